@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
 	"github.com/manno/baca/internal/backend/k8s"
 	"github.com/manno/baca/internal/change"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var applyCmd = &cobra.Command{
@@ -11,13 +18,75 @@ var applyCmd = &cobra.Command{
 	Short: "Apply a Change definition",
 	Long: `Read a Change definition and execute it.
 Creates one job per repository defined in the Change.
-Monitors job status and reports when all jobs are done.`,
+Monitors job status and reports when all jobs are done.
+
+Repositories on github.com, gitlab.com and codeberg.org are supported out of
+the box. Map self-hosted instances to a forge in the config file:
+
+  forges:
+    gitlab.example.com: gitlab
+    git.example.com: forgejo
+
+Use --dry-run=client to render the job manifests without contacting the
+cluster, or --dry-run=server to have the API server validate them without
+persisting anything. Rendered manifests are printed with -o yaml|json or
+written to --output-dir.`,
 	Args:         cobra.ExactArgs(1),
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		logger := GetLogger()
 		changeFile := args[0]
 
+		kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		wait, _ := cmd.Flags().GetBool("wait")
+		retries, _ := cmd.Flags().GetInt32("retries")
+		forkOrg, _ := cmd.Flags().GetString("fork-org")
+		dryRun, _ := cmd.Flags().GetString("dry-run")
+		output, _ := cmd.Flags().GetString("output")
+		outputDir, _ := cmd.Flags().GetString("output-dir")
+		publishMode, _ := cmd.Flags().GetString("publish-mode")
+		coAuthors, _ := cmd.Flags().GetStringArray("co-author")
+		noCoAuthor, _ := cmd.Flags().GetBool("no-co-author")
+		runtimeClass, _ := cmd.Flags().GetString("runtime-class")
+		profile, _ := cmd.Flags().GetString("profile")
+		if err := k8s.ValidateProfile(profile); err != nil {
+			return err
+		}
+
+		opts, err := jobOptions()
+		if err != nil {
+			return err
+		}
+		opts.Wait = wait
+		opts.Retries = retries
+		opts.ForkOrg = forkOrg
+		switch dryRun {
+		case "none":
+		case "client":
+			opts.DryRun = k8s.DryRunClient
+		case "server":
+			opts.DryRun = k8s.DryRunServer
+		default:
+			return fmt.Errorf("invalid --dry-run value %q: must be none, client or server", dryRun)
+		}
+
+		if output != "" && output != "yaml" && output != "json" {
+			return fmt.Errorf("invalid --output value %q: must be yaml or json", output)
+		}
+		if (output != "" || outputDir != "") && opts.DryRun == k8s.DryRunNone {
+			return fmt.Errorf("--output and --output-dir require --dry-run")
+		}
+		// Client dry runs print manifests by default, server dry runs only when asked
+		printManifests := outputDir == "" && (output != "" || opts.DryRun == k8s.DryRunClient)
+		if output == "" {
+			output = "yaml"
+		}
+		if printManifests {
+			// Keep stdout clean for the manifests
+			useStderrLogger()
+		}
+
+		logger := GetLogger()
 		logger.Info("applying change", "file", changeFile)
 
 		ch, err := change.LoadFromFile(changeFile)
@@ -26,37 +95,123 @@ Monitors job status and reports when all jobs are done.`,
 			return err
 		}
 
-		logger.Info("loaded change", "repos", len(ch.Spec.Repos), "agent", ch.Spec.Agent)
+		if publishMode != "" {
+			mode, err := change.ParsePublishMode(publishMode)
+			if err != nil {
+				return err
+			}
+			ch.Spec.Publish.Mode = mode
+		}
 
-		kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
-		namespace, _ := cmd.Flags().GetString("namespace")
-		wait, _ := cmd.Flags().GetBool("wait")
-		retries, _ := cmd.Flags().GetInt32("retries")
-		forkOrg, _ := cmd.Flags().GetString("fork-org")
+		if runtimeClass != "" {
+			opts.Security.RuntimeClassName = runtimeClass
+		}
 
-		cfg, err := k8s.GetConfig(kubeconfig)
-		if err != nil {
-			logger.Error("failed to get kubernetes config", "error", err)
+		// Commit defaults from the config file, and the person applying the
+		// change: --co-author, or else the user from the git config
+		var commitDefaults change.CommitSpec
+		if err := viper.UnmarshalKey("commit", &commitDefaults); err != nil {
+			return fmt.Errorf("invalid commit config: %w", err)
+		}
+		if len(coAuthors) == 0 && !noCoAuthor {
+			if coAuthor := gitCoAuthor(cmd.Context()); coAuthor != "" {
+				logger.Info("crediting the git user as co-author", "co-author", coAuthor)
+				coAuthors = append(coAuthors, coAuthor)
+			}
+		}
+		for _, coAuthor := range coAuthors {
+			if !slices.Contains(commitDefaults.CoAuthors, coAuthor) && !slices.Contains(ch.Spec.Commit.CoAuthors, coAuthor) {
+				commitDefaults.CoAuthors = append(commitDefaults.CoAuthors, coAuthor)
+			}
+		}
+		ch.Spec.Commit.Merge(commitDefaults)
+		if err := change.ValidateCommit(ch.Spec.Commit); err != nil {
 			return err
 		}
 
-		b, err := k8s.New(cfg, namespace, logger)
-		if err != nil {
-			logger.Error("failed to create backend", "error", err)
-			return err
+		logger.Info("loaded change", "publish-mode", ch.Spec.Publish.Mode, "repos", len(ch.Spec.Repos), "agent", ch.Spec.Agent)
+
+		var b *k8s.KubernetesBackend
+		if opts.DryRun == k8s.DryRunClient {
+			b = k8s.NewOffline(namespace, logger)
+		} else {
+			cfg, err := k8s.GetConfig(kubeconfig)
+			if err != nil {
+				logger.Error("failed to get kubernetes config", "error", err)
+				return err
+			}
+
+			b, err = k8s.New(cfg, namespace, logger)
+			if err != nil {
+				logger.Error("failed to create backend", "error", err)
+				return err
+			}
 		}
 
 		ctx := cmd.Context()
-		if err := b.ApplyChange(ctx, ch, wait, retries, forkOrg); err != nil {
+		jobs, err := b.WithProfile(profile).ApplyChange(ctx, ch, opts)
+		if err != nil {
 			logger.Error("failed to apply change", "error", err)
 			return err
 		}
 
+		if outputDir != "" {
+			if err := k8s.WriteManifestsToDir(outputDir, jobs, output); err != nil {
+				logger.Error("failed to write manifests", "error", err)
+				return err
+			}
+			logger.Info("manifests written", "dir", outputDir, "count", len(jobs))
+		} else if printManifests {
+			if err := k8s.WriteManifests(cmd.OutOrStdout(), jobs, output); err != nil {
+				logger.Error("failed to write manifests", "error", err)
+				return err
+			}
+		}
+
 		logger.Info("apply completed")
 		return nil
 	},
 }
 
+// jobOptions returns the options for the jobs from the config file: forges,
+// security, network and tracing.
+func jobOptions() (k8s.ApplyOptions, error) {
+	opts := k8s.ApplyOptions{
+		Forges: viper.GetStringMapString("forges"),
+		// The jobs continue the trace of apply
+		TracingEndpoint: tracingConfig.JobEndpointOrDefault(),
+	}
+	if err := viper.UnmarshalKey("security", &opts.Security); err != nil {
+		return opts, fmt.Errorf("invalid security config: %w", err)
+	}
+	if err := viper.UnmarshalKey("network", &opts.Network); err != nil {
+		return opts, fmt.Errorf("invalid network config: %w", err)
+	}
+	if err := change.ValidateNetwork(opts.Network.NetworkSpec); err != nil {
+		return opts, fmt.Errorf("invalid network config: %w", err)
+	}
+	return opts, nil
+}
+
+// gitCoAuthor returns "Name <email>" of the user in the git config, or "" if
+// either isn't set.
+func gitCoAuthor(ctx context.Context) string {
+	var values []string
+	for _, key := range []string{"user.name", "user.email"} {
+		out, err := exec.CommandContext(ctx, "git", "config", "--get", key).Output()
+		value := strings.TrimSpace(string(out))
+		if err != nil || value == "" {
+			return ""
+		}
+		values = append(values, value)
+	}
+	coAuthor := fmt.Sprintf("%s <%s>", values[0], values[1])
+	if change.ValidateCommit(change.CommitSpec{CoAuthors: []string{coAuthor}}) != nil {
+		return ""
+	}
+	return coAuthor
+}
+
 func init() {
 	rootCmd.AddCommand(applyCmd)
 
@@ -65,4 +220,13 @@ func init() {
 	applyCmd.Flags().Bool("wait", true, "wait for jobs to complete")
 	applyCmd.Flags().Int32("retries", 0, "number of times to retry failed jobs (BackoffLimit)")
 	applyCmd.Flags().String("fork-org", "", "GitHub organization/user to create forks under (default: authenticated user)")
+	applyCmd.Flags().String("publish-mode", "", "override spec.publish.mode: fork (push to a staging fork) or branch (push to the repository itself)")
+	applyCmd.Flags().StringArray("co-author", nil, "add a Co-authored-by trailer to the commits, e.g. \"Jane Doe <jane@example.com>\" (repeatable, default: user.name and user.email from the git config)")
+	applyCmd.Flags().Bool("no-co-author", false, "don't credit the git user with a Co-authored-by trailer")
+	applyCmd.Flags().String("profile", "", "use the credentials of a profile created with baca setup --profile")
+	applyCmd.Flags().String("runtime-class", "", "run the jobs' pods with a RuntimeClass for sandboxing, e.g. gvisor (overrides security.runtimeClassName)")
+	applyCmd.Flags().String("dry-run", "none", "render jobs without creating them: none, client (no cluster access) or server (validate against the API server)")
+	applyCmd.Flags().Lookup("dry-run").NoOptDefVal = "client"
+	applyCmd.Flags().StringP("output", "o", "", "print rendered manifests in this format (yaml or json), requires --dry-run")
+	applyCmd.Flags().String("output-dir", "", "write rendered manifests to this directory, one file per job, requires --dry-run")
 }
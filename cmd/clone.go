@@ -1,50 +1,111 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/publish"
 	"github.com/spf13/cobra"
 )
 
 var cloneCmd = &cobra.Command{
-	Use:          "clone [repo-url]",
-	Short:        "Clone a git repository",
-	Long:         `Clone a git repository in the execution backend using fleet gitcloner.`,
-	Args:         cobra.ExactArgs(1),
+	Use:   "clone",
+	Short: "Clone the staging fork of a repository",
+	// Only used by the job's git-clone container
+	Hidden: true,
+	Long: `Clone the staging fork of a repository.
+This runs as the second init container of a Kubernetes job. It reads the fork
+URL written by 'baca fork-setup' and clones the branch with the credentials
+for the fork's host, e.g. GITHUB_TOKEN_GHE_EXAMPLE_COM for a GitHub
+Enterprise Server at ghe.example.com. GITHUB_TOKEN, GITLAB_TOKEN and
+GITEA_TOKEN, depending on --forge, are only used for github.com, gitlab.com
+and codeberg.org. With GitHub App authentication the token is scoped to the
+fork.
+
+The branch is cloned as a bare repository into --git-dir, on a volume which
+only the clone and publish containers mount, and copied into --dir for the
+agent. 'baca publish' commits from --git-dir, so changes to the agent's
+.git, like hooks, don't run with the forge credentials.
+
+With --upstream-url the fork's branch is fast-forwarded to the upstream
+repository's branch, for forges which can't sync forks like GitLab. A fork
+which diverged from upstream is cloned as is.
+
+With --repo-url the repository is cloned directly, for the branch publish
+mode which doesn't use a fork.`,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := GetLogger()
-		repoURL := args[0]
 
-		logger.Info("cloning repository", "url", repoURL)
-
-		output, _ := cmd.Flags().GetString("output")
+		repoURL, _ := cmd.Flags().GetString("repo-url")
+		upstreamURL, _ := cmd.Flags().GetString("upstream-url")
+		forkURLFile, _ := cmd.Flags().GetString("fork-url-file")
 		branch, _ := cmd.Flags().GetString("branch")
+		dir, _ := cmd.Flags().GetString("dir")
+		gitDir, _ := cmd.Flags().GetString("git-dir")
+		forgeName, _ := cmd.Flags().GetString("forge")
+
+		if repoURL == "" {
+			forkURL, err := os.ReadFile(forkURLFile)
+			if err != nil {
+				return fmt.Errorf("failed to read fork URL: %w", err)
+			}
+			repoURL = strings.TrimSpace(string(forkURL))
+		}
+		repo, err := forge.ParseRepoURL(repoURL)
+		if err != nil {
+			logger.Error("failed to parse repository URL", "error", err)
+			return err
+		}
 
-		// Use fleet gitcloner
-		fleetArgs := []string{"gitcloner", repoURL, output}
-		if branch != "" {
-			fleetArgs = append(fleetArgs, "--branch", branch)
+		var upstream *forge.Repo
+		if upstreamURL != "" {
+			parsed, err := forge.ParseRepoURL(upstreamURL)
+			if err != nil {
+				logger.Error("failed to parse upstream URL", "error", err)
+				return err
+			}
+			upstream = &parsed
 		}
 
-		fleetCmd := exec.Command("fleet", fleetArgs...)
-		fleetCmd.Stdout = os.Stdout
-		fleetCmd.Stderr = os.Stderr
+		kind, err := forge.ParseKind(forgeName)
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		client, err := publish.NewProvider(ctx, kind, repo, repo.Owner)
+		if err != nil {
+			logger.Error("failed to create forge client", "error", err)
+			return err
+		}
 
-		if err := fleetCmd.Run(); err != nil {
+		if err := publish.Clone(ctx, client, logger, publish.CloneOptions{
+			Repo:     repo,
+			Branch:   branch,
+			Upstream: upstream,
+			Dir:      dir,
+			GitDir:   gitDir,
+		}); err != nil {
 			logger.Error("clone failed", "error", err)
 			return err
 		}
 
-		logger.Info("clone completed")
+		logger.Info("clone completed", "dir", dir)
 		return nil
 	},
 }
 
 func init() {
+	cloneCmd.RunE = withSpan("clone", withFailureClass(cloneCmd.RunE))
 	rootCmd.AddCommand(cloneCmd)
 
-	cloneCmd.Flags().String("output", ".", "output directory")
-	cloneCmd.Flags().String("branch", "", "branch to clone")
+	cloneCmd.Flags().String("repo-url", "", "repository to clone instead of the fork, for branch publish mode")
+	cloneCmd.Flags().String("upstream-url", "", "repository the fork was created from, to fast-forward the fork's branch to")
+	cloneCmd.Flags().String("fork-url-file", "/workspace/fork-url.txt", "file containing the fork URL written by fork-setup")
+	cloneCmd.Flags().String("branch", "main", "branch to clone")
+	cloneCmd.Flags().String("dir", "/workspace/repo", "directory to clone into")
+	cloneCmd.Flags().String("git-dir", "/var/run/baca/git/repo.git", "bare repository for 'baca publish', outside of the agent's volumes")
+	cloneCmd.Flags().String("forge", string(forge.GitHub), "forge hosting the repository (github, gitlab, gitea)")
 }
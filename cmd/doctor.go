@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/preflight"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the credentials and the cluster are ready for jobs",
+	Long: `Check the credentials and the cluster are ready for jobs.
+Runs the preflight checks of baca setup against the stored credentials:
+
+  - the forge tokens authenticate, have the required scopes and can fork
+    into --fork-org
+  - the Copilot token and Gemini API key or OAuth credentials are valid
+  - the current user may create jobs and read pods and their logs in the
+    namespace
+  - the runner image can be pulled
+
+Referenced credentials are read from their secrets, credentials injected
+from Vault can't be checked. Prints a table of the results and fails if any
+check failed. Warnings are for checks which couldn't be verified, or which
+only affect some jobs.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Keep stdout clean for the table
+		useStderrLogger()
+		logger := GetLogger()
+
+		kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		profile, _ := cmd.Flags().GetString("profile")
+		forkOrg, _ := cmd.Flags().GetString("fork-org")
+		image, _ := cmd.Flags().GetString("image")
+		if err := k8s.ValidateProfile(profile); err != nil {
+			return err
+		}
+
+		cfg, err := k8s.GetConfig(kubeconfig)
+		if err != nil {
+			logger.Error("failed to get kubernetes config", "error", err)
+			return err
+		}
+
+		backend, err := k8s.New(cfg, namespace, logger)
+		if err != nil {
+			logger.Error("failed to create backend", "error", err)
+			return err
+		}
+		backend = backend.WithProfile(profile)
+
+		ctx := cmd.Context()
+		var credentials map[string]string
+		report := backend.Preflight(ctx)
+		if credentials, err = backend.Credentials(ctx); err != nil {
+			report = append(report, preflight.Failf("credentials", "%v", err))
+		}
+		report = append(report, runPreflight(ctx, credentials, viper.GetStringMapString("forges"), forkOrg, image)...)
+
+		if err := report.Print(cmd.OutOrStdout()); err != nil {
+			return err
+		}
+		if report.Failed() {
+			return fmt.Errorf("preflight checks failed: %s", report)
+		}
+		return nil
+	},
+}
+
+// runPreflight checks the credentials, unless they are nil, and the runner
+// image.
+func runPreflight(ctx context.Context, credentials, forges map[string]string, forkOrg, image string) preflight.Report {
+	var report preflight.Report
+	if credentials != nil {
+		report = preflight.CheckCredentials(ctx, preflight.Options{
+			Credentials: credentials,
+			Forges:      forges,
+			ForkOrg:     forkOrg,
+		})
+	}
+	return append(report, preflight.Image(ctx, nil, image))
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().String("kubeconfig", "", "path to kubeconfig file")
+	doctorCmd.Flags().String("namespace", "default", "kubernetes namespace")
+	doctorCmd.Flags().String("profile", "", "check the credentials of a profile created with baca setup --profile")
+	doctorCmd.Flags().String("fork-org", "", "check the forge user can fork into this organization")
+	doctorCmd.Flags().String("image", k8s.DefaultImage, "runner image to check")
+}
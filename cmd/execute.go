@@ -51,6 +51,7 @@ Accepts a JSON config string with agent, prompt, and resources.`,
 }
 
 func init() {
+	executeCmd.RunE = withSpan("execute", withFailureClass(executeCmd.RunE))
 	rootCmd.AddCommand(executeCmd)
 
 	executeCmd.Flags().String("work-dir", ".", "working directory")
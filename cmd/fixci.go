@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/followup"
+	"github.com/manno/baca/internal/forge"
+	"github.com/spf13/cobra"
+)
+
+var fixCICmd = &cobra.Command{
+	Use:   "fix-ci RUN",
+	Short: "Launch jobs fixing the failing CI checks of a run's pull requests",
+	Long: `Launch jobs fixing the failing CI checks of the pull requests created by a
+run. For each open pull request with failed checks, a job runs the agent on
+its head branch with the end of the failing checks' logs, and pushes the fix
+to the pull request. The jobs are recorded as a follow-up run in the history.
+
+A pull request is fixed at most --max-attempts times, and only once per head
+commit: if a fix made no changes or failed, it isn't retried until the
+branch changes.
+
+With --watch the run's pull requests are checked again every interval, and
+fixes are launched as checks fail, until none is open anymore.
+
+The forges are queried with the tokens from the environment, like baca prs.
+The jobs use the credentials of the run's profile.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFollowUps(cmd, args[0], k8s.FollowUpCI, ciFollowUps{})
+	},
+}
+
+// ciFollowUps fixes the failing checks of pull requests.
+type ciFollowUps struct{}
+
+func (ciFollowUps) Pending(_ context.Context, _ forge.Provider, _ forge.Repo, status *forge.PullRequestStatus) (bool, error) {
+	return status.CheckState() == forge.CheckFailure, nil
+}
+
+func (ciFollowUps) FollowUp(ctx context.Context, client forge.Provider, upstream forge.Repo, status *forge.PullRequestStatus, original string) (k8s.FollowUp, error) {
+	logger := GetLogger()
+	var logs []followup.CheckLog
+	for _, check := range status.FailedChecks() {
+		log, err := client.CheckLog(ctx, upstream, check)
+		if err != nil {
+			logger.Info("no log for failing check", "url", status.URL, "check", check.Name, "error", err)
+		}
+		logs = append(logs, followup.CheckLog{Check: check, Log: log})
+	}
+	logger.Info("fixing failing checks", "url", status.URL, "checks", len(logs))
+	return k8s.FollowUp{
+		Prompt: followup.CIPrompt(original, k8s.FollowUpDir, logs),
+		Files:  map[string]string{followup.CILogsFile: followup.CILogs(logs)},
+	}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(fixCICmd)
+	addFollowUpFlags(fixCICmd, "fix")
+}
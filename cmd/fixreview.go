@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/followup"
+	"github.com/manno/baca/internal/forge"
+	"github.com/spf13/cobra"
+)
+
+var fixReviewCmd = &cobra.Command{
+	Use:   "fix-review RUN",
+	Short: "Launch jobs addressing the review comments on a run's pull requests",
+	Long: `Launch jobs addressing the unresolved review comments on the pull requests
+created by a run. For each open pull request with unanswered review threads,
+a job runs the agent on its head branch with the comments as prompt, pushes
+the changes to the pull request and replies to each thread with the pushed
+commit and a summary of the changes. The jobs are recorded as a follow-up run
+in the history.
+
+Only comments by users with write access to the repository are addressed,
+or with --reviewer only comments by those users. Comments by others are
+neither passed to the agent nor make a thread pending.
+
+Threads whose last comment is such a reply are answered already, a new
+comment by a reviewer makes them pending again. Only replies by the user of
+the forge token count, not a copy of one in anyone else's comment. A pull
+request is followed up at most --max-attempts times, and only once per head
+commit: if the agent made no changes or failed, the comments aren't
+addressed again until the branch changes.
+
+With --watch the run's pull requests are checked again every interval, until
+none is open anymore.
+
+Review threads are supported on GitHub and GitLab. The forges are queried
+with the tokens from the environment, like baca prs. The jobs use the
+credentials of the run's profile.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reviewers, _ := cmd.Flags().GetStringSlice("reviewer")
+		source := reviewFollowUps{
+			threads:    map[string][]forge.ReviewThread{},
+			reviewers:  reviewers,
+			canPush:    map[string]bool{},
+			publishers: map[string]string{},
+		}
+		return runFollowUps(cmd, args[0], k8s.FollowUpReview, source)
+	},
+}
+
+// reviewFollowUps addresses the unanswered review threads of pull requests.
+type reviewFollowUps struct {
+	// threads found by Pending, by pull request URL
+	threads map[string][]forge.ReviewThread
+	// reviewers whose comments are addressed, if empty those of users with
+	// write access
+	reviewers []string
+	// canPush caches the write access of authors, by repository and author
+	canPush map[string]bool
+	// publishers caches the user replying to the threads, by forge host
+	publishers map[string]string
+}
+
+func (r reviewFollowUps) Pending(ctx context.Context, client forge.Provider, upstream forge.Repo, status *forge.PullRequestStatus) (bool, error) {
+	threads, err := client.ReviewThreads(ctx, upstream, status.Number)
+	if err != nil {
+		return false, err
+	}
+	publisher, ok := r.publishers[upstream.Host]
+	if !ok {
+		if publisher, err = client.CurrentUser(ctx); err != nil {
+			return false, fmt.Errorf("failed to get the user replying to review threads: %w", err)
+		}
+		r.publishers[upstream.Host] = publisher
+	}
+	r.threads[status.URL] = followup.Unanswered(threads, publisher, func(author string) bool {
+		return r.trusted(ctx, client, upstream, author)
+	})
+	return len(r.threads[status.URL]) > 0, nil
+}
+
+// trusted reports whether the author's comments are addressed. Failing to
+// look up the author's access is logged and ignores the comments.
+func (r reviewFollowUps) trusted(ctx context.Context, client forge.Provider, upstream forge.Repo, author string) bool {
+	if len(r.reviewers) > 0 {
+		return slices.Contains(r.reviewers, author)
+	}
+	key := upstream.String() + "/" + author
+	if canPush, ok := r.canPush[key]; ok {
+		return canPush
+	}
+	canPush, err := client.CanPush(ctx, upstream, author)
+	if err != nil {
+		GetLogger().Warn("ignoring review comments, failed to look up the author's access", "repo", upstream.String(), "author", author, "error", err)
+	}
+	r.canPush[key] = canPush
+	return canPush
+}
+
+func (r reviewFollowUps) FollowUp(_ context.Context, _ forge.Provider, _ forge.Repo, status *forge.PullRequestStatus, original string) (k8s.FollowUp, error) {
+	threads := r.threads[status.URL]
+	data, err := followup.ReviewThreadsJSON(threads)
+	if err != nil {
+		return k8s.FollowUp{}, err
+	}
+	GetLogger().Info("addressing review comments", "url", status.URL, "threads", len(threads))
+	return k8s.FollowUp{
+		Prompt:        followup.ReviewPrompt(original, threads),
+		Files:         map[string]string{followup.ReviewThreadsFile: data},
+		ReviewThreads: followup.ReviewThreadsFile,
+	}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(fixReviewCmd)
+	addFollowUpFlags(fixReviewCmd, "follow up")
+	fixReviewCmd.Flags().StringSlice("reviewer", nil, "only address comments by these users, instead of those by users with write access (repeatable)")
+}
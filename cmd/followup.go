@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/forge"
+	"github.com/spf13/cobra"
+)
+
+// followUpSource finds what a follow-up of a kind addresses in the pull
+// requests of a run.
+type followUpSource interface {
+	// Pending tells whether an open pull request needs a follow-up.
+	Pending(ctx context.Context, client forge.Provider, upstream forge.Repo, status *forge.PullRequestStatus) (bool, error)
+	// FollowUp builds the follow-up of a pending pull request created for
+	// the original prompt.
+	FollowUp(ctx context.Context, client forge.Provider, upstream forge.Repo, status *forge.PullRequestStatus, original string) (k8s.FollowUp, error)
+}
+
+// runFollowUps launches follow-up runs of kind for the run in args, with the
+// flags added by addFollowUpFlags. With --watch it repeats until none of
+// the run's pull requests is open anymore.
+func runFollowUps(cmd *cobra.Command, runID, kind string, source followUpSource) error {
+	logger := GetLogger()
+	maxAttempts, _ := cmd.Flags().GetInt("max-attempts")
+	watch, _ := cmd.Flags().GetDuration("watch")
+	wait, _ := cmd.Flags().GetBool("wait")
+	retries, _ := cmd.Flags().GetInt32("retries")
+
+	opts, err := jobOptions()
+	if err != nil {
+		return err
+	}
+	opts.Wait = wait && watch == 0
+	opts.Retries = retries
+
+	backend, err := historyBackend(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	for {
+		open, err := launchFollowUps(ctx, backend, runID, kind, source, opts, maxAttempts)
+		if err != nil {
+			logger.Error("failed to follow up run", "run", runID, "kind", kind, "error", err)
+			return err
+		}
+		if watch == 0 {
+			return nil
+		}
+		if open == 0 {
+			logger.Info("no open pull requests left", "run", runID)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(watch):
+		}
+	}
+}
+
+// launchFollowUps launches a follow-up run for the run's open pull requests
+// pending for source, and returns the number of open pull requests.
+func launchFollowUps(ctx context.Context, backend *k8s.KubernetesBackend, runID, kind string, source followUpSource, opts k8s.ApplyOptions, maxAttempts int) (int, error) {
+	logger := GetLogger()
+	run, c, attempts, err := followUpRun(ctx, backend, runID, kind)
+	if err != nil {
+		return 0, err
+	}
+
+	open := 0
+	followUps := map[string]k8s.FollowUp{}
+	var repos []string
+	for _, repo := range run.Repos {
+		if repo.PullRequestURL == "" {
+			continue
+		}
+		client, upstream, err := forgeClient(ctx, repo.Repo, opts.Forges)
+		if err != nil {
+			logger.Warn("skipping pull request", "url", repo.PullRequestURL, "error", err)
+			continue
+		}
+		number, err := forge.PullRequestNumber(repo.PullRequestURL)
+		if err != nil {
+			logger.Warn("skipping pull request", "url", repo.PullRequestURL, "error", err)
+			continue
+		}
+		status, err := client.PullRequestStatus(ctx, upstream, number)
+		if err != nil {
+			logger.Warn("failed to get pull request status", "url", repo.PullRequestURL, "error", err)
+			continue
+		}
+		if status.State != forge.StateOpen {
+			continue
+		}
+		open++
+		pending, err := source.Pending(ctx, client, upstream, status)
+		if err != nil {
+			logger.Warn("skipping pull request", "url", repo.PullRequestURL, "error", err)
+			continue
+		}
+		if !pending {
+			continue
+		}
+		if reason := attempts.skip(repo.PullRequestURL, status.HeadSHA, maxAttempts); reason != "" {
+			logger.Info("not following up pull request", "url", repo.PullRequestURL, "reason", reason)
+			continue
+		}
+		if status.Head == (forge.Repo{}) {
+			logger.Warn("not following up pull request, its head repository is gone", "url", repo.PullRequestURL)
+			continue
+		}
+
+		f, err := source.FollowUp(ctx, client, upstream, status, c.Spec.Prompt)
+		if err != nil {
+			logger.Warn("skipping pull request", "url", repo.PullRequestURL, "error", err)
+			continue
+		}
+		f.PullRequestURL = repo.PullRequestURL
+		f.HeadURL = status.Head.URL()
+		f.HeadBranch = status.HeadBranch
+		f.HeadSHA = status.HeadSHA
+		followUps[repo.Repo] = f
+		repos = append(repos, repo.Repo)
+	}
+
+	if len(repos) == 0 {
+		logger.Info("no pull requests to follow up", "run", runID, "kind", kind, "open", open)
+		return open, nil
+	}
+	c.Spec.Repos = repos
+	opts.FollowUp = &k8s.FollowUpOptions{Kind: kind, Run: runID, PullRequests: followUps}
+	if _, err := backend.WithProfile(run.Profile).ApplyChange(ctx, c, opts); err != nil {
+		return open, err
+	}
+	return open, nil
+}
+
+// followUpAttempts counts the earlier follow-ups of a kind, by pull request.
+type followUpAttempts struct {
+	count map[string]int
+	// heads are the pull requests' head commits followed up
+	heads   map[string]bool
+	running map[string]bool
+}
+
+// skip returns why a pull request isn't followed up again, or "".
+func (a followUpAttempts) skip(prURL, headSHA string, maxAttempts int) string {
+	switch {
+	case a.running[prURL]:
+		return "a follow-up is running"
+	case a.heads[prURL+"@"+headSHA]:
+		return "its head commit was followed up already"
+	case maxAttempts > 0 && a.count[prURL] >= maxAttempts:
+		return fmt.Sprintf("followed up %d times already", a.count[prURL])
+	}
+	return ""
+}
+
+// followUpRun returns a run, the change it applied and the attempts of its
+// follow-up runs of kind.
+func followUpRun(ctx context.Context, backend *k8s.KubernetesBackend, runID, kind string) (*k8s.Run, *change.Change, followUpAttempts, error) {
+	attempts := followUpAttempts{count: map[string]int{}, heads: map[string]bool{}, running: map[string]bool{}}
+	run, err := backend.GetRun(ctx, runID)
+	if err != nil {
+		return nil, nil, attempts, err
+	}
+	if run.Parent != "" {
+		return nil, nil, attempts, fmt.Errorf("run %s is a follow-up of %s, use that run", runID, run.Parent)
+	}
+	c, err := backend.GetRunChange(ctx, runID)
+	if err != nil {
+		return nil, nil, attempts, err
+	}
+
+	followUps, err := backend.History(ctx, k8s.HistoryFilter{Parent: runID})
+	if err != nil {
+		return nil, nil, attempts, err
+	}
+	for _, f := range followUps {
+		if f.FollowUp != kind {
+			continue
+		}
+		for _, repo := range f.Repos {
+			attempts.count[repo.PullRequestURL]++
+			attempts.heads[repo.PullRequestURL+"@"+repo.HeadSHA] = true
+			if repo.Outcome == k8s.OutcomeRunning {
+				attempts.running[repo.PullRequestURL] = true
+			}
+		}
+	}
+	return run, c, attempts, nil
+}
+
+// addFollowUpFlags adds the flags read by runFollowUps.
+func addFollowUpFlags(cmd *cobra.Command, what string) {
+	cmd.Flags().String("kubeconfig", "", "path to kubeconfig file")
+	cmd.Flags().String("namespace", "default", "kubernetes namespace")
+	cmd.Flags().Int("max-attempts", 2, what+" a pull request at most this many times, 0 for no limit")
+	cmd.Flags().Duration("watch", 0, "check the pull requests again at this interval until none is open, e.g. 10m")
+	cmd.Flags().Bool("wait", false, "wait for the jobs to complete, not with --watch")
+	cmd.Flags().Int32("retries", 0, "number of times to retry failed jobs (BackoffLimit)")
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/publish"
+	"github.com/spf13/cobra"
+)
+
+var forkSetupCmd = &cobra.Command{
+	Use:   "fork-setup",
+	Short: "Create or sync the staging fork of a repository",
+	Long: `Create or sync the staging fork of a repository.
+This runs as the first init container of a Kubernetes job. It creates a fork
+in the fork organization (or the authenticated user's account), syncs an
+existing fork with upstream and writes the fork URL to the output file for
+the clone step.
+
+Fails if a repository with the same name exists but is not a fork.
+Authenticates with GITHUB_TOKEN, GITLAB_TOKEN or GITEA_TOKEN, depending on
+--forge, or the token for a self-hosted forge's host, e.g.
+GITLAB_TOKEN_GITLAB_EXAMPLE_COM, or as the GitHub App from GITHUB_APP_ID
+and GITHUB_APP_PRIVATE_KEY, which requires --fork-org.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := GetLogger()
+
+		repoURL, _ := cmd.Flags().GetString("repo-url")
+		forkOrg, _ := cmd.Flags().GetString("fork-org")
+		branch, _ := cmd.Flags().GetString("branch")
+		output, _ := cmd.Flags().GetString("output")
+		forgeName, _ := cmd.Flags().GetString("forge")
+
+		upstream, err := forge.ParseRepoURL(repoURL)
+		if err != nil {
+			logger.Error("failed to parse repository URL", "error", err)
+			return err
+		}
+
+		kind, err := forge.ParseKind(forgeName)
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		client, err := publish.NewProvider(ctx, kind, upstream, forkOrg)
+		if err != nil {
+			logger.Error("failed to create forge client", "error", err)
+			return err
+		}
+
+		fork, err := publish.NewForkSetup(client, logger).Run(ctx, upstream, forkOrg, branch)
+		if err != nil {
+			logger.Error("fork setup failed", "error", err)
+			return err
+		}
+
+		// Read by the git-clone init container
+		if err := os.WriteFile(output, []byte(fork.URL()+"\n"), 0600); err != nil {
+			return fmt.Errorf("failed to write fork URL: %w", err)
+		}
+
+		logger.Info("fork ready", "url", fork.URL())
+		return nil
+	},
+}
+
+func init() {
+	forkSetupCmd.RunE = withSpan("fork-setup", withFailureClass(forkSetupCmd.RunE))
+	rootCmd.AddCommand(forkSetupCmd)
+
+	forkSetupCmd.Flags().String("repo-url", "", "URL of the repository to fork")
+	forkSetupCmd.Flags().String("fork-org", "", "organization/user to create the fork under (default: authenticated user)")
+	forkSetupCmd.Flags().String("branch", "main", "branch to sync from upstream")
+	forkSetupCmd.Flags().String("output", "/workspace/fork-url.txt", "file to write the fork URL to")
+	forkSetupCmd.Flags().String("forge", string(forge.GitHub), "forge hosting the repository (github, gitlab, gitea)")
+
+	_ = forkSetupCmd.MarkFlagRequired("repo-url")
+}
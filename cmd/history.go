@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/metrics"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the runs of baca apply",
+	Long: `List the runs of baca apply, the newest first.
+Each apply records a run in a ConfigMap in the namespace, which outlives the
+jobs: the change name and content hash, who ran it, when, and the outcome and
+pull request of each repository. Outcomes are recorded when apply --wait
+finishes, when baca metrics sees the jobs finish, or when the history is
+read while the jobs still exist. Jobs deleted before are listed as unknown.
+
+Outcomes are running, pr-created, pr-updated, no-changes, failed and
+unknown. Runs submitted by other users are not listed.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Keep stdout clean for the table
+		useStderrLogger()
+
+		filter := k8s.HistoryFilter{}
+		filter.Change, _ = cmd.Flags().GetString("change")
+		filter.Repo, _ = cmd.Flags().GetString("repo")
+		filter.Outcome, _ = cmd.Flags().GetString("outcome")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		backend, err := historyBackend(cmd)
+		if err != nil {
+			return err
+		}
+		runs, err := backend.History(cmd.Context(), filter)
+		if err != nil {
+			GetLogger().Error("failed to read history", "error", err)
+			return err
+		}
+		if limit > 0 && len(runs) > limit {
+			runs = runs[:limit]
+		}
+		return printRuns(cmd.OutOrStdout(), runs)
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:          "show RUN",
+	Short:        "Show the repositories and outcomes of a run",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		useStderrLogger()
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "" && output != "json" {
+			return fmt.Errorf("invalid --output value %q: must be json", output)
+		}
+
+		backend, err := historyBackend(cmd)
+		if err != nil {
+			return err
+		}
+		run, err := backend.GetRun(cmd.Context(), args[0])
+		if err != nil {
+			GetLogger().Error("failed to get run", "run", args[0], "error", err)
+			return err
+		}
+		if output == "json" {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(run)
+		}
+		return printRun(cmd.OutOrStdout(), run)
+	},
+}
+
+var historyPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old runs from the history",
+	Long: `Delete the ConfigMaps of old runs from the history. Runs beyond the newest
+--keep runs or older than --older-than are deleted, once they are finished.
+Follow-up runs count with the run they follow up, and are deleted along
+with it. Runs submitted by other users are kept.
+
+Run it regularly, e.g. in a CronJob, to keep the number of ConfigMaps in the
+namespace bounded.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := GetLogger()
+		keep, _ := cmd.Flags().GetInt("keep")
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if keep <= 0 && olderThan <= 0 {
+			return fmt.Errorf("--keep or --older-than is required")
+		}
+		var before time.Time
+		if olderThan > 0 {
+			before = time.Now().Add(-olderThan)
+		}
+
+		backend, err := historyBackend(cmd)
+		if err != nil {
+			return err
+		}
+		pruned, err := backend.PruneHistory(cmd.Context(), keep, before, dryRun)
+		for _, id := range pruned {
+			logger.Info("pruned run", "run", id, "dry-run", dryRun)
+		}
+		if err != nil {
+			logger.Error("failed to prune history", "error", err)
+			return err
+		}
+		logger.Info("history pruned", "runs", len(pruned), "dry-run", dryRun)
+		return nil
+	},
+}
+
+func historyBackend(cmd *cobra.Command) (*k8s.KubernetesBackend, error) {
+	logger := GetLogger()
+	kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+	namespace, _ := cmd.Flags().GetString("namespace")
+
+	cfg, err := k8s.GetConfig(kubeconfig)
+	if err != nil {
+		logger.Error("failed to get kubernetes config", "error", err)
+		return nil, err
+	}
+	backend, err := k8s.New(cfg, namespace, logger)
+	if err != nil {
+		logger.Error("failed to create backend", "error", err)
+		return nil, err
+	}
+	return backend, nil
+}
+
+func printRuns(w io.Writer, runs []*k8s.Run) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tCHANGE\tCREATED\tSUBMITTED BY\tREPOS\tOUTCOMES")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			run.ID, run.Change, run.Created.Local().Format(time.DateTime), run.SubmittedBy, len(run.Repos), outcomeSummary(run))
+	}
+	return tw.Flush()
+}
+
+// outcomeSummary counts the outcomes of a run, e.g. "2 pr-created, 1 failed".
+func outcomeSummary(run *k8s.Run) string {
+	summary := run.Summary()
+	var parts []string
+	for _, outcome := range []string{metrics.OutcomePullRequest, metrics.OutcomePullRequestUpdated, metrics.OutcomeNoChanges, metrics.OutcomeFailed, k8s.OutcomeRunning, k8s.OutcomeUnknown} {
+		if n := summary[outcome]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, outcome))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func printRun(w io.Writer, run *k8s.Run) error {
+	fmt.Fprintf(w, "Run:          %s\n", run.ID)
+	fmt.Fprintf(w, "Change:       %s\n", run.Change)
+	fmt.Fprintf(w, "Change hash:  %s\n", run.ChangeHash)
+	if run.SubmittedBy != "" {
+		fmt.Fprintf(w, "Submitted by: %s\n", run.SubmittedBy)
+	}
+	if run.Profile != "" {
+		fmt.Fprintf(w, "Profile:      %s\n", run.Profile)
+	}
+	if run.Parent != "" {
+		fmt.Fprintf(w, "Follow-up:    %s of %s\n", run.FollowUp, run.Parent)
+	}
+	fmt.Fprintf(w, "Created:      %s\n", run.Created.Local().Format(time.DateTime))
+	if run.Finished != nil {
+		fmt.Fprintf(w, "Finished:     %s\n", run.Finished.Local().Format(time.DateTime))
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tJOB\tOUTCOME\tFAILURE\tPULL REQUEST")
+	for _, repo := range run.Repos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", repo.Repo, repo.Job, repo.Outcome, repo.FailureClass, repo.PullRequestURL)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd)
+	historyCmd.AddCommand(historyPruneCmd)
+
+	historyCmd.PersistentFlags().String("kubeconfig", "", "path to kubeconfig file")
+	historyCmd.PersistentFlags().String("namespace", "default", "kubernetes namespace")
+	historyCmd.Flags().String("change", "", "only list runs of this change name")
+	historyCmd.Flags().String("repo", "", "only list runs with a repository URL containing this")
+	historyCmd.Flags().String("outcome", "", "only list runs with a repository of this outcome: running, pr-created, pr-updated, no-changes, failed or unknown")
+	historyCmd.Flags().Int("limit", 20, "list at most this many runs, 0 for all")
+	historyShowCmd.Flags().StringP("output", "o", "", "print the run in this format (json)")
+	historyPruneCmd.Flags().Int("keep", 0, "keep the newest this many runs")
+	historyPruneCmd.Flags().Duration("older-than", 0, "delete runs created longer ago than this, e.g. 720h")
+	historyPruneCmd.Flags().Bool("dry-run", false, "only list the runs to delete")
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/manno/baca/internal/metrics"
+	"github.com/manno/baca/internal/tracing"
+	"github.com/spf13/cobra"
+)
+
+// writeTermination writes the termination message read by `baca metrics`,
+// when running in a job.
+func writeTermination(t metrics.Termination) {
+	path := os.Getenv(metrics.TerminationLogEnv)
+	if path == "" {
+		return
+	}
+	if err := metrics.WriteTermination(path, t); err != nil {
+		GetLogger().Warn("failed to write termination message", "error", err)
+	}
+}
+
+// withFailureClass wraps the RunE of a job command to record the class of
+// its error in the termination message.
+func withFailureClass(run func(*cobra.Command, []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		err := run(cmd, args)
+		if class := metrics.Classify(err); class != "" {
+			writeTermination(metrics.Termination{FailureClass: class})
+		}
+		return err
+	}
+}
+
+// withSpan wraps the RunE of a job command in a span of the job's trace.
+func withSpan(name string, run func(*cobra.Command, []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) (err error) {
+		ctx, span := tracing.Start(cmd.Context(), name)
+		defer func() { tracing.End(span, err) }()
+		cmd.SetContext(ctx)
+		return run(cmd, args)
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/spf13/cobra"
+)
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Expose Prometheus metrics about the jobs in a namespace",
+	Long: `Expose Prometheus metrics about the jobs in a namespace.
+Polls the jobs every --interval and serves the metrics on --listen at
+/metrics, or pushes them to a Pushgateway with --push-gateway:
+
+  baca_jobs_created_total             jobs created
+  baca_jobs_finished_total            jobs finished, by outcome and failure class
+  baca_agent_duration_seconds         time the agent container ran
+  baca_pr_creation_latency_seconds    time from job creation to the pull request
+  baca_jobs_queued                    unfinished jobs whose pod isn't scheduled
+  baca_jobs_running                   unfinished jobs whose pod is scheduled
+
+Jobs are labeled with change, repo and agent, the queue gauges with change.
+Outcomes are pr-created, pr-updated (a follow-up pushed to an existing pull
+request), no-changes and failed. Failure classes are guardrails, secrets,
+auth, timeout, oom, or the failed container: fork-setup, git-clone, agent or
+publish.
+
+Finished jobs are deleted after a week, keep the interval well below. Only
+jobs created and finished after the start are counted, so a restart doesn't
+count existing jobs again. Run it in the cluster with a service account
+which may list jobs and pods.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := GetLogger()
+
+		kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		listen, _ := cmd.Flags().GetString("listen")
+		pushGateway, _ := cmd.Flags().GetString("push-gateway")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+
+		cfg, err := k8s.GetConfig(kubeconfig)
+		if err != nil {
+			logger.Error("failed to get kubernetes config", "error", err)
+			return err
+		}
+
+		backend, err := k8s.New(cfg, namespace, logger)
+		if err != nil {
+			logger.Error("failed to create backend", "error", err)
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+		rec := metrics.NewRecorder()
+		watchErr := make(chan error, 1)
+		go func() {
+			watchErr <- backend.WatchMetrics(ctx, rec, interval)
+		}()
+
+		if pushGateway != "" {
+			logger.Info("pushing metrics", "gateway", pushGateway, "namespace", namespace, "interval", interval)
+			pusher := push.New(pushGateway, "baca").Grouping("namespace", namespace).Gatherer(rec.Registry)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case err := <-watchErr:
+					return err
+				case <-ticker.C:
+					if err := pusher.Push(); err != nil {
+						logger.Warn("failed to push metrics", "error", err)
+					}
+				}
+			}
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(rec.Registry, promhttp.HandlerOpts{}))
+		server := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := <-watchErr; err != nil && !errors.Is(err, context.Canceled) {
+				logger.Error("metrics collection stopped", "error", err)
+			}
+			_ = server.Close()
+		}()
+
+		logger.Info("serving metrics", "address", listen, "namespace", namespace, "interval", interval)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("failed to serve metrics", "error", err)
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+
+	metricsCmd.Flags().String("kubeconfig", "", "path to kubeconfig file")
+	metricsCmd.Flags().String("namespace", "default", "kubernetes namespace of the jobs")
+	metricsCmd.Flags().String("listen", ":9090", "address to serve /metrics on")
+	metricsCmd.Flags().String("push-gateway", "", "push metrics to this Pushgateway URL instead of serving them")
+	metricsCmd.Flags().Duration("interval", 30*time.Second, "how often jobs are polled and metrics pushed")
+}
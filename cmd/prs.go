@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/publish"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var prsCmd = &cobra.Command{
+	Use:   "prs RUN",
+	Short: "Show the status of the pull requests created by a run",
+	Long: `Show the status of the pull requests created by a run, as listed by
+baca history: whether each is open, merged or closed, the combined state of
+its CI checks, its review state and whether it can be merged without
+conflicts. A summary shows the progress of the run, e.g.
+
+  27/40 merged, 2 closed, 11 open: 5 failing CI, 1 changes requested
+
+The forges are queried with the tokens from the environment, e.g.
+GITHUB_TOKEN or GITLAB_TOKEN_GITLAB_EXAMPLE_COM, see baca setup.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		useStderrLogger()
+		logger := GetLogger()
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "" && output != "json" {
+			return fmt.Errorf("invalid --output value %q: must be json", output)
+		}
+
+		backend, err := historyBackend(cmd)
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		run, err := backend.GetRun(ctx, args[0])
+		if err != nil {
+			logger.Error("failed to get run", "run", args[0], "error", err)
+			return err
+		}
+
+		statuses := pullRequestStatuses(ctx, run, viper.GetStringMapString("forges"))
+		if output == "json" {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(statuses)
+		}
+		return printPullRequests(cmd.OutOrStdout(), statuses)
+	},
+}
+
+// runPullRequest is the status of the pull request of a repository in a run,
+// Error is set if it couldn't be queried.
+type runPullRequest struct {
+	Repo   string                   `json:"repo"`
+	URL    string                   `json:"url"`
+	Status *forge.PullRequestStatus `json:"status,omitempty"`
+	Error  string                   `json:"error,omitempty"`
+}
+
+// pullRequestStatuses queries the forges for the pull requests of the run,
+// failures are reported per pull request.
+func pullRequestStatuses(ctx context.Context, run *k8s.Run, forges map[string]string) []runPullRequest {
+	var prs []runPullRequest
+	for _, repo := range run.Repos {
+		if repo.PullRequestURL == "" {
+			continue
+		}
+		pr := runPullRequest{Repo: repo.Repo, URL: repo.PullRequestURL}
+		status, err := pullRequestStatus(ctx, repo.Repo, repo.PullRequestURL, forges)
+		if err != nil {
+			GetLogger().Warn("failed to get pull request status", "url", repo.PullRequestURL, "error", err)
+			pr.Error = err.Error()
+		}
+		pr.Status = status
+		prs = append(prs, pr)
+	}
+	return prs
+}
+
+func pullRequestStatus(ctx context.Context, repoURL, prURL string, forges map[string]string) (*forge.PullRequestStatus, error) {
+	client, upstream, err := forgeClient(ctx, repoURL, forges)
+	if err != nil {
+		return nil, err
+	}
+	number, err := forge.PullRequestNumber(prURL)
+	if err != nil {
+		return nil, err
+	}
+	return client.PullRequestStatus(ctx, upstream, number)
+}
+
+// forgeClient returns a client for the forge of a run's repository, with
+// the tokens from the environment.
+func forgeClient(ctx context.Context, repoURL string, forges map[string]string) (forge.Provider, forge.Repo, error) {
+	upstream, err := forge.ParseRepoURL(repoURL)
+	if err != nil {
+		return nil, forge.Repo{}, err
+	}
+	kind, err := forge.KindForHost(upstream.Host, forges)
+	if err != nil {
+		return nil, forge.Repo{}, err
+	}
+	// A GitHub App only needs a token for the upstream installation
+	client, err := publish.NewProvider(ctx, kind, upstream, upstream.Owner)
+	if err != nil {
+		return nil, forge.Repo{}, fmt.Errorf("failed to create forge client: %w", err)
+	}
+	return client, upstream, nil
+}
+
+func printPullRequests(w io.Writer, prs []runPullRequest) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tPULL REQUEST\tSTATE\tCHECKS\tREVIEW\tMERGEABLE")
+	for _, pr := range prs {
+		if pr.Status == nil {
+			fmt.Fprintf(tw, "%s\t%s\terror\t\t\t\n", pr.Repo, pr.URL)
+			continue
+		}
+		s := pr.Status
+		checks := s.CheckState()
+		if failed := s.FailedChecks(); len(failed) > 0 {
+			var names []string
+			for _, check := range failed {
+				names = append(names, check.Name)
+			}
+			checks += " (" + strings.Join(names, ", ") + ")"
+		}
+		mergeable := "unknown"
+		if s.Mergeable != nil {
+			mergeable = map[bool]string{true: "yes", false: "conflict"}[*s.Mergeable]
+		}
+		if s.State != forge.StateOpen {
+			mergeable = ""
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", pr.Repo, pr.URL, s.State, checks, s.Review, mergeable)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, progressSummary(prs))
+	return nil
+}
+
+// progressSummary sums up the pull requests of a run, e.g. "27/40 merged,
+// 2 closed, 11 open: 5 failing CI, 1 changes requested".
+func progressSummary(prs []runPullRequest) string {
+	var merged, closed, open, failing, pending, changes, approved, conflicts, unknown int
+	for _, pr := range prs {
+		s := pr.Status
+		if s == nil {
+			unknown++
+			continue
+		}
+		switch s.State {
+		case forge.StateMerged:
+			merged++
+			continue
+		case forge.StateClosed:
+			closed++
+			continue
+		}
+		open++
+		switch s.CheckState() {
+		case forge.CheckFailure:
+			failing++
+		case forge.CheckPending:
+			pending++
+		}
+		switch s.Review {
+		case forge.ReviewChangesRequested:
+			changes++
+		case forge.ReviewApproved:
+			approved++
+		}
+		if s.Mergeable != nil && !*s.Mergeable {
+			conflicts++
+		}
+	}
+
+	summary := fmt.Sprintf("%d/%d merged, %d closed, %d open", merged, len(prs), closed, open)
+	var details []string
+	for _, d := range []struct {
+		n    int
+		text string
+	}{
+		{failing, "failing CI"},
+		{pending, "CI pending"},
+		{changes, "changes requested"},
+		{approved, "approved"},
+		{conflicts, "with conflicts"},
+	} {
+		if d.n > 0 {
+			details = append(details, fmt.Sprintf("%d %s", d.n, d.text))
+		}
+	}
+	if len(details) > 0 {
+		summary += ": " + strings.Join(details, ", ")
+	}
+	if unknown > 0 {
+		summary += fmt.Sprintf(" (%d not queried)", unknown)
+	}
+	return summary
+}
+
+func init() {
+	rootCmd.AddCommand(prsCmd)
+
+	prsCmd.Flags().String("kubeconfig", "", "path to kubeconfig file")
+	prsCmd.Flags().String("namespace", "default", "kubernetes namespace")
+	prsCmd.Flags().StringP("output", "o", "", "print the pull requests in this format (json)")
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/followup"
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/metadata"
+	"github.com/manno/baca/internal/metrics"
+	"github.com/manno/baca/internal/publish"
+	"github.com/manno/baca/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var publishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "Push the agent's changes and create a pull request",
+	Long: `Push the agent's changes and create a pull request.
+This runs in the Kubernetes job after 'baca execute'. It commits all changes
+in the work dir to a new branch of --git-dir, the bare repository written by
+'baca clone' which the agent can't modify, pushes the branch to the fork and
+opens a pull request against the original repository, using the PR metadata
+written by 'baca execute'. Does nothing if the agent made no changes.
+
+Before committing, the changes are checked against the guardrails of the
+change again, the agent could have changed files after its check. Before
+pushing, the commit, its message and the PR metadata are scanned for the
+values of all credentials in --credentials-dir and common token formats.
+Publishing is aborted if anything is found, or if --credentials-dir exists
+but can't be read.
+
+In branch publish mode (publish.mode in the config) the branch is pushed to
+the original repository and the pull request is opened within it.
+
+With --pull-request-url an existing pull request is updated instead, e.g. by
+'baca fix-ci': --git-dir is a clone of its head branch in --head-url, the
+branch in the config, and the changes are pushed there. With
+--review-threads, e.g. from 'baca fix-review', each of the threads is
+replied to with the pushed commit and the summary from the PR metadata.
+
+Authenticates with GITHUB_TOKEN, GITLAB_TOKEN or GITEA_TOKEN, depending on
+--forge, or the token for a self-hosted forge's host, e.g.
+GITLAB_TOKEN_GITLAB_EXAMPLE_COM, or as the GitHub App from GITHUB_APP_ID
+and GITHUB_APP_PRIVATE_KEY.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := GetLogger()
+
+		configJSON, _ := cmd.Flags().GetString("config")
+		workDir, _ := cmd.Flags().GetString("work-dir")
+		gitDir, _ := cmd.Flags().GetString("git-dir")
+		repoURL, _ := cmd.Flags().GetString("repo-url")
+		forkURLFile, _ := cmd.Flags().GetString("fork-url-file")
+		metadataFile, _ := cmd.Flags().GetString("metadata")
+		credentialsDir, _ := cmd.Flags().GetString("credentials-dir")
+		forgeName, _ := cmd.Flags().GetString("forge")
+		prURL, _ := cmd.Flags().GetString("pull-request-url")
+		headURL, _ := cmd.Flags().GetString("head-url")
+		reviewThreadsFile, _ := cmd.Flags().GetString("review-threads")
+
+		var spec change.ChangeSpec
+		if err := json.Unmarshal([]byte(configJSON), &spec); err != nil {
+			logger.Error("failed to parse config JSON", "error", err)
+			return err
+		}
+
+		upstream, err := forge.ParseRepoURL(repoURL)
+		if err != nil {
+			logger.Error("failed to parse repository URL", "error", err)
+			return err
+		}
+
+		// In branch mode the branch is pushed to upstream itself, updates go
+		// to the head of the pull request
+		fork := upstream
+		var update *forge.PullRequest
+		switch {
+		case prURL != "":
+			number, err := forge.PullRequestNumber(prURL)
+			if err != nil {
+				return err
+			}
+			update = &forge.PullRequest{Number: number, URL: prURL}
+			if headURL == "" {
+				return fmt.Errorf("--head-url is required with --pull-request-url")
+			}
+			fork, err = forge.ParseRepoURL(headURL)
+			if err != nil {
+				logger.Error("failed to parse head repository URL", "error", err)
+				return err
+			}
+		case reviewThreadsFile != "":
+			return fmt.Errorf("--review-threads requires --pull-request-url")
+		case spec.Publish.Mode != change.PublishBranch:
+			forkURL, err := os.ReadFile(forkURLFile)
+			if err != nil {
+				return fmt.Errorf("failed to read fork URL: %w", err)
+			}
+			fork, err = forge.ParseRepoURL(strings.TrimSpace(string(forkURL)))
+			if err != nil {
+				logger.Error("failed to parse fork URL", "error", err)
+				return err
+			}
+		}
+
+		var threads []forge.ReviewThread
+		if reviewThreadsFile != "" {
+			if threads, err = followup.LoadReviewThreads(reviewThreadsFile); err != nil {
+				logger.Error("failed to load review threads", "error", err)
+				return err
+			}
+		}
+
+		// Without metadata, e.g. if execute failed to write it, Publish uses a template
+		m, err := metadata.Load(metadataFile)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				logger.Warn("ignoring invalid PR metadata", "error", err)
+			}
+			m = metadata.Metadata{}
+		}
+
+		baseBranch := spec.Branch
+		if baseBranch == "" {
+			baseBranch = "main"
+		}
+
+		kind, err := forge.ParseKind(forgeName)
+		if err != nil {
+			return err
+		}
+		commit := publish.CommitOptions{
+			AuthorName:  spec.Commit.Author.Name,
+			AuthorEmail: spec.Commit.Author.Email,
+			CoAuthors:   spec.Commit.CoAuthors,
+			SignOff:     spec.Commit.SignOff,
+		}
+		if spec.Commit.Sign {
+			commit.SigningKey = os.Getenv("GIT_SIGNING_KEY")
+			if commit.SigningKey == "" {
+				logger.Error("commit signing requested but no signing key found")
+				return fmt.Errorf("commit.sign is set but GIT_SIGNING_KEY is empty, store a key with 'baca setup --signing-key'")
+			}
+		}
+
+		// The mounted secret has all credentials, also those the job doesn't
+		// get as environment variables. Without them leaks of those can't be
+		// detected, so publishing fails unless the directory doesn't exist.
+		credentials, err := secrets.LoadDir(credentialsDir)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			logger.Warn("no credentials mounted, only scanning for the environment and token patterns", "dir", credentialsDir)
+			credentials = map[string]string{}
+		case err != nil:
+			logger.Error("failed to load credentials for secret scanning", "dir", credentialsDir, "error", err)
+			return fmt.Errorf("failed to load credentials for secret scanning: %w", err)
+		}
+		// Credentials injected as files, e.g. by Vault, are in the environment
+		for key, value := range secrets.LoadEnv() {
+			credentials[key] = value
+		}
+
+		ctx := cmd.Context()
+		client, err := publish.NewProvider(ctx, kind, upstream, fork.Owner)
+		if err != nil {
+			logger.Error("failed to create forge client", "error", err)
+			return err
+		}
+
+		pr, err := publish.NewPublisher(client, logger).Publish(ctx, publish.Options{
+			WorkDir:       workDir,
+			GitDir:        gitDir,
+			Upstream:      upstream,
+			Fork:          fork,
+			BaseBranch:    baseBranch,
+			Metadata:      m,
+			Prompt:        spec.Prompt,
+			Commit:        commit,
+			Guardrails:    spec.Guardrails,
+			Scanner:       secrets.NewScanner(credentials),
+			PullRequest:   update,
+			ReviewThreads: threads,
+		})
+		if err != nil {
+			logger.Error("publish failed", "error", err)
+			return err
+		}
+
+		if pr != nil {
+			logger.Info("publish completed", "pr", pr.URL)
+			termination := metrics.Termination{PullRequestURL: pr.URL, PullRequestUpdated: update != nil}
+			if update == nil {
+				now := time.Now()
+				termination.PullRequestCreatedAt = &now
+			}
+			writeTermination(termination)
+		}
+		return nil
+	},
+}
+
+func init() {
+	publishCmd.RunE = withSpan("publish", withFailureClass(publishCmd.RunE))
+	rootCmd.AddCommand(publishCmd)
+
+	publishCmd.Flags().String("config", "", "JSON configuration of the change (prompt, branch)")
+	publishCmd.Flags().String("work-dir", ".", "repository with the agent's changes")
+	publishCmd.Flags().String("git-dir", "/var/run/baca/git/repo.git", "bare repository written by 'baca clone' to commit the changes in")
+	publishCmd.Flags().String("repo-url", "", "URL of the original repository to open the pull request against")
+	publishCmd.Flags().String("fork-url-file", "/workspace/fork-url.txt", "file containing the fork URL written by fork-setup")
+	publishCmd.Flags().String("metadata", "/workspace/pr-metadata.json", "PR metadata written by execute")
+	publishCmd.Flags().String("credentials-dir", "/var/run/baca/credentials", "mounted credentials secret, the changes are scanned for its values")
+	publishCmd.Flags().String("forge", string(forge.GitHub), "forge hosting the repository (github, gitlab, gitea)")
+	publishCmd.Flags().String("pull-request-url", "", "existing pull request to push the changes to, instead of opening a new one")
+	publishCmd.Flags().String("head-url", "", "repository with the head branch of --pull-request-url")
+	publishCmd.Flags().String("review-threads", "", "JSON file with the review threads of --pull-request-url to reply to after pushing")
+
+	_ = publishCmd.MarkFlagRequired("config")
+	_ = publishCmd.MarkFlagRequired("repo-url")
+}
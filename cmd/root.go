@@ -1,16 +1,27 @@
 package cmd
 
 import (
+	"context"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/manno/baca/internal/secrets"
+	"github.com/manno/baca/internal/tracing"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	cfgFile string
-	logger  *slog.Logger
+	cfgFile  string
+	logger   *slog.Logger
+	logLevel = new(slog.LevelVar)
+	// tracingConfig is read from the config file, shutdownTracing flushes
+	// the spans on exit
+	tracingConfig   tracing.Config
+	shutdownTracing = func(context.Context) error { return nil }
 )
 
 var rootCmd = &cobra.Command{
@@ -20,7 +31,15 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	// Job containers continue the trace of apply
+	err := rootCmd.ExecuteContext(tracing.FromEnv(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("failed to export traces", "error", err)
+	}
+	return err
 }
 
 func init() {
@@ -30,9 +49,7 @@ func init() {
 	rootCmd.PersistentFlags().String("log-level", "info", "log level (debug, info, warn, error)")
 	_ = viper.BindPFlag("log-level", rootCmd.PersistentFlags().Lookup("log-level"))
 
-	logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	logger = newLogger(os.Stdout)
 }
 
 func initConfig() {
@@ -56,17 +73,73 @@ func initConfig() {
 		logger.Info("using config file", "file", viper.ConfigFileUsed())
 	}
 
+	loadCredentialFiles()
+
 	level := viper.GetString("log-level")
 	switch level {
 	case "debug":
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		logLevel.Set(slog.LevelDebug)
 	case "warn":
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}))
+		logLevel.Set(slog.LevelWarn)
 	case "error":
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+		logLevel.Set(slog.LevelError)
+	}
+
+	setupTracing()
+}
+
+// setupTracing exports traces to the collector of the `tracing` config key,
+// or in jobs to the one from the OTLP environment variables.
+func setupTracing() {
+	if err := viper.UnmarshalKey("tracing", &tracingConfig); err != nil {
+		logger.Warn("ignoring invalid tracing config", "error", err)
+		return
+	}
+	shutdown, err := tracing.Setup(context.Background(), tracingConfig, "baca")
+	if err != nil {
+		logger.Warn("tracing disabled", "error", err)
+		return
+	}
+	shutdownTracing = shutdown
+}
+
+// loadCredentialFiles sets environment variables from the files in the
+// BACA_CREDENTIALS_FILES directory, e.g. rendered by the Vault Agent
+// injector. Variables which are already set take precedence.
+func loadCredentialFiles() {
+	dir := os.Getenv("BACA_CREDENTIALS_FILES")
+	if dir == "" {
+		return
+	}
+	credentials, err := secrets.LoadDir(dir)
+	if err != nil {
+		logger.Warn("failed to load credential files", "dir", dir, "error", err)
+		return
+	}
+	for key, value := range credentials {
+		if os.Getenv(key) == "" {
+			_ = os.Setenv(key, strings.TrimSpace(value))
+		}
 	}
+	// Mask the new credentials in logs, too
+	logger = newLogger(os.Stdout)
+}
+
+// newLogger returns a JSON logger which masks the values of credentials in
+// the environment and well-known token formats.
+func newLogger(w io.Writer) *slog.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: logLevel,
+	})
+	return slog.New(secrets.NewHandler(handler, secrets.NewScanner(secrets.LoadEnv())))
 }
 
 func GetLogger() *slog.Logger {
 	return logger
 }
+
+// useStderrLogger sends all further log output to stderr, so stdout can be
+// used for machine readable output.
+func useStderrLogger() {
+	logger = newLogger(os.Stderr)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/preflight"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate KEY[=VALUE]...",
+	Short: "Replace individual credentials",
+	Long: `Replace individual credentials in the credentials secret, keeping all
+others. Each argument names a key of the secret, e.g. GITHUB_TOKEN:
+
+  KEY=VALUE  - use VALUE
+  KEY=@FILE  - read the value from FILE, e.g. GIT_SIGNING_KEY=@key.pem
+  KEY        - read the value from the environment variable KEY
+
+Keys holding the same value as a rotated key are rotated along, e.g.
+COPILOT_TOKEN if setup stored GITHUB_TOKEN as it. New GitHub tokens
+are verified, and their expiration is recorded, unless --skip-preflight is
+given.`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := GetLogger()
+
+		kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		profile, _ := cmd.Flags().GetString("profile")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+		if err := k8s.ValidateProfile(profile); err != nil {
+			return err
+		}
+
+		credentials, err := parseRotateArgs(args)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		expires, err := preflight.TokenExpiries(ctx, preflight.Options{
+			Credentials: credentials,
+			Forges:      viper.GetStringMapString("forges"),
+		})
+		if err != nil {
+			if !skipPreflight {
+				logger.Error("invalid token", "error", err)
+				return fmt.Errorf("invalid token, use --skip-preflight to store it anyway: %w", err)
+			}
+			logger.Warn("failed to look up token expiration", "error", err)
+		}
+
+		cfg, err := k8s.GetConfig(kubeconfig)
+		if err != nil {
+			logger.Error("failed to get kubernetes config", "error", err)
+			return err
+		}
+
+		backend, err := k8s.New(cfg, namespace, logger)
+		if err != nil {
+			logger.Error("failed to create backend", "error", err)
+			return err
+		}
+
+		if _, err := backend.WithProfile(profile).Rotate(ctx, credentials, expires); err != nil {
+			logger.Error("failed to rotate credentials", "error", err)
+			return err
+		}
+		return nil
+	},
+}
+
+// parseRotateArgs reads the new credentials, see rotateCmd.
+func parseRotateArgs(args []string) (map[string]string, error) {
+	credentials := map[string]string{}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		switch {
+		case key == "":
+			return nil, fmt.Errorf("invalid argument %q: must be KEY[=VALUE]", arg)
+		case !ok:
+			value = os.Getenv(key)
+			if value == "" {
+				return nil, fmt.Errorf("%s is not set in the environment", key)
+			}
+		case strings.HasPrefix(value, "@"):
+			data, err := os.ReadFile(value[1:])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", key, err)
+			}
+			value = string(data)
+		}
+		if value == "" {
+			return nil, fmt.Errorf("%s is empty", key)
+		}
+		credentials[key] = value
+	}
+	return credentials, nil
+}
+
+func init() {
+	setupCmd.AddCommand(rotateCmd)
+
+	rotateCmd.Flags().String("kubeconfig", "", "path to kubeconfig file")
+	rotateCmd.Flags().String("namespace", "default", "kubernetes namespace")
+	rotateCmd.Flags().String("profile", "", "rotate the credentials of a profile created with baca setup --profile")
+	rotateCmd.Flags().Bool("skip-preflight", false, "store tokens without verifying them")
+}
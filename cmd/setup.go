@@ -3,9 +3,15 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/forge/github"
+	"github.com/manno/baca/internal/preflight"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var setupCmd = &cobra.Command{
@@ -17,7 +23,7 @@ create pull requests, and run coding agents.
 
 Required credentials (via flags or environment variables):
   GITHUB_TOKEN - GitHub personal access token for:
-    - Git repository cloning (Fleet gitcloner)
+    - Git repository cloning (baca clone)
     - Git push to create branches (git push origin)
     - Pull request creation (gh CLI)
     
@@ -32,6 +38,43 @@ Required credentials (via flags or environment variables):
       Generate at: https://github.com/settings/tokens/new
       Required scopes: repo, read:org
 
+GitHub App, instead of GITHUB_TOKEN (jobs then require --fork-org):
+    --github-app-id and --github-app-key (path to the app's private key PEM)
+    Each job mints short-lived installation tokens: one scoped to the
+    target repository for pull requests, one for the fork organization.
+    Required app permissions: "Contents" read/write, "Pull requests"
+      read/write, "Administration" read/write (to create forks), "Metadata" read
+    Install the app on the target repositories and the fork organization.
+
+GitHub Enterprise Server tokens, one per host (the host must be mapped to
+  github in the 'forges' config):
+    --github-host-token ghe.example.com=TOKEN
+    Stored as GITHUB_TOKEN_GHE_EXAMPLE_COM, same permissions as GITHUB_TOKEN
+
+GITLAB_TOKEN - GitLab personal access token, if any repositories are hosted
+  on gitlab.com:
+    --gitlab-token or GITLAB_TOKEN
+    Self-hosted GitLab (see 'forges' in the config file), one per host:
+    --gitlab-host-token gitlab.example.com=TOKEN
+    Required scopes: api (fork projects, create merge requests),
+      write_repository (push branches)
+
+GITEA_TOKEN - Gitea/Forgejo access token, if any repositories are hosted on
+  codeberg.org:
+    --gitea-token or GITEA_TOKEN
+    Self-hosted Gitea or Forgejo, one per host:
+    --gitea-host-token git.example.com=TOKEN
+    Required scopes: write:repository, read:user (write:organization to
+      fork into an organization)
+
+The default tokens are only used for github.com, gitlab.com and
+codeberg.org, other hosts only get their host specific token.
+
+Commit signing (if any change sets commit.sign):
+  --signing-key - Path to an OpenSSH or armored GPG private key without
+    passphrase, stored as GIT_SIGNING_KEY. Register the public key with the
+    forge account of the commit author.
+
 Copilot CLI authentication (if using copilot-cli agent):
   --copilot-token or COPILOT_TOKEN - GitHub token for Copilot CLI
     
@@ -39,17 +82,55 @@ Copilot CLI authentication (if using copilot-cli agent):
       Generate at: https://github.com/settings/personal-access-tokens/new
       Required permissions: "Copilot Requests" read/write
     
-    Classic PAT:
-      Generate at: https://github.com/settings/tokens/new
-      Required scopes: repo, read:org (same as GITHUB_TOKEN)
-    
-    Note: Can use same token as GITHUB_TOKEN if it has all required scopes
+    Use a separate token without repository access: the agent container
+    gets it, and the forge tokens are kept out of that container.
+    --copilot-use-github-token stores GITHUB_TOKEN as COPILOT_TOKEN instead,
+    which gives the agent push access to the repositories. Not recommended.
 
 Gemini authentication (if using gemini-cli agent, choose one):
   --gemini-api-key or GEMINI_API_KEY - Gemini API key for gemini-cli
     Generate at: https://aistudio.google.com/apikey
   --gemini-oauth - Copy OAuth credentials from ~/.gemini/ directory
-    Authenticate gemini CLI first, then use this flag`,
+    Authenticate gemini CLI first, then use this flag
+
+Credential references, so tokens never pass through this machine:
+  --credential-ref GITHUB_TOKEN=team-secrets/github-token - Use a key of an
+    existing secret in the namespace, not the credentials of another profile.
+    Running setup with plain values later drops their references.
+  --external-secret-store vault-backend --external-secret
+    GITHUB_TOKEN=baca/github#token - Create an ExternalSecret, the External
+    Secrets Operator syncs the credentials secret from the store.
+  --vault-role baca --vault-secret GITHUB_TOKEN=secret/data/baca#github_token
+    - Inject credentials with the Vault Agent injector, which logs in with
+    the token of the jobs' service account (--service-account). Agent
+    credentials can't come from Vault.
+
+Preflight checks, before anything is stored:
+  The tokens are verified with the forges (user, scopes, forking into
+  --fork-org), the Copilot token and Gemini credentials with a cheap call,
+  the permissions for jobs, pods and logs in the namespace, and whether
+  --image can be pulled. Results are printed as a table, setup stops if any
+  check fails unless --skip-preflight is given. Re-run them any time with
+  'baca doctor'.
+
+Expiration:
+  The expiration of GitHub tokens is recorded on the secret, baca apply
+  warns a week before. Replace single credentials with 'baca setup rotate'.
+
+Profiles:
+  --profile alice - Store the credentials in baca-credentials-alice instead
+    of baca-credentials. Jobs use them with 'baca apply --profile alice'.
+
+Pod security:
+  --pod-security restricted - Label the namespace to enforce the
+    "restricted" Pod Security Standard. Jobs run as a non-root user with a
+    read-only root filesystem, see the 'security' key of the config file.
+
+Network policy:
+  --network-policy - Install a NetworkPolicy denying egress of all job pods
+    except to the cluster DNS, see 'network.dns' of the config file. baca apply then creates a policy per job, allowing the forge,
+    the agent's API, the change's resources and the 'network' allowlist of
+    the config file.`,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := GetLogger()
@@ -58,37 +139,153 @@ Gemini authentication (if using gemini-cli agent, choose one):
 		kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
 		namespace, _ := cmd.Flags().GetString("namespace")
 		githubToken, _ := cmd.Flags().GetString("github-token")
+		githubHostTokens, _ := cmd.Flags().GetStringToString("github-host-token")
+		gitlabHostTokens, _ := cmd.Flags().GetStringToString("gitlab-host-token")
+		giteaHostTokens, _ := cmd.Flags().GetStringToString("gitea-host-token")
+		hostTokens := map[forge.Kind]map[string]string{
+			forge.GitHub: githubHostTokens,
+			forge.GitLab: gitlabHostTokens,
+			forge.Gitea:  giteaHostTokens,
+		}
+		githubAppID, _ := cmd.Flags().GetString("github-app-id")
+		githubAppKeyFile, _ := cmd.Flags().GetString("github-app-key")
+		gitlabToken, _ := cmd.Flags().GetString("gitlab-token")
+		giteaToken, _ := cmd.Flags().GetString("gitea-token")
 		copilotToken, _ := cmd.Flags().GetString("copilot-token")
+		copilotUseGitHubToken, _ := cmd.Flags().GetBool("copilot-use-github-token")
 		googleAPIKey, _ := cmd.Flags().GetString("gemini-api-key")
 		useGeminiOAuth, _ := cmd.Flags().GetBool("gemini-oauth")
+		networkPolicy, _ := cmd.Flags().GetBool("network-policy")
+		podSecurity, _ := cmd.Flags().GetString("pod-security")
+		profile, _ := cmd.Flags().GetString("profile")
+		if err := k8s.ValidateProfile(profile); err != nil {
+			return err
+		}
+		signingKeyFile, _ := cmd.Flags().GetString("signing-key")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+		forkOrg, _ := cmd.Flags().GetString("fork-org")
+		image, _ := cmd.Flags().GetString("image")
 
-		// Fallback to environment variables if flags not provided
-		if githubToken == "" {
+		refs, err := credentialRefs(cmd)
+		if err != nil {
+			return err
+		}
+		externalStore, _ := cmd.Flags().GetString("external-secret-store")
+		externalRefs, _ := cmd.Flags().GetStringToString("external-secret")
+		if (externalStore == "") != (len(externalRefs) == 0) {
+			return fmt.Errorf("use --external-secret-store and --external-secret together")
+		}
+		referenced := refs.Keys()
+		for key := range externalRefs {
+			referenced = append(referenced, key)
+		}
+		useRefs := len(referenced) > 0
+
+		// Fallback to environment variables if flags not provided, unless
+		// the credentials are referenced
+		if useRefs {
+			logger.Info("using credential references, ignoring token environment variables")
+		} else if githubToken == "" {
 			githubToken = os.Getenv("GITHUB_TOKEN")
 		}
-		if copilotToken == "" {
-			copilotToken = os.Getenv("COPILOT_TOKEN")
+		if !useRefs {
+			if gitlabToken == "" {
+				gitlabToken = os.Getenv("GITLAB_TOKEN")
+			}
+			if giteaToken == "" {
+				giteaToken = os.Getenv("GITEA_TOKEN")
+			}
+			if copilotToken == "" {
+				copilotToken = os.Getenv("COPILOT_TOKEN")
+			}
+			if googleAPIKey == "" {
+				googleAPIKey = os.Getenv("GEMINI_API_KEY")
+			}
+			if githubAppID == "" {
+				githubAppID = os.Getenv("GITHUB_APP_ID")
+			}
 		}
-		if googleAPIKey == "" {
-			googleAPIKey = os.Getenv("GEMINI_API_KEY")
+
+		if (githubAppID == "") != (githubAppKeyFile == "") {
+			logger.Error("github app id and key are required together")
+			return fmt.Errorf("use --github-app-id and --github-app-key together")
 		}
 
-		if githubToken == "" {
-			logger.Error("github token is required")
-			return fmt.Errorf("github token is required: use --github-token flag or GITHUB_TOKEN env var")
+		hasHostToken := len(githubHostTokens)+len(gitlabHostTokens)+len(giteaHostTokens) > 0
+		if githubToken == "" && githubAppID == "" && !hasHostToken && gitlabToken == "" && giteaToken == "" && !hasForgeCredential(referenced) {
+			logger.Error("forge token is required")
+			return fmt.Errorf("a forge token is required: use --github-token, --github-app-id, --gitlab-token, --gitea-token or --<forge>-host-token flags, GITHUB_TOKEN, GITLAB_TOKEN or GITEA_TOKEN env vars, or reference one")
 		}
 
 		// Build credentials map
-		credentials := map[string]string{
-			"GITHUB_TOKEN": githubToken,
+		credentials := map[string]string{}
+		if githubToken != "" {
+			credentials["GITHUB_TOKEN"] = githubToken
+		}
+		if githubAppID != "" {
+			key, err := os.ReadFile(githubAppKeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read github app key: %w", err)
+			}
+			// Fail early on a bad key instead of in every job
+			if _, err := github.NewApp(github.DefaultBaseURL, githubAppID, key); err != nil {
+				logger.Error("invalid github app key", "error", err)
+				return err
+			}
+			credentials["GITHUB_APP_ID"] = githubAppID
+			credentials["GITHUB_APP_PRIVATE_KEY"] = string(key)
+			logger.Info("using github app authentication", "app-id", githubAppID)
+		}
+		for kind, tokens := range hostTokens {
+			for host, token := range tokens {
+				host = strings.ToLower(host)
+				credentials[forge.HostTokenEnv(kind, host)] = token
+				logger.Info("using host token", "forge", kind, "host", host)
+			}
+		}
+		if gitlabToken != "" {
+			credentials["GITLAB_TOKEN"] = gitlabToken
+			logger.Info("using gitlab token")
+		}
+		if giteaToken != "" {
+			credentials["GITEA_TOKEN"] = giteaToken
+			logger.Info("using gitea token")
 		}
 
-		// Add copilot token if provided (separate from GITHUB_TOKEN)
-		if copilotToken != "" {
+		if signingKeyFile != "" {
+			key, err := os.ReadFile(signingKeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read signing key: %w", err)
+			}
+			credentials["GIT_SIGNING_KEY"] = string(key)
+			logger.Info("using commit signing key")
+		}
+
+		// The agent only gets COPILOT_TOKEN, not the forge tokens. Copying
+		// GITHUB_TOKEN defeats that and has to be asked for.
+		const copyWarning = "the agent gets GITHUB_TOKEN as COPILOT_TOKEN and can push to and open pull requests on all repositories the token can access, use a separate --copilot-token instead"
+		switch {
+		case copilotToken != "":
+			if copilotUseGitHubToken {
+				return fmt.Errorf("use either --copilot-token or --copilot-use-github-token")
+			}
 			credentials["COPILOT_TOKEN"] = copilotToken
 			logger.Info("using separate copilot token")
-		} else {
-			logger.Info("copilot will use GITHUB_TOKEN (ensure it has Copilot Requests permission)")
+		case !copilotUseGitHubToken:
+			if !slices.Contains(referenced, "COPILOT_TOKEN") {
+				logger.Info("no copilot token, copilot-cli jobs fail: use --copilot-token with a token limited to Copilot Requests")
+			}
+		case githubToken != "":
+			credentials["COPILOT_TOKEN"] = githubToken
+			logger.Warn(copyWarning)
+		case refs.Secrets["GITHUB_TOKEN"] != (k8s.SecretKeyRef{}) && !slices.Contains(referenced, "COPILOT_TOKEN"):
+			refs.Secrets["COPILOT_TOKEN"] = refs.Secrets["GITHUB_TOKEN"]
+			logger.Warn(copyWarning)
+		case externalRefs["GITHUB_TOKEN"] != "" && !slices.Contains(referenced, "COPILOT_TOKEN"):
+			externalRefs["COPILOT_TOKEN"] = externalRefs["GITHUB_TOKEN"]
+			logger.Warn(copyWarning)
+		default:
+			return fmt.Errorf("--copilot-use-github-token requires a GITHUB_TOKEN")
 		}
 
 		// Handle gemini authentication
@@ -130,6 +327,11 @@ Gemini authentication (if using gemini-cli agent, choose one):
 			logger.Info("using gemini oauth authentication", "files", len(geminiFiles))
 		}
 
+		// The operator owns the secret, it can't be written by setup as well
+		if externalStore != "" && len(credentials) > 0 {
+			return fmt.Errorf("--external-secret can't be combined with tokens or keys stored by setup, reference all credentials")
+		}
+
 		cfg, err := k8s.GetConfig(kubeconfig)
 		if err != nil {
 			logger.Error("failed to get kubernetes config", "error", err)
@@ -143,23 +345,179 @@ Gemini authentication (if using gemini-cli agent, choose one):
 		}
 
 		ctx := cmd.Context()
-		if err := backend.Setup(ctx, credentials); err != nil {
+		backend = backend.WithProfile(profile)
+		forges := map[string]string{}
+		for host, kind := range viper.GetStringMapString("forges") {
+			forges[host] = kind
+		}
+		for kind, tokens := range hostTokens {
+			for host := range tokens {
+				forges[strings.ToLower(host)] = string(kind)
+			}
+		}
+		if skipPreflight {
+			logger.Warn("skipping preflight checks")
+		} else {
+			// Referenced credentials aren't known here, baca doctor reads
+			// them from the cluster
+			checked := credentials
+			if useRefs {
+				checked = nil
+				logger.Info("run baca doctor after setup to check the referenced credentials")
+			}
+			report := backend.Preflight(ctx)
+			report = append(report, runPreflight(ctx, checked, forges, forkOrg, image)...)
+			if err := report.Print(cmd.OutOrStdout()); err != nil {
+				return err
+			}
+			if report.Failed() {
+				logger.Error("preflight checks failed")
+				return fmt.Errorf("preflight checks failed, fix them or use --skip-preflight: %s", report)
+			}
+		}
+
+		// Recorded with the credentials, so apply warns before they expire
+		expires, err := preflight.TokenExpiries(ctx, preflight.Options{Credentials: credentials, Forges: forges})
+		if err != nil {
+			logger.Warn("failed to look up token expiration", "error", err)
+		}
+
+		if err := backend.Setup(ctx, credentials, expires); err != nil {
 			logger.Error("failed to setup backend", "error", err)
 			return err
 		}
 
+		if refs != nil {
+			if err := backend.SetupCredentialRefs(ctx, refs); err != nil {
+				logger.Error("failed to store credential references", "error", err)
+				return err
+			}
+		} else if len(credentials) > 0 {
+			keys := make([]string, 0, len(credentials))
+			for key := range credentials {
+				keys = append(keys, key)
+			}
+			if err := backend.ForgetCredentialRefs(ctx, keys); err != nil {
+				logger.Error("failed to drop credential references", "error", err)
+				return err
+			}
+		}
+
+		if externalStore != "" {
+			store, err := k8s.ParseExternalSecretStore(externalStore)
+			if err != nil {
+				return err
+			}
+			if err := backend.SetupExternalSecret(ctx, store, externalRefs); err != nil {
+				logger.Error("failed to setup external secret", "error", err)
+				return err
+			}
+		}
+
+		if podSecurity != "" {
+			if err := backend.SetupPodSecurity(ctx, podSecurity); err != nil {
+				logger.Error("failed to setup pod security", "error", err)
+				return err
+			}
+		}
+
+		if networkPolicy {
+			var network k8s.NetworkOptions
+			if err := viper.UnmarshalKey("network", &network); err != nil {
+				return fmt.Errorf("invalid network config: %w", err)
+			}
+			if err := backend.SetupNetworkPolicy(ctx, network.DNS); err != nil {
+				logger.Error("failed to setup network policy", "error", err)
+				return err
+			}
+		}
+
 		logger.Info("setup completed")
 		return nil
 	},
 }
 
+// credentialRefs parses the references to existing secrets and Vault, it
+// returns nil if there are none.
+func credentialRefs(cmd *cobra.Command) (*k8s.CredentialRefs, error) {
+	secretRefs, _ := cmd.Flags().GetStringToString("credential-ref")
+	vaultRole, _ := cmd.Flags().GetString("vault-role")
+	vaultSecrets, _ := cmd.Flags().GetStringToString("vault-secret")
+	serviceAccount, _ := cmd.Flags().GetString("service-account")
+
+	if len(secretRefs) == 0 && len(vaultSecrets) == 0 {
+		return nil, nil
+	}
+
+	refs := &k8s.CredentialRefs{Secrets: map[string]k8s.SecretKeyRef{}}
+	for key, value := range secretRefs {
+		ref, err := k8s.ParseSecretKeyRef(value)
+		if err != nil {
+			return nil, fmt.Errorf("--credential-ref %s: %w", key, err)
+		}
+		refs.Secrets[key] = ref
+	}
+
+	if len(vaultSecrets) > 0 {
+		if vaultRole == "" {
+			return nil, fmt.Errorf("--vault-secret requires --vault-role")
+		}
+		for key, value := range vaultSecrets {
+			if _, _, err := k8s.ParseVaultRef(value); err != nil {
+				return nil, fmt.Errorf("--vault-secret %s: %w", key, err)
+			}
+			// Vault secrets are only rendered into the forge containers
+			if key == "COPILOT_TOKEN" || key == "GEMINI_API_KEY" {
+				return nil, fmt.Errorf("--vault-secret %s: agent credentials can't come from vault, use --credential-ref or --external-secret", key)
+			}
+		}
+		refs.Vault = &k8s.VaultRefs{Role: vaultRole, ServiceAccount: serviceAccount, Secrets: vaultSecrets}
+	}
+	return refs, nil
+}
+
+// hasForgeCredential reports whether a forge token or GitHub App is among
+// the credential names.
+func hasForgeCredential(keys []string) bool {
+	for _, key := range keys {
+		switch {
+		case key == "GITHUB_TOKEN", key == "GITLAB_TOKEN", key == "GITEA_TOKEN", key == "GITHUB_APP_ID":
+			return true
+		case strings.HasPrefix(key, "GITHUB_TOKEN_"), strings.HasPrefix(key, "GITLAB_TOKEN_"), strings.HasPrefix(key, "GITEA_TOKEN_"):
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	rootCmd.AddCommand(setupCmd)
 
 	setupCmd.Flags().String("kubeconfig", "", "path to kubeconfig file")
 	setupCmd.Flags().String("namespace", "default", "kubernetes namespace")
 	setupCmd.Flags().String("github-token", "", "GitHub token for git/PR operations (defaults to GITHUB_TOKEN env var)")
-	setupCmd.Flags().String("copilot-token", "", "GitHub token for Copilot CLI (defaults to COPILOT_TOKEN env var, or uses GITHUB_TOKEN)")
+	setupCmd.Flags().String("github-app-id", "", "GitHub App ID, used instead of a GitHub token (defaults to GITHUB_APP_ID env var)")
+	setupCmd.Flags().String("github-app-key", "", "path to the GitHub App's private key (PEM)")
+	setupCmd.Flags().StringToString("github-host-token", nil, "GitHub Enterprise Server token per host, e.g. ghe.example.com=TOKEN (repeatable)")
+	setupCmd.Flags().String("gitlab-token", "", "gitlab.com token for fork/merge request operations (defaults to GITLAB_TOKEN env var)")
+	setupCmd.Flags().StringToString("gitlab-host-token", nil, "self-hosted GitLab token per host, e.g. gitlab.example.com=TOKEN (repeatable)")
+	setupCmd.Flags().String("gitea-token", "", "codeberg.org token for fork/pull request operations (defaults to GITEA_TOKEN env var)")
+	setupCmd.Flags().StringToString("gitea-host-token", nil, "self-hosted Gitea/Forgejo token per host, e.g. git.example.com=TOKEN (repeatable)")
+	setupCmd.Flags().String("signing-key", "", "path to an OpenSSH or GPG private key to sign commits with")
+	setupCmd.Flags().String("copilot-token", "", "GitHub token for Copilot CLI, limited to Copilot Requests (defaults to COPILOT_TOKEN env var)")
+	setupCmd.Flags().Bool("copilot-use-github-token", false, "store GITHUB_TOKEN as COPILOT_TOKEN, giving the agent its repository access (not recommended)")
+	setupCmd.Flags().StringToString("credential-ref", nil, "reference a key of an existing secret instead of storing a credential, e.g. GITHUB_TOKEN=team-secrets/github-token (repeatable)")
+	setupCmd.Flags().String("vault-role", "", "Vault Kubernetes auth role for the Vault Agent injector")
+	setupCmd.Flags().StringToString("vault-secret", nil, "inject a credential from Vault, e.g. GITHUB_TOKEN=secret/data/baca#github_token (repeatable)")
+	setupCmd.Flags().String("service-account", "", "service account the jobs run as, e.g. bound to the Vault role")
+	setupCmd.Flags().String("external-secret-store", "", "External Secrets Operator store to sync the credentials from, name or ClusterSecretStore/name")
+	setupCmd.Flags().StringToString("external-secret", nil, "sync a credential with the External Secrets Operator, e.g. GITHUB_TOKEN=baca/github#token (repeatable)")
+	setupCmd.Flags().String("profile", "", "store the credentials as profile baca-credentials-<profile>, for users or teams sharing the namespace")
+	setupCmd.Flags().String("pod-security", "", "enforce a Pod Security Standard on the namespace: restricted (recommended), baseline or privileged")
+	setupCmd.Flags().Bool("network-policy", false, "deny egress of job pods except to the hosts each job needs")
+	setupCmd.Flags().Bool("skip-preflight", false, "store the credentials without checking them and the cluster first")
+	setupCmd.Flags().String("fork-org", "", "check the forge user can fork into this organization")
+	setupCmd.Flags().String("image", k8s.DefaultImage, "runner image to check")
 	setupCmd.Flags().String("gemini-api-key", "", "Gemini API key for gemini-cli (defaults to GEMINI_API_KEY env var)")
 	setupCmd.Flags().Bool("gemini-oauth", false, "Copy OAuth credentials from ~/.gemini/ for gemini authentication")
 }
@@ -4,7 +4,8 @@ package agent
 type Config struct {
 	Name        string   // Logical agent name (e.g., "gemini-cli")
 	Command     string   // Actual command to execute (e.g., "gemini")
-	Credentials []string // Required credentials for this agent
+	Credentials []string // Keys of baca-credentials passed to the agent, it gets no others
+	Hosts       []string // API endpoints the agent needs, allowed by network policies
 }
 
 // AgentConfigs maps agent names to their configurations
@@ -12,20 +13,35 @@ var AgentConfigs = map[string]Config{
 	"gemini-cli": {
 		Name:    "gemini-cli",
 		Command: "gemini",
-		// Credentials: Either GEMINI_API_KEY OR GEMINI_oauth_creds_json + others
+		// Credentials: Either GEMINI_API_KEY OR the GEMINI_* OAuth files, which
+		// are mounted to /root/.gemini
 		// API Key: https://aistudio.google.com/apikey
 		// OAuth: Authenticate via `gemini` CLI first, then use --gemini-oauth
-		Credentials: []string{"GEMINI_API_KEY", "GEMINI_oauth_creds_json"},
+		Credentials: []string{"GEMINI_API_KEY"},
+		Hosts: []string{
+			"generativelanguage.googleapis.com",
+			"cloudcode-pa.googleapis.com",
+			"oauth2.googleapis.com",
+			"www.googleapis.com",
+		},
 	},
 	"copilot-cli": {
 		Name:    "copilot-cli",
 		Command: "copilot",
-		// Credentials: COPILOT_TOKEN or GITHUB_TOKEN (in order of precedence)
+		// Credentials: COPILOT_TOKEN, passed to copilot as GITHUB_TOKEN
 		// Generate at: https://github.com/settings/personal-access-tokens/new
 		// Required permissions: "Copilot Requests" read/write
-		// Note: If COPILOT_TOKEN not provided, falls back to GITHUB_TOKEN
-		//       Ensure your token has "Copilot Requests" permission
-		Credentials: []string{"COPILOT_TOKEN", "GITHUB_TOKEN"},
+		// Note: baca setup only stores GITHUB_TOKEN as COPILOT_TOKEN with
+		//       --copilot-use-github-token
+		Credentials: []string{"COPILOT_TOKEN"},
+		Hosts: []string{
+			"api.githubcopilot.com",
+			"api.individual.githubcopilot.com",
+			"api.business.githubcopilot.com",
+			"api.enterprise.githubcopilot.com",
+			"api.github.com",
+			"github.com",
+		},
 	},
 }
 
@@ -9,9 +9,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
+	"reflect"
 
 	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/guardrails"
+	"github.com/manno/baca/internal/metadata"
+	"github.com/manno/baca/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Executor struct {
@@ -29,16 +33,28 @@ func NewExecutor(workDir string, logger *slog.Logger) *Executor {
 func (e *Executor) Execute(ctx context.Context, c *change.Change) error {
 	e.logger.Info("executing change", "agent", c.Spec.Agent, "workDir", e.workDir)
 
-	if err := e.downloadResources(ctx, c); err != nil {
+	if err := traced(ctx, "download-resources", func(ctx context.Context) error {
+		return e.downloadResources(ctx, c)
+	}); err != nil {
 		return fmt.Errorf("failed to download resources: %w", err)
 	}
 
-	if err := e.runAgent(ctx, c); err != nil {
+	if err := traced(ctx, "agent", func(ctx context.Context) error {
+		return e.runAgent(ctx, c)
+	}, attribute.String("baca.agent", c.Spec.Agent)); err != nil {
 		return fmt.Errorf("failed to run agent: %w", err)
 	}
 
+	if err := traced(ctx, "verify", func(ctx context.Context) error {
+		return e.checkGuardrails(ctx, c)
+	}); err != nil {
+		return err
+	}
+
 	// Generate PR metadata after agent completes
-	if err := e.generatePRMetadata(ctx, c); err != nil {
+	if err := traced(ctx, "pr-metadata", func(ctx context.Context) error {
+		return e.generatePRMetadata(ctx, c)
+	}); err != nil {
 		e.logger.Error("failed to generate PR metadata", "error", err)
 		// Don't fail the job if PR metadata generation fails
 	}
@@ -47,6 +63,14 @@ func (e *Executor) Execute(ctx context.Context, c *change.Change) error {
 	return nil
 }
 
+// traced runs a step of the execution in its own span.
+func traced(ctx context.Context, name string, step func(context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := tracing.Start(ctx, name, attrs...)
+	err := step(ctx)
+	tracing.End(span, err)
+	return err
+}
+
 func (e *Executor) downloadResources(ctx context.Context, c *change.Change) error {
 	if c.Spec.AgentsMD != "" {
 		e.logger.Info("downloading agents.md", "url", c.Spec.AgentsMD)
@@ -119,6 +143,7 @@ func (e *Executor) runAgent(ctx context.Context, c *change.Change) error {
 	}
 
 	cmd.Dir = e.workDir
+	cmd.Env = agentEnv(c.Spec.Agent)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
@@ -131,34 +156,97 @@ func (e *Executor) runAgent(ctx context.Context, c *change.Change) error {
 	return nil
 }
 
+// checkGuardrails fails if the agent's changes violate spec.guardrails. In
+// revert mode offending files are reverted first and only the remaining
+// violations fail.
+func (e *Executor) checkGuardrails(ctx context.Context, c *change.Change) error {
+	g := c.Spec.Guardrails
+	if reflect.DeepEqual(g, change.GuardrailsSpec{}) {
+		return nil
+	}
+
+	branch := c.Spec.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	base := "origin/" + branch
+
+	changes, err := guardrails.Collect(ctx, e.workDir, base)
+	if err != nil {
+		return fmt.Errorf("failed to collect changes for guardrails: %w", err)
+	}
+	violations := guardrails.Check(g, changes)
+	if len(violations) == 0 {
+		e.logger.Info("guardrails passed", "files", len(changes))
+		return nil
+	}
+
+	if g.OnViolation == "revert" {
+		for _, v := range violations {
+			if v.Path != "" {
+				e.logger.Warn("reverting file that violates guardrails", "path", v.Path, "reason", v.Message)
+			}
+		}
+		if err := guardrails.Revert(ctx, e.workDir, base, changes, violations); err != nil {
+			return fmt.Errorf("failed to revert files: %w", err)
+		}
+		if changes, err = guardrails.Collect(ctx, e.workDir, base); err != nil {
+			return fmt.Errorf("failed to collect changes for guardrails: %w", err)
+		}
+		if violations = guardrails.Check(g, changes); len(violations) == 0 {
+			e.logger.Info("guardrails passed after revert", "files", len(changes))
+			return nil
+		}
+	}
+
+	for _, v := range violations {
+		e.logger.Error("guardrail violated", "path", v.Path, "reason", v.Message)
+	}
+	return &guardrails.ViolationError{Violations: violations}
+}
+
+// metadataPath is read by `baca publish`
+const metadataPath = "/workspace/pr-metadata.json"
+
+// generatePRMetadata asks the agent for the pull request metadata. If the
+// agent fails or its answer can't be parsed, a template from the prompt and
+// the diffstat is used instead.
 func (e *Executor) generatePRMetadata(ctx context.Context, c *change.Change) error {
 	e.logger.Info("generating PR metadata")
 
-	// Extract prompt without everything after ---
-	promptClean := c.Spec.Prompt
-	if idx := strings.Index(promptClean, "\n---\n"); idx != -1 {
-		promptClean = promptClean[:idx]
-	}
+	promptClean := metadata.CleanPrompt(c.Spec.Prompt)
 
 	// Prepare prompt for agent to generate PR description
 	prPrompt := fmt.Sprintf(`Review the git diff and create a pull request title and description.
 
 Requirements:
-- Title: One line, clear and descriptive (max 72 chars)
-- Body: Summarize what changed and why (2-4 sentences)
-- Add a "## Prompt" section at the end with the original prompt
+- title: One line, clear and descriptive (max %d chars)
+- body: Summarize what changed and why (2-4 sentences), in markdown
+- body: Add a "## Prompt" section at the end with the original prompt
+- labels: Optional, e.g. ["dependencies"]
+- commit_message: Optional, a conventional git commit message if the title and body don't fit
 
 Original prompt:
 %s
 
-Format your response EXACTLY as:
-TITLE: <your title here>
-BODY:
-<your description here>
+Respond with a single JSON object and nothing else:
+{"title": "...", "body": "...", "labels": [], "commit_message": ""}`, metadata.MaxTitleLength, promptClean)
 
-## Prompt
-%s`, promptClean, promptClean)
+	m, err := e.runMetadataAgent(ctx, c, prPrompt)
+	if err != nil {
+		e.logger.Warn("using PR metadata template", "reason", err)
+		m = metadata.Fallback(c.Spec.Prompt, e.diffStat(ctx, c.Spec.Branch))
+	}
 
+	if err := metadata.Write(metadataPath, m); err != nil {
+		return err
+	}
+
+	e.logger.Info("PR metadata generated", "path", metadataPath, "title", m.Title)
+	return nil
+}
+
+func (e *Executor) runMetadataAgent(ctx context.Context, c *change.Change, prPrompt string) (metadata.Metadata, error) {
 	// Run the agent to generate PR description
 	agentCommand := GetCommand(c.Spec.Agent)
 	var cmd *exec.Cmd
@@ -172,22 +260,55 @@ BODY:
 	case "gemini-cli":
 		cmd = exec.CommandContext(ctx, agentCommand, prPrompt)
 	default:
-		return fmt.Errorf("unsupported agent: %s", c.Spec.Agent)
+		return metadata.Metadata{}, fmt.Errorf("unsupported agent: %s", c.Spec.Agent)
 	}
 
 	cmd.Dir = e.workDir
+	cmd.Env = agentEnv(c.Spec.Agent)
 	cmd.Stderr = os.Stderr // Send stderr to logs, not to PR metadata
 	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("agent failed to generate PR metadata: %w", err)
+		return metadata.Metadata{}, fmt.Errorf("agent failed to generate PR metadata: %w", err)
 	}
 
-	// Write the output to /workspace/pr-metadata.txt
-	metadataPath := "/workspace/pr-metadata.txt"
-	if err := os.WriteFile(metadataPath, output, 0600); err != nil {
-		return fmt.Errorf("failed to write PR metadata: %w", err)
+	return metadata.Parse(string(output))
+}
+
+// diffStat returns the changes compared to the base branch, including
+// commits made by the agent and new files. Errors are logged and result in
+// an empty diffstat.
+func (e *Executor) diffStat(ctx context.Context, branch string) string {
+	if branch == "" {
+		branch = "main"
+	}
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = e.workDir
+		out, err := cmd.Output()
+		return string(out), err
 	}
 
-	e.logger.Info("PR metadata generated", "path", metadataPath)
-	return nil
+	// Intent-to-add makes new files show up in the diff, publish stages them anyway
+	if _, err := git("add", "--all", "--intent-to-add"); err != nil {
+		e.logger.Warn("failed to compute diffstat", "error", err)
+		return ""
+	}
+	out, err := git("diff", "--stat", "origin/"+branch)
+	if err != nil {
+		e.logger.Warn("failed to compute diffstat", "error", err)
+		return ""
+	}
+	return out
+}
+
+// agentEnv returns the environment for the agent process. Copilot reads its
+// token from GITHUB_TOKEN, so a separate COPILOT_TOKEN takes precedence there.
+func agentEnv(agentName string) []string {
+	env := os.Environ()
+	if agentName == "copilot-cli" {
+		if token := os.Getenv("COPILOT_TOKEN"); token != "" {
+			env = append(env, "GITHUB_TOKEN="+token)
+		}
+	}
+	return env
 }
@@ -4,63 +4,335 @@ import (
 	"bufio"
 	"context"
 	"crypto/rand"
-	_ "embed"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"net/url"
+	"path"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/manno/baca/internal/agent"
 	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/secrets"
+	"github.com/manno/baca/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-//go:embed scripts/fork-setup.sh
-var forkSetupScript string
+const (
+	// repoAnnotation stores the unmodified repository URL, labels are truncated.
+	repoAnnotation = "baca.io/repo"
+	// changeLabel and agentLabel tell jobs apart in metrics, changeAnnotation
+	// stores the unmodified change name
+	changeLabel      = "baca.io/change"
+	changeAnnotation = "baca.io/change"
+	agentLabel       = "baca.io/agent"
+
+	// gitDirMountPath has the repository clone and publish share
+	gitDirMountPath = "/var/run/baca/git"
+)
 
-//go:embed scripts/job-runner.sh
-var jobScript string
+// DryRunMode controls whether ApplyChange persists the rendered jobs.
+type DryRunMode string
 
-func (k *KubernetesBackend) ApplyChange(ctx context.Context, c *change.Change, wait bool, retries int32, forkOrg string) error {
-	k.logger.Info("applying change", "repos", len(c.Spec.Repos), "fork-org", forkOrg)
+const (
+	DryRunNone   DryRunMode = ""
+	DryRunClient DryRunMode = "client"
+	DryRunServer DryRunMode = "server"
+)
 
-	var jobNames []string
-	for _, repo := range c.Spec.Repos {
-		k.logger.Info("creating job for repository", "repo", repo)
+// ApplyOptions configures how a Change is turned into jobs.
+type ApplyOptions struct {
+	Wait    bool       // Wait for all jobs to finish
+	Retries int32      // BackoffLimit for each job
+	ForkOrg string     // Organization/user to create forks under (default: authenticated user)
+	DryRun  DryRunMode // Render or validate jobs without creating them
+	// Forges maps hosts to forge kinds, in addition to github.com and gitlab.com
+	Forges map[string]string
+	// Network restricts the egress of the jobs' pods
+	Network NetworkOptions
+	// Security hardens the jobs' pods
+	Security SecurityOptions
+	// TracingEndpoint is the OTLP collector URL the jobs export their spans
+	// to, they continue the trace of ApplyChange. Empty disables tracing.
+	TracingEndpoint string
+	// FollowUp updates the pull requests of a run instead of opening new
+	// ones, e.g. to fix failing CI checks
+	FollowUp *FollowUpOptions
+}
 
-		job := k.createJob(c, repo, retries, forkOrg)
+// ApplyChange creates one job per repository and returns the jobs. In
+// DryRunClient mode the cluster is never contacted, in DryRunServer mode the
+// jobs are validated by the API server but not persisted.
+func (k *KubernetesBackend) ApplyChange(ctx context.Context, c *change.Change, opts ApplyOptions) (jobs []*batchv1.Job, err error) {
+	ctx, span := tracing.Start(ctx, "apply",
+		attribute.String("baca.change", c.Metadata.Name),
+		attribute.Int("baca.repos", len(c.Spec.Repos)),
+		attribute.String("baca.dry_run", string(opts.DryRun)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	k.logger.Info("applying change", "repos", len(c.Spec.Repos), "fork-org", opts.ForkOrg, "profile", k.profile, "dry-run", opts.DryRun)
+
+	if opts.DryRun != DryRunClient {
+		if err := k.prepareSubmission(ctx); err != nil {
+			return nil, err
+		}
+	}
 
-		if err := k.client.Create(ctx, job); err != nil {
-			k.logger.Error("failed to create job in kubernetes", "repo", repo, "error", err)
-			return fmt.Errorf("failed to create kubernetes job for %s: %w", repo, err)
+	jobs, err = k.RenderChange(c, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun == DryRunClient {
+		if opts.Network.Enabled {
+			k.logger.Info("network policies are resolved when the jobs are created, they are not rendered")
 		}
+		if opts.FollowUp != nil {
+			k.logger.Info("follow-up files are created with the jobs, they are not rendered")
+		}
+		return jobs, nil
+	}
 
-		k.logger.Info("job created", "repo", repo, "job", job.Name)
+	if !opts.Network.Enabled {
+		enabled, err := k.networkPoliciesEnabled(ctx)
+		if err != nil {
+			k.logger.Warn("failed to check for the default deny network policy, not creating network policies", "error", err)
+		}
+		opts.Network.Enabled = enabled
+	}
+
+	var createOpts []client.CreateOption
+	if opts.DryRun == DryRunServer {
+		createOpts = append(createOpts, client.DryRunAll)
+	}
+
+	// Runs are recorded in the history, labeling their jobs, which are kept
+	// until the outcome is recorded
+	var runID string
+	if opts.DryRun == DryRunNone {
+		runID = k.newRunID(c)
+		span.SetAttributes(attribute.String("baca.run", runID))
+		for _, job := range jobs {
+			job.Labels[runLabel] = runID
+			job.Spec.TTLSecondsAfterFinished = int32Ptr(int32(recordedJobTTL / time.Second))
+		}
+	}
+
+	var created []*batchv1.Job
+	var jobNames []string
+	for _, job := range jobs {
+		if err := k.submitJob(ctx, c, job, opts, createOpts); err != nil {
+			k.saveRun(ctx, c, runID, created, opts.FollowUp)
+			return nil, err
+		}
+		created = append(created, job)
 		jobNames = append(jobNames, job.Name)
 	}
+	k.saveRun(ctx, c, runID, created, opts.FollowUp)
+
+	if opts.DryRun == DryRunServer {
+		k.logger.Info("server-side dry run succeeded", "jobs", len(jobs))
+		return jobs, nil
+	}
 
 	// Monitor job status if requested
-	if wait {
+	if opts.Wait {
 		k.logger.Info("monitoring jobs", "count", len(jobNames))
-		return k.monitorJobs(ctx, jobNames)
+		err := k.monitorJobs(ctx, jobNames)
+		k.refreshRun(ctx, runID)
+		return jobs, err
 	}
 
+	return jobs, nil
+}
+
+// submitJob creates the job and its network policy, in a span of the apply
+// trace the job's containers continue.
+func (k *KubernetesBackend) submitJob(ctx context.Context, c *change.Change, job *batchv1.Job, opts ApplyOptions, createOpts []client.CreateOption) (err error) {
+	repo := job.Annotations[repoAnnotation]
+	ctx, span := tracing.Start(ctx, "job",
+		attribute.String("baca.repo", repo),
+		attribute.String("baca.job", job.Name),
+	)
+	defer func() { tracing.End(span, err) }()
+	if opts.TracingEndpoint != "" {
+		addEnv(&job.Spec.Template.Spec, tracing.Env(ctx))
+	}
+
+	k.logger.Info("creating job for repository", "repo", repo)
+
+	// The policy is created first, so the pod never runs unrestricted
+	policy, err := k.createNetworkPolicy(ctx, c, job, opts, createOpts)
+	if err != nil {
+		k.logger.Error("failed to create network policy", "repo", repo, "error", err)
+		return err
+	}
+
+	followUp, err := opts.FollowUp.followUp(repo)
+	if err != nil {
+		return err
+	}
+	files, err := k.createFollowUpFiles(ctx, job, followUp, createOpts)
+	if err != nil {
+		if policy != nil && opts.DryRun == DryRunNone {
+			_ = k.client.Delete(ctx, policy)
+		}
+		return err
+	}
+
+	if err := k.client.Create(ctx, job, createOpts...); err != nil {
+		k.logger.Error("failed to create job in kubernetes", "repo", repo, "error", err)
+		if opts.DryRun == DryRunNone {
+			if policy != nil {
+				_ = k.client.Delete(ctx, policy)
+			}
+			if files != nil {
+				_ = k.client.Delete(ctx, files)
+			}
+		}
+		return fmt.Errorf("failed to create kubernetes job for %s: %w", repo, err)
+	}
+
+	if policy != nil && opts.DryRun == DryRunNone {
+		if err := k.ownByJob(ctx, policy, job); err != nil {
+			k.logger.Warn("failed to set owner of network policy, it won't be deleted with the job", "policy", policy.Name, "error", err)
+		}
+	}
+	if files != nil && opts.DryRun == DryRunNone {
+		if err := k.ownByJob(ctx, files, job); err != nil {
+			k.logger.Warn("failed to set owner of follow-up files, they won't be deleted with the job", "configmap", files.Name, "error", err)
+		}
+	}
+
+	k.logger.Info("job created", "repo", repo, "job", job.Name)
+	return nil
+}
+
+// ownByJob makes the job own obj, e.g. its network policy, so it is deleted
+// along with the job.
+func (k *KubernetesBackend) ownByJob(ctx context.Context, obj client.Object, job *batchv1.Job) error {
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion:         batchv1.SchemeGroupVersion.String(),
+		Kind:               "Job",
+		Name:               job.Name,
+		UID:                job.UID,
+		BlockOwnerDeletion: boolPtr(true),
+	}})
+	return k.client.Update(ctx, obj)
+}
+
+// prepareSubmission looks up the submitting user, to label the jobs with,
+// checks that the profile's credentials exist and belong to the user, and
+// warns if they expire.
+func (k *KubernetesBackend) prepareSubmission(ctx context.Context) error {
+	identity, err := k.Identity(ctx)
+	switch {
+	case err != nil && k.profile != "":
+		// Without the identity the jobs and the profile's owner can't be
+		// checked
+		return fmt.Errorf("profile %q needs the submitting user: %w", k.profile, err)
+	case err != nil:
+		k.logger.Warn("failed to identify the submitting user, jobs are not labeled with it", "error", err)
+	default:
+		k.logger.Info("submitting as", "user", identity)
+	}
+
+	if err := k.loadCredentialRefs(ctx); err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	err = k.client.Get(ctx, client.ObjectKey{Name: k.secretName(), Namespace: k.namespace}, secret)
+	switch {
+	case err == nil:
+		if err := k.checkOwner(ctx, secret); err != nil {
+			return fmt.Errorf("can't use profile %q: %w", k.profile, err)
+		}
+		k.warnExpiringCredentials(secret)
+	case k.profile == "" || k.refs != nil:
+		// The secret is optional, or may only be readable by the jobs
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("credentials for profile %q not found: run 'baca setup --profile %s' first", k.profile, k.profile)
+	default:
+		return fmt.Errorf("failed to get credentials for profile %q: %w", k.profile, err)
+	}
 	return nil
 }
 
-func (k *KubernetesBackend) createJob(c *change.Change, repoURL string, retries int32, forkOrg string) *batchv1.Job {
+// createNetworkPolicy creates the egress allowlist for the job's pods, if
+// network policies are enabled. It returns nil otherwise.
+func (k *KubernetesBackend) createNetworkPolicy(ctx context.Context, c *change.Change, job *batchv1.Job, opts ApplyOptions, createOpts []client.CreateOption) (*networkingv1.NetworkPolicy, error) {
+	if !opts.Network.Enabled {
+		return nil, nil
+	}
+	upstream, err := forge.ParseRepoURL(job.Annotations[repoAnnotation])
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := egressHosts(c, upstream, opts.Network)
+	cidrs := append(append([]string{}, opts.Network.AllowedCIDRs...), c.Spec.Network.AllowedCIDRs...)
+	policy, err := k.renderNetworkPolicy(ctx, job, hosts, cidrs, opts.Network.DNS, opts.TracingEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.client.Create(ctx, policy, createOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create network policy for %s: %w", upstream.String(), err)
+	}
+	k.logger.Info("network policy created", "policy", policy.Name, "hosts", hosts, "cidrs", cidrs)
+	return policy, nil
+}
+
+// RenderChange builds the jobs for all repositories of a Change without
+// contacting the cluster.
+func (k *KubernetesBackend) RenderChange(c *change.Change, opts ApplyOptions) ([]*batchv1.Job, error) {
+	jobs := make([]*batchv1.Job, 0, len(c.Spec.Repos))
+	for _, repo := range c.Spec.Repos {
+		job, err := k.createJob(c, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render job for %s: %w", repo, err)
+		}
+		if !opts.Security.AllowRoot {
+			if violations := restrictedViolations(job.Spec.Template.Spec); len(violations) > 0 {
+				return nil, fmt.Errorf("job for %s violates the restricted pod security standard: %s", repo, strings.Join(violations, "; "))
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (k *KubernetesBackend) createJob(c *change.Change, repoURL string, opts ApplyOptions) (*batchv1.Job, error) {
+	upstream, err := forge.ParseRepoURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	forgeKind, err := forge.KindForHost(upstream.Host, opts.Forges)
+	if err != nil {
+		return nil, err
+	}
+	followUp, err := opts.FollowUp.followUp(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
 	jobName := k.generateJobName(repoURL)
+	secretName := k.secretName()
 	image := c.Spec.Image
 	if image == "" {
 		image = DefaultImage
 	}
 
 	// Use retries from command-line argument
-	backoffLimit := retries
+	backoffLimit := opts.Retries
 
 	// Shared volume for repository
 	sharedVolume := corev1.Volume{
@@ -75,133 +347,241 @@ func (k *KubernetesBackend) createJob(c *change.Change, repoURL string, retries
 		MountPath: "/workspace",
 	}
 
+	// Repository publish commits in, the agent doesn't mount it, so it can't
+	// plant hooks or config there
+	gitVolume := corev1.Volume{
+		Name: "git",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+	gitMount := corev1.VolumeMount{
+		Name:      "git",
+		MountPath: gitDirMountPath,
+	}
+
+	// Follow-ups run on the pull request's head branch, which the agent's
+	// changes are compared to
+	spec := c.Spec
+	if followUp != nil {
+		spec.Branch = followUp.HeadBranch
+		if followUp.Prompt != "" {
+			spec.Prompt = followUp.Prompt
+		}
+	}
+	branch := spec.Branch
+	if branch == "" {
+		branch = "main"
+	}
+
+	// gh and the agents' GitHub integrations need to know the enterprise host
+	var forgeEnv []corev1.EnvVar
+	if forgeKind == forge.GitHub && upstream.Host != "github.com" {
+		hostToken := k.credentialEnv(forge.HostTokenEnv(forgeKind, upstream.Host))
+		hostToken.Name = "GH_ENTERPRISE_TOKEN"
+		forgeEnv = append(forgeEnv, corev1.EnvVar{
+			Name:  "GH_HOST",
+			Value: upstream.Host,
+		}, hostToken)
+	}
+	// Referenced credentials, for all containers but the agent
+	forgeEnv = append(forgeEnv, k.refEnv()...)
+	if k.refs != nil && k.refs.Vault != nil {
+		forgeEnv = append(forgeEnv, corev1.EnvVar{
+			Name:  credentialFilesEnv,
+			Value: vaultSecretsDir,
+		})
+	}
+
 	// Init container 1: Create/sync fork
 	forkSetupContainer := corev1.Container{
 		Name:            "fork-setup",
 		Image:           image,
 		ImagePullPolicy: corev1.PullIfNotPresent,
-		Command:         []string{"sh", "-c", forkSetupScript},
-		VolumeMounts:    []corev1.VolumeMount{workspaceMount},
-		Env: []corev1.EnvVar{
+		Command: []string{
+			"baca", "fork-setup",
+			"--repo-url", "$(ORIGINAL_REPO_URL)",
+			"--fork-org", "$(FORK_ORG)",
+			"--branch", branch,
+			"--forge", string(forgeKind),
+			"--output", "/workspace/fork-url.txt",
+		},
+		VolumeMounts: []corev1.VolumeMount{workspaceMount},
+		Env: append([]corev1.EnvVar{
 			{
 				Name:  "ORIGINAL_REPO_URL",
 				Value: repoURL,
 			},
 			{
 				Name:  "FORK_ORG",
-				Value: forkOrg,
+				Value: opts.ForkOrg,
 			},
-		},
+		}, forgeEnv...),
 		EnvFrom: []corev1.EnvFromSource{
 			{
 				SecretRef: &corev1.SecretEnvSource{
 					LocalObjectReference: corev1.LocalObjectReference{
-						Name: "baca-credentials",
+						Name: secretName,
 					},
+					// All credentials may be referenced elsewhere
+					Optional: boolPtr(k.refs != nil),
 				},
 			},
 		},
 	}
 
-	// Init container 2: Clone fork repository with fleet
-	branch := c.Spec.Branch
-	if branch == "" {
-		branch = "main"
+	// Init container 2: Clone the fork repository, the URL is stored by the
+	// fork-setup container. In branch mode there is no fork, upstream is
+	// cloned directly. Follow-ups clone the pull request's head.
+	cloneCommand := []string{
+		"baca", "clone",
+		"--fork-url-file", "/workspace/fork-url.txt",
+		"--branch", branch,
+		"--forge", string(forgeKind),
+		"--dir", "/workspace/repo",
+		"--git-dir", gitDirMountPath + "/repo.git",
+	}
+	switch {
+	case followUp != nil:
+		cloneCommand = append(cloneCommand, "--repo-url", followUp.HeadURL)
+	case c.Spec.Publish.Mode == change.PublishBranch:
+		cloneCommand = append(cloneCommand, "--repo-url", "$(ORIGINAL_REPO_URL)")
+	default:
+		// Not all forges can sync the fork
+		cloneCommand = append(cloneCommand, "--upstream-url", "$(ORIGINAL_REPO_URL)")
 	}
-
-	// Clone from the fork (URL stored by fork-setup container)
 	gitCloneContainer := corev1.Container{
 		Name:            "git-clone",
 		Image:           image,
 		ImagePullPolicy: corev1.PullIfNotPresent,
-		Command: []string{
-			"sh", "-c",
-			fmt.Sprintf("FORK_URL=$(cat /workspace/fork-url.txt); fleet gitcloner --branch %s \"$FORK_URL\" /workspace/repo", branch),
-		},
-		VolumeMounts: []corev1.VolumeMount{workspaceMount},
+		Command:         cloneCommand,
+		VolumeMounts:    []corev1.VolumeMount{workspaceMount, gitMount},
+		Env: append([]corev1.EnvVar{
+			{
+				Name:  "ORIGINAL_REPO_URL",
+				Value: repoURL,
+			},
+		}, forgeEnv...),
 		EnvFrom: []corev1.EnvFromSource{
 			{
 				SecretRef: &corev1.SecretEnvSource{
 					LocalObjectReference: corev1.LocalObjectReference{
-						Name: "baca-credentials",
+						Name: secretName,
 					},
+					// All credentials may be referenced elsewhere
+					Optional: boolPtr(k.refs != nil),
 				},
 			},
 		},
 	}
 
 	// Build JSON config for execute command
-	configJSON, err := json.Marshal(c.Spec)
+	configJSON, err := json.Marshal(spec)
 	if err != nil {
 		k.logger.Error("failed to marshal config to JSON", "error", err)
 		// Fallback to empty but this shouldn't happen
 		configJSON = []byte("{}")
 	}
 
-	// Main container: Run baca execute with agent
-	container := corev1.Container{
-		Name:            "runner",
+	// Init container 3: Run the agent. It only gets its own credentials, the
+	// forge tokens stay in the other containers, which share nothing but the
+	// workspace, home and tmp with it.
+	agentEnv := []corev1.EnvVar{
+		{
+			Name:  "CONFIG",
+			Value: string(configJSON),
+		},
+	}
+	if config, ok := agent.GetConfig(c.Spec.Agent); ok {
+		for _, key := range config.Credentials {
+			agentEnv = append(agentEnv, k.credentialEnv(key))
+		}
+	}
+	if forgeKind == forge.GitHub && upstream.Host != "github.com" {
+		agentEnv = append(agentEnv, corev1.EnvVar{
+			Name:  "GH_HOST",
+			Value: upstream.Host,
+		})
+	}
+	agentContainer := corev1.Container{
+		Name:            "agent",
 		Image:           image,
 		ImagePullPolicy: corev1.PullIfNotPresent,
-		Command:         []string{"bash", "-c", jobScript},
+		Command:         []string{"baca", "execute", "--config", "$(CONFIG)", "--work-dir", "/workspace/repo"},
 		VolumeMounts:    []corev1.VolumeMount{workspaceMount},
-		Env: []corev1.EnvVar{
+		Env:             agentEnv,
+	}
+
+	// Main container: Push the agent's changes and open the PR. The
+	// credentials are mounted as files as well, so baca publish can scan the
+	// changes for all of them.
+	publishCommand := []string{
+		"baca", "publish",
+		"--config", "$(CONFIG)",
+		"--work-dir", "/workspace/repo",
+		"--git-dir", gitDirMountPath + "/repo.git",
+		"--repo-url", "$(ORIGINAL_REPO_URL)",
+		"--forge", string(forgeKind),
+		"--fork-url-file", "/workspace/fork-url.txt",
+	}
+	if followUp != nil {
+		publishCommand = append(publishCommand, "--pull-request-url", followUp.PullRequestURL, "--head-url", followUp.HeadURL)
+		if followUp.ReviewThreads != "" {
+			publishCommand = append(publishCommand, "--review-threads", path.Join(FollowUpDir, followUp.ReviewThreads))
+		}
+	}
+	container := corev1.Container{
+		Name:            "publish",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         publishCommand,
+		VolumeMounts: []corev1.VolumeMount{workspaceMount, gitMount, {
+			Name:      "credentials",
+			MountPath: "/var/run/baca/credentials",
+			ReadOnly:  true,
+		}},
+		Env: append([]corev1.EnvVar{
 			{
 				Name:  "CONFIG",
 				Value: string(configJSON),
 			},
-			{
-				Name:  "REPO_URL",
-				Value: repoURL,
-			},
 			{
 				Name:  "ORIGINAL_REPO_URL",
 				Value: repoURL,
 			},
-			{
-				Name:  "PROMPT",
-				Value: c.Spec.Prompt,
-			},
-		},
+		}, forgeEnv...),
 		EnvFrom: []corev1.EnvFromSource{
 			{
 				SecretRef: &corev1.SecretEnvSource{
 					LocalObjectReference: corev1.LocalObjectReference{
-						Name: "baca-credentials",
+						Name: secretName,
 					},
+					// All credentials may be referenced elsewhere
+					Optional: boolPtr(k.refs != nil),
 				},
 			},
 		},
 	}
 
-	podSpec := corev1.PodSpec{
-		RestartPolicy:  corev1.RestartPolicyNever,
-		InitContainers: []corev1.Container{forkSetupContainer, gitCloneContainer},
-		Containers:     []corev1.Container{container},
-		Volumes:        []corev1.Volume{sharedVolume},
-	}
-
 	// Mount gemini OAuth files if using gemini-cli
+	var volumes []corev1.Volume
 	if c.Spec.Agent == "gemini-cli" {
-		// Add gemini OAuth volume mount to the container
-		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		agentContainer.VolumeMounts = append(agentContainer.VolumeMounts, corev1.VolumeMount{
 			Name:      "gemini-oauth",
-			MountPath: "/root/.gemini",
+			MountPath: homeDir + "/.gemini",
 			ReadOnly:  true,
 		})
-		podSpec.Containers[0] = container
-
-		// Add gemini OAuth volume to pod volumes
-		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		volumes = append(volumes, corev1.Volume{
 			Name: "gemini-oauth",
 			VolumeSource: corev1.VolumeSource{
 				Secret: &corev1.SecretVolumeSource{
-					SecretName: "baca-credentials",
+					SecretName: secretName,
+					// Readable by the fsGroup, the agent doesn't run as root
 					Items: []corev1.KeyToPath{
-						{Key: "GEMINI_oauth_creds.json", Path: "oauth_creds.json", Mode: int32Ptr(0600)},
-						{Key: "GEMINI_google_accounts.json", Path: "google_accounts.json", Mode: int32Ptr(0600)},
-						{Key: "GEMINI_installation_id", Path: "installation_id", Mode: int32Ptr(0600)},
-						{Key: "GEMINI_settings.json", Path: "settings.json", Mode: int32Ptr(0600)},
+						{Key: "GEMINI_oauth_creds.json", Path: "oauth_creds.json", Mode: int32Ptr(0440)},
+						{Key: "GEMINI_google_accounts.json", Path: "google_accounts.json", Mode: int32Ptr(0440)},
+						{Key: "GEMINI_installation_id", Path: "installation_id", Mode: int32Ptr(0440)},
+						{Key: "GEMINI_settings.json", Path: "settings.json", Mode: int32Ptr(0440)},
 					},
 					Optional: boolPtr(true), // Optional in case using API key instead
 				},
@@ -209,16 +589,56 @@ func (k *KubernetesBackend) createJob(c *change.Change, repoURL string, retries
 		})
 	}
 
+	if followUp != nil && len(followUp.Files) > 0 {
+		volume, mount := followUpVolume(jobName)
+		agentContainer.VolumeMounts = append(agentContainer.VolumeMounts, mount)
+		volumes = append(volumes, volume)
+		if followUp.ReviewThreads != "" {
+			container.VolumeMounts = append(container.VolumeMounts, mount)
+		}
+	}
+
+	// The fork of follow-ups exists already
+	initContainers := []corev1.Container{forkSetupContainer, gitCloneContainer, agentContainer}
+	if c.Spec.Publish.Mode == change.PublishBranch || followUp != nil {
+		initContainers = []corev1.Container{gitCloneContainer, agentContainer}
+	}
+
+	podSpec := corev1.PodSpec{
+		RestartPolicy:  corev1.RestartPolicyNever,
+		InitContainers: initContainers,
+		Containers:     []corev1.Container{container},
+		Volumes:        append([]corev1.Volume{sharedVolume, gitVolume, k.credentialsVolume()}, volumes...),
+	}
+	if k.refs != nil && k.refs.Vault != nil && k.refs.Vault.ServiceAccount != "" {
+		podSpec.ServiceAccountName = k.refs.Vault.ServiceAccount
+	}
+	opts.Security.harden(&podSpec)
+	setTerminationLog(&podSpec)
+	if opts.TracingEndpoint != "" {
+		addEnv(&podSpec, map[string]string{tracing.EndpointEnv: opts.TracingEndpoint})
+	}
+
 	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.String(),
+			Kind:       "Job",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      jobName,
 			Namespace: k.namespace,
+			Annotations: map[string]string{
+				repoAnnotation:   repoURL,
+				changeAnnotation: c.Metadata.Name,
+			},
 			Labels: map[string]string{
 				"app":                          "background-automated-code-agent",
 				"app.kubernetes.io/name":       "baca",
 				"app.kubernetes.io/component":  "job",
 				"app.kubernetes.io/managed-by": "baca-cli",
-				"repo":                         k.sanitizeLabel(repoURL),
+				"repo":                         k.sanitizeLabel(upstream.String()),
+				changeLabel:                    k.sanitizeLabel(c.Metadata.Name),
+				agentLabel:                     k.sanitizeLabel(c.Spec.Agent),
 			},
 		},
 		Spec: batchv1.JobSpec{
@@ -226,23 +646,68 @@ func (k *KubernetesBackend) createJob(c *change.Change, repoURL string, retries
 			TTLSecondsAfterFinished: int32Ptr(300),          // Clean up after 5 minutes
 			BackoffLimit:            int32Ptr(backoffLimit), // Configurable retries (default: 0)
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: k.vaultAnnotations(forgeContainers(podSpec)),
+					// Selected by the network policies
+					Labels: map[string]string{
+						"app.kubernetes.io/name": "baca",
+						runIDLabel:               jobName,
+					},
+				},
 				Spec: podSpec,
 			},
 		},
 	}
 
-	return job
+	// Tenants sharing the namespace are told apart by profile and submitter
+	if k.profile != "" {
+		job.Labels[profileLabel] = k.profile
+	}
+	if k.identity != "" {
+		job.Annotations[submittedByAnnotation] = k.identity
+		job.Labels[submittedByLabel] = k.sanitizeLabel(k.identity)
+	}
+
+	return job, nil
+}
+
+// forgeContainers returns the names of all containers except the agent.
+func forgeContainers(spec corev1.PodSpec) []string {
+	var names []string
+	for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		if c.Name != "agent" {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// addEnv sets the environment variables on all containers of spec.
+func addEnv(spec *corev1.PodSpec, env map[string]string) {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			for _, name := range names {
+				containers[i].Env = append(containers[i].Env, corev1.EnvVar{Name: name, Value: env[name]})
+			}
+		}
+	}
 }
 
 func (k *KubernetesBackend) generateJobName(repoURL string) string {
-	u, err := url.Parse(repoURL)
-	if err != nil || u.Scheme == "" {
+	repo, err := forge.ParseRepoURL(repoURL)
+	if err != nil {
 		return fmt.Sprintf("baca-job-%s", generateRandomSuffix())
 	}
 
-	path := strings.TrimPrefix(u.Path, "/")
-	path = strings.TrimSuffix(path, ".git")
-	path = strings.ReplaceAll(path, "/", "-")
+	// The host is left out, names only need to be unique within a change
+	path := strings.ReplaceAll(repo.FullName(), "/", "-")
+	path = strings.ReplaceAll(path, ".", "-")
+	path = strings.ReplaceAll(path, "_", "-")
 	path = strings.ToLower(path)
 
 	// Calculate max length: 63 (k8s limit) - len("baca-") - len("-") - 8 (suffix)
@@ -271,22 +736,34 @@ func generateRandomSuffix() string {
 func (k *KubernetesBackend) sanitizeLabel(s string) string {
 	s = strings.ReplaceAll(s, "https://", "")
 	s = strings.ReplaceAll(s, "http://", "")
-	s = strings.ReplaceAll(s, "/", "-")
-	s = strings.ReplaceAll(s, ".", "-")
-	s = strings.ToLower(s)
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, s)
 
 	if len(s) > 63 {
 		s = s[:63]
 	}
 
-	return s
+	// Label values must end with an alphanumeric character
+	return strings.TrimRight(s, "-_")
 }
 
+// GetJobStatus returns the status of a job. Jobs submitted by other users
+// are refused.
 func (k *KubernetesBackend) GetJobStatus(ctx context.Context, jobName string) (string, error) {
 	job := &batchv1.Job{}
 	if err := k.client.Get(ctx, client.ObjectKey{Name: jobName, Namespace: k.namespace}, job); err != nil {
 		return "", fmt.Errorf("failed to get job: %w", err)
 	}
+	if err := k.checkOwner(ctx, job); err != nil {
+		return "", err
+	}
 
 	// Check job conditions
 	for _, condition := range job.Status.Conditions {
@@ -392,17 +869,36 @@ func (k *KubernetesBackend) printPodLogs(ctx context.Context, jobName string) {
 	k.logger.Info("=== Pod logs for job ===", "job", jobName, "pod", pod.Name)
 
 	// Get logs from all containers
+	scanner := k.logScanner(ctx)
 	for _, container := range pod.Spec.InitContainers {
-		k.printContainerLogs(ctx, pod.Name, container.Name, true)
+		k.printContainerLogs(ctx, scanner, pod.Name, container.Name, true)
 	}
 	for _, container := range pod.Spec.Containers {
-		k.printContainerLogs(ctx, pod.Name, container.Name, false)
+		k.printContainerLogs(ctx, scanner, pod.Name, container.Name, false)
 	}
 
 	k.logger.Info("=== End of logs ===", "job", jobName)
 }
 
-func (k *KubernetesBackend) printContainerLogs(ctx context.Context, podName, containerName string, isInit bool) {
+// logScanner returns a scanner for the credentials in the profile's secret and
+// the local environment. If the secret can't be read, e.g. for lack of
+// permissions, only the environment and token patterns are masked.
+func (k *KubernetesBackend) logScanner(ctx context.Context) *secrets.Scanner {
+	credentials := secrets.LoadEnv()
+	secret := &corev1.Secret{}
+	if err := k.client.Get(ctx, client.ObjectKey{Name: k.secretName(), Namespace: k.namespace}, secret); err != nil {
+		k.logger.Debug("failed to read credentials for masking logs", "error", err)
+		return secrets.NewScanner(credentials)
+	}
+	for key, value := range secret.Data {
+		credentials[key] = string(value)
+	}
+	return secrets.NewScanner(credentials)
+}
+
+// printContainerLogs prints the logs of a container to stdout, credentials
+// found by scanner are masked.
+func (k *KubernetesBackend) printContainerLogs(ctx context.Context, scanner *secrets.Scanner, podName, containerName string, isInit bool) {
 	containerType := "container"
 	if isInit {
 		containerType = "init-container"
@@ -421,13 +917,13 @@ func (k *KubernetesBackend) printContainerLogs(ctx context.Context, podName, con
 
 	k.logger.Info("--- Logs from "+containerType+" ---", "container", containerName)
 
-	scanner := bufio.NewScanner(logs)
-	for scanner.Scan() {
+	lines := bufio.NewScanner(logs)
+	for lines.Scan() {
 		// Print directly to stdout (not as structured log)
-		fmt.Println(scanner.Text())
+		fmt.Println(scanner.Mask(lines.Text()))
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := lines.Err(); err != nil {
 		k.logger.Error("error reading logs", "error", err)
 	}
 }
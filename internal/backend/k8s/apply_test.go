@@ -1,8 +1,15 @@
 package k8s
 
 import (
+	"bytes"
+	"io"
+	"log/slog"
 	"strings"
 	"testing"
+
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/tracing"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestGenerateJobName(t *testing.T) {
@@ -43,6 +50,16 @@ func TestGenerateJobName(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name:    "ssh URL on an enterprise host",
+			repoURL: "git@ghe.example.com:platform/deploy.tools.git",
+			wantCheck: func(jobName string) error {
+				if !strings.HasPrefix(jobName, "baca-platform-deploy-tools-") {
+					t.Errorf("expected job name to start with 'baca-platform-deploy-tools-', got %s", jobName)
+				}
+				return nil
+			},
+		},
 		{
 			name:    "invalid URL (no scheme)",
 			repoURL: "not-a-valid-url",
@@ -132,3 +149,295 @@ func TestGenerateRandomSuffix(t *testing.T) {
 		t.Errorf("suffix collision rate too high: %.2f%% unique", uniqueRate*100)
 	}
 }
+
+func TestRenderChange(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos: []string{
+				"https://github.com/example/repo1",
+				"https://github.com/example/repo2",
+			},
+			Agent: "copilot-cli",
+		},
+	}
+
+	jobs, err := k.ApplyChange(t.Context(), c, ApplyOptions{DryRun: DryRunClient, Retries: 2})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs, got %d", len(jobs))
+	}
+	for i, job := range jobs {
+		if job.Namespace != "baca-jobs" {
+			t.Errorf("expected namespace baca-jobs, got %s", job.Namespace)
+		}
+		if job.Annotations[repoAnnotation] != c.Spec.Repos[i] {
+			t.Errorf("expected repo annotation %s, got %s", c.Spec.Repos[i], job.Annotations[repoAnnotation])
+		}
+		if *job.Spec.BackoffLimit != 2 {
+			t.Errorf("expected backoff limit 2, got %d", *job.Spec.BackoffLimit)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteManifests(&buf, jobs, "yaml"); err != nil {
+		t.Fatalf("failed to write manifests: %v", err)
+	}
+	out := buf.String()
+	if strings.Count(out, "kind: Job\n") != 2 {
+		t.Errorf("expected two Job documents, got:\n%s", out)
+	}
+	if strings.Count(out, "\n---\n") != 1 {
+		t.Errorf("expected documents to be separated by ---, got:\n%s", out)
+	}
+	if !strings.Contains(out, "apiVersion: batch/v1") {
+		t.Errorf("expected apiVersion batch/v1 in manifests")
+	}
+}
+
+func TestRenderChangeEnterpriseHost(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos:  []string{"https://ghe.example.com/platform/deploy"},
+			Agent:  "copilot-cli",
+		},
+	}
+
+	if _, err := k.RenderChange(c, ApplyOptions{}); err == nil {
+		t.Fatal("expected error for a host missing from the forges config")
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{Forges: map[string]string{"ghe.example.com": "github"}})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	job := jobs[0]
+	if job.Labels["repo"] != "ghe-example-com-platform-deploy" {
+		t.Errorf("unexpected repo label %s", job.Labels["repo"])
+	}
+
+	for _, container := range append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers...) {
+		env := map[string]corev1.EnvVar{}
+		for _, e := range container.Env {
+			env[e.Name] = e
+		}
+		if env["GH_HOST"].Value != "ghe.example.com" {
+			t.Errorf("%s: expected GH_HOST ghe.example.com, got %q", container.Name, env["GH_HOST"].Value)
+		}
+		ref := env["GH_ENTERPRISE_TOKEN"].ValueFrom
+		if container.Name == "agent" {
+			if ref != nil {
+				t.Errorf("agent: expected no forge token, got %+v", ref)
+			}
+			continue
+		}
+		if ref == nil || ref.SecretKeyRef.Key != "GITHUB_TOKEN_GHE_EXAMPLE_COM" {
+			t.Errorf("%s: expected GH_ENTERPRISE_TOKEN from the host token, got %+v", container.Name, ref)
+		}
+	}
+}
+
+func TestRenderChangeBranchMode(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt:  "Add tests",
+			Repos:   []string{"https://github.com/example/repo1"},
+			Agent:   "copilot-cli",
+			Publish: change.PublishSpec{Mode: change.PublishBranch},
+		},
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	initContainers := jobs[0].Spec.Template.Spec.InitContainers
+	if len(initContainers) != 2 || initContainers[0].Name != "git-clone" || initContainers[1].Name != "agent" {
+		t.Fatalf("expected only the git-clone and agent init containers, got %+v", initContainers)
+	}
+	if !strings.Contains(strings.Join(initContainers[0].Command, " "), "--repo-url $(ORIGINAL_REPO_URL)") {
+		t.Errorf("expected upstream to be cloned, got %v", initContainers[0].Command)
+	}
+	config := jobs[0].Spec.Template.Spec.Containers[0].Env[0]
+	if config.Name != "CONFIG" || !strings.Contains(config.Value, `"Mode":"branch"`) {
+		t.Errorf("expected publish mode in config, got %s", config.Value)
+	}
+}
+
+func TestRenderChangeAgentCredentials(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos:  []string{"https://github.com/example/repo1"},
+			Agent:  "copilot-cli",
+		},
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	spec := jobs[0].Spec.Template.Spec
+	if len(spec.InitContainers) != 3 {
+		t.Fatalf("expected fork-setup, git-clone and agent init containers, got %d", len(spec.InitContainers))
+	}
+
+	agent := spec.InitContainers[2]
+	if agent.Name != "agent" || len(agent.EnvFrom) != 0 {
+		t.Fatalf("expected agent container without the whole secret, got %+v", agent)
+	}
+	var keys []string
+	for _, e := range agent.Env {
+		if e.ValueFrom != nil {
+			keys = append(keys, e.ValueFrom.SecretKeyRef.Key)
+		}
+	}
+	if len(keys) != 1 || keys[0] != "COPILOT_TOKEN" {
+		t.Errorf("expected only COPILOT_TOKEN for the agent, got %v", keys)
+	}
+
+	publish := spec.Containers[0]
+	if publish.Name != "publish" || len(publish.EnvFrom) != 1 || publish.EnvFrom[0].SecretRef.Name != "baca-credentials" {
+		t.Errorf("expected publish container with the credentials, got %+v", publish)
+	}
+	if hasMount(agent, gitDirMountPath) || !hasMount(spec.InitContainers[1], gitDirMountPath) || !hasMount(publish, gitDirMountPath) {
+		t.Errorf("expected the git dir to be mounted in git-clone and publish only")
+	}
+	if clone := strings.Join(spec.InitContainers[1].Command, " "); !strings.Contains(clone, "--upstream-url $(ORIGINAL_REPO_URL)") {
+		t.Errorf("expected the fork to be fast-forwarded to upstream, got %s", clone)
+	}
+}
+
+func TestRenderChangeTracing(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos:  []string{"https://github.com/example/repo1"},
+			Agent:  "copilot-cli",
+		},
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, container := range jobs[0].Spec.Template.Spec.InitContainers {
+		if hasEnvName(container, tracing.EndpointEnv) {
+			t.Errorf("expected no tracing without an endpoint in %s", container.Name)
+		}
+	}
+
+	jobs, err = k.RenderChange(c, ApplyOptions{TracingEndpoint: "http://otel-collector.monitoring:4318"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := jobs[0].Spec.Template.Spec
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		if !hasEnv(container, tracing.EndpointEnv, "http://otel-collector.monitoring:4318") {
+			t.Errorf("expected %s to export spans", container.Name)
+		}
+	}
+}
+
+func TestRenderChangeFollowUp(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos:  []string{"https://github.com/example/repo1"},
+			Agent:  "copilot-cli",
+		},
+	}
+	opts := ApplyOptions{FollowUp: &FollowUpOptions{
+		Kind: FollowUpCI,
+		Run:  "add-tests-20260101-120000-abcd",
+		PullRequests: map[string]FollowUp{
+			"https://github.com/example/repo1": {
+				PullRequestURL: "https://github.com/example/repo1/pull/7",
+				HeadURL:        "https://github.com/baca-bot/repo1",
+				HeadBranch:     "baca/add-tests",
+				Prompt:         "Fix the failing checks",
+				Files:          map[string]string{"ci-logs.md": "FAIL"},
+			},
+		},
+	}}
+
+	jobs, err := k.RenderChange(c, opts)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	spec := jobs[0].Spec.Template.Spec
+	if len(spec.InitContainers) != 2 || spec.InitContainers[0].Name != "git-clone" || spec.InitContainers[1].Name != "agent" {
+		t.Fatalf("expected no fork-setup for a follow-up, got %+v", spec.InitContainers)
+	}
+	if clone := strings.Join(spec.InitContainers[0].Command, " "); !strings.Contains(clone, "--repo-url https://github.com/baca-bot/repo1") {
+		t.Errorf("expected the head repository to be cloned, got %s", clone)
+	}
+	publish := strings.Join(spec.Containers[0].Command, " ")
+	if !strings.Contains(publish, "--pull-request-url https://github.com/example/repo1/pull/7") {
+		t.Errorf("expected publish to update the pull request, got %s", publish)
+	}
+	config := spec.Containers[0].Env[0]
+	if !strings.Contains(config.Value, `"Branch":"baca/add-tests"`) || !strings.Contains(config.Value, `"Prompt":"Fix the failing checks"`) {
+		t.Errorf("expected head branch and follow-up prompt in config, got %s", config.Value)
+	}
+	if !hasMount(spec.InitContainers[1], FollowUpDir) {
+		t.Errorf("expected follow-up files mounted in the agent container")
+	}
+	if hasMount(spec.Containers[0], FollowUpDir) {
+		t.Errorf("expected no follow-up files in the publish container without review threads")
+	}
+
+	review := opts.FollowUp.PullRequests["https://github.com/example/repo1"]
+	review.Files = map[string]string{"review-threads.json": "[]"}
+	review.ReviewThreads = "review-threads.json"
+	opts.FollowUp = &FollowUpOptions{Kind: FollowUpReview, Run: opts.FollowUp.Run, PullRequests: map[string]FollowUp{"https://github.com/example/repo1": review}}
+	jobs, err = k.RenderChange(c, opts)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	publishContainer := jobs[0].Spec.Template.Spec.Containers[0]
+	if publish := strings.Join(publishContainer.Command, " "); !strings.Contains(publish, "--review-threads /workspace/follow-up/review-threads.json") {
+		t.Errorf("expected publish to reply to the review threads, got %s", publish)
+	}
+	if !hasMount(publishContainer, FollowUpDir) {
+		t.Errorf("expected follow-up files mounted in the publish container")
+	}
+
+	c.Spec.Repos = append(c.Spec.Repos, "https://github.com/example/repo2")
+	if _, err := k.RenderChange(c, opts); err == nil {
+		t.Error("expected error for a repository without a pull request to follow up")
+	}
+}
+
+func hasMount(c corev1.Container, mountPath string) bool {
+	for _, m := range c.VolumeMounts {
+		if m.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnvName(c corev1.Container, name string) bool {
+	for _, env := range c.Env {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// createdAtPrefix and expiresAtPrefix annotate the credentials secret
+	// per key, e.g. expires-at.baca.io/GITHUB_TOKEN
+	createdAtPrefix = "created-at.baca.io/"
+	expiresAtPrefix = "expires-at.baca.io/"
+	// expiryWarning is how long before credentials expire apply warns
+	expiryWarning = 7 * 24 * time.Hour
+)
+
+// annotateCredentials records when keys were stored and when they expire, if
+// known. Keys too long for an annotation name aren't recorded.
+func annotateCredentials(secret *corev1.Secret, keys []string, expires map[string]time.Time, now time.Time) {
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	for _, key := range keys {
+		if len(validation.IsQualifiedName(createdAtPrefix+key)) > 0 {
+			continue
+		}
+		secret.Annotations[createdAtPrefix+key] = now.UTC().Format(time.RFC3339)
+		if t, ok := expires[key]; ok && !t.IsZero() {
+			secret.Annotations[expiresAtPrefix+key] = t.UTC().Format(time.RFC3339)
+		} else {
+			delete(secret.Annotations, expiresAtPrefix+key)
+		}
+	}
+}
+
+// CredentialExpiry is when a stored credential expires.
+type CredentialExpiry struct {
+	Key     string
+	Expires time.Time
+}
+
+// expiringCredentials returns the credentials of secret expiring before
+// deadline, the earliest first.
+func expiringCredentials(secret *corev1.Secret, deadline time.Time) []CredentialExpiry {
+	var expiring []CredentialExpiry
+	for name, value := range secret.Annotations {
+		key, ok := strings.CutPrefix(name, expiresAtPrefix)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil || !t.Before(deadline) {
+			continue
+		}
+		expiring = append(expiring, CredentialExpiry{Key: key, Expires: t})
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].Expires.Before(expiring[j].Expires)
+	})
+	return expiring
+}
+
+// warnExpiringCredentials warns about credentials which expired, or expire
+// within expiryWarning. Jobs using them fail in fork setup.
+func (k *KubernetesBackend) warnExpiringCredentials(secret *corev1.Secret) {
+	now := time.Now()
+	for _, c := range expiringCredentials(secret, now.Add(expiryWarning)) {
+		if c.Expires.Before(now) {
+			k.logger.Warn("credential expired, jobs using it fail: rotate it with 'baca setup rotate'", "key", c.Key, "expired", c.Expires)
+			continue
+		}
+		k.logger.Warn("credential expires soon: rotate it with 'baca setup rotate'", "key", c.Key, "expires", c.Expires)
+	}
+}
+
+// Rotate replaces individual credentials in the profile's secret, keeping
+// all others. Keys holding the same value as a rotated key, e.g.
+// COPILOT_TOKEN stored from GITHUB_TOKEN by setup, are rotated along. It
+// returns the rotated keys.
+func (k *KubernetesBackend) Rotate(ctx context.Context, credentials map[string]string, expires map[string]time.Time) ([]string, error) {
+	secret := &corev1.Secret{}
+	if err := k.client.Get(ctx, client.ObjectKey{Name: k.secretName(), Namespace: k.namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("credentials %s not found: run 'baca setup' first", k.secretName())
+		}
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+	if err := k.checkOwner(ctx, secret); err != nil {
+		return nil, err
+	}
+	for _, owner := range secret.OwnerReferences {
+		if owner.Kind == externalSecretGVK.Kind {
+			return nil, fmt.Errorf("credentials %s are synced by the External Secrets Operator, rotate them in the secret store", secret.Name)
+		}
+	}
+
+	rotated := map[string]string{}
+	expires = copyExpiries(expires)
+	for key, value := range credentials {
+		rotated[key] = value
+		old, ok := secret.Data[key]
+		if !ok || len(old) == 0 {
+			continue
+		}
+		for other, otherValue := range secret.Data {
+			if _, given := credentials[other]; !given && string(otherValue) == string(old) {
+				rotated[other] = value
+				if t, ok := expires[key]; ok {
+					expires[other] = t
+				}
+			}
+		}
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	keys := make([]string, 0, len(rotated))
+	for key, value := range rotated {
+		secret.Data[key] = []byte(value)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	annotateCredentials(secret, keys, expires, time.Now())
+
+	if err := k.client.Update(ctx, secret); err != nil {
+		return nil, fmt.Errorf("failed to update credentials: %w", err)
+	}
+	k.logger.Info("credentials rotated", "secret", secret.Name, "keys", keys)
+	return keys, nil
+}
+
+func copyExpiries(expires map[string]time.Time) map[string]time.Time {
+	result := make(map[string]time.Time, len(expires))
+	for key, t := range expires {
+		result[key] = t
+	}
+	return result
+}
@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAnnotateCredentials(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(30 * 24 * time.Hour)
+	secret := &corev1.Secret{}
+	secret.Annotations = map[string]string{expiresAtPrefix + "COPILOT_TOKEN": "2026-01-01T00:00:00Z"}
+
+	long := "GITHUB_TOKEN_" + strings.Repeat("A", 60)
+	annotateCredentials(secret, []string{"GITHUB_TOKEN", "COPILOT_TOKEN", long}, map[string]time.Time{"GITHUB_TOKEN": expires}, now)
+
+	if got := secret.Annotations[createdAtPrefix+"GITHUB_TOKEN"]; got != "2026-10-01T12:00:00Z" {
+		t.Errorf("unexpected created-at %q", got)
+	}
+	if got := secret.Annotations[expiresAtPrefix+"GITHUB_TOKEN"]; got != "2026-10-31T12:00:00Z" {
+		t.Errorf("unexpected expires-at %q", got)
+	}
+	if _, ok := secret.Annotations[expiresAtPrefix+"COPILOT_TOKEN"]; ok {
+		t.Error("expected expiration of the replaced COPILOT_TOKEN to be removed")
+	}
+	if _, ok := secret.Annotations[createdAtPrefix+long]; ok {
+		t.Error("expected key too long for an annotation to be skipped")
+	}
+}
+
+func TestExpiringCredentials(t *testing.T) {
+	secret := &corev1.Secret{}
+	secret.Annotations = map[string]string{
+		expiresAtPrefix + "GITHUB_TOKEN":  "2026-10-20T00:00:00Z",
+		expiresAtPrefix + "COPILOT_TOKEN": "2026-10-10T00:00:00Z",
+		expiresAtPrefix + "GITLAB_TOKEN":  "2027-01-01T00:00:00Z",
+		expiresAtPrefix + "INVALID":       "tomorrow",
+		createdAtPrefix + "GITHUB_TOKEN":  "2026-09-01T00:00:00Z",
+	}
+
+	expiring := expiringCredentials(secret, time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC))
+	if len(expiring) != 2 || expiring[0].Key != "COPILOT_TOKEN" || expiring[1].Key != "GITHUB_TOKEN" {
+		t.Errorf("unexpected expiring credentials %+v", expiring)
+	}
+}
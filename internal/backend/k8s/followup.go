@@ -0,0 +1,109 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kinds of follow-up runs.
+const (
+	// FollowUpCI fixes the failing CI checks of pull requests
+	FollowUpCI = "ci"
+	// FollowUpReview addresses the unresolved review comments of pull
+	// requests
+	FollowUpReview = "review"
+)
+
+// FollowUpDir is where the files of a follow-up are mounted in the agent
+// container. It's outside the repository, so they aren't committed.
+const FollowUpDir = "/workspace/follow-up"
+
+// FollowUp updates an existing pull request instead of opening a new one:
+// the agent runs on its head branch and the changes are pushed there.
+type FollowUp struct {
+	PullRequestURL string
+	// HeadURL is the repository HeadBranch is in, usually the fork
+	HeadURL    string
+	HeadBranch string
+	// HeadSHA is recorded in the run, to tell which commit was followed up
+	HeadSHA string
+	// Prompt replaces the change's prompt
+	Prompt string
+	// Files are mounted in FollowUpDir, e.g. the logs of failing checks.
+	// Credentials in them are masked.
+	Files map[string]string
+	// ReviewThreads is the file in Files with the review threads publish
+	// replies to after pushing, if any
+	ReviewThreads string
+}
+
+// FollowUpOptions turn an apply into a follow-up of a run.
+type FollowUpOptions struct {
+	Kind string
+	// Run is the ID of the run whose pull requests are followed up
+	Run string
+	// PullRequests maps the change's repositories to their follow-up
+	PullRequests map[string]FollowUp
+}
+
+// followUp returns the follow-up of a repository, or nil if opts isn't one.
+func (o *FollowUpOptions) followUp(repoURL string) (*FollowUp, error) {
+	if o == nil {
+		return nil, nil
+	}
+	f, ok := o.PullRequests[repoURL]
+	if !ok {
+		return nil, fmt.Errorf("no pull request to follow up for %s", repoURL)
+	}
+	return &f, nil
+}
+
+// followUpVolume mounts the follow-up files of a job from the ConfigMap
+// created by createFollowUpFiles.
+func followUpVolume(jobName string) (corev1.Volume, corev1.VolumeMount) {
+	volume := corev1.Volume{
+		Name: "follow-up",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: jobName},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: "follow-up", MountPath: FollowUpDir, ReadOnly: true}
+	return volume, mount
+}
+
+// createFollowUpFiles stores the follow-up files of a job in a ConfigMap
+// named like the job. Credentials of the profile and the environment are
+// masked, logs may print them. It returns nil if there are no files.
+func (k *KubernetesBackend) createFollowUpFiles(ctx context.Context, job *batchv1.Job, f *FollowUp, createOpts []client.CreateOption) (*corev1.ConfigMap, error) {
+	if f == nil || len(f.Files) == 0 {
+		return nil, nil
+	}
+	scanner := k.logScanner(ctx)
+	data := map[string]string{}
+	for name, content := range f.Files {
+		data[name] = scanner.Mask(content)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: k.namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "baca",
+				"app.kubernetes.io/component":  "follow-up",
+				"app.kubernetes.io/managed-by": "baca-cli",
+			},
+		},
+		Data: data,
+	}
+	if err := k.client.Create(ctx, cm, createOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create follow-up files for %s: %w", job.Name, err)
+	}
+	return cm, nil
+}
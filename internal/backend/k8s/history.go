@@ -0,0 +1,457 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/change"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// runLabel is set on the jobs of a run and its history ConfigMap
+	runLabel = "baca.io/run"
+	// runConfigMapPrefix and runKey store a run as JSON in a ConfigMap, which
+	// outlives the jobs. changeKey stores the applied change, for
+	// follow-ups.
+	runConfigMapPrefix = "baca-run-"
+	runKey             = "run.json"
+	changeKey          = "change.json"
+
+	// recordedJobTTL keeps the finished jobs of a run until their outcome is
+	// recorded, by the metrics watcher or when the history is read. Jobs
+	// deleted before that have an unknown outcome.
+	recordedJobTTL = 7 * 24 * time.Hour
+)
+
+// Outcomes of a repository in a run, besides the metrics outcomes of
+// finished jobs.
+const (
+	OutcomeRunning = "running"
+	// OutcomeUnknown is for jobs deleted before their outcome was recorded
+	OutcomeUnknown = "unknown"
+)
+
+// Run is the history record of one apply.
+type Run struct {
+	ID          string     `json:"id"`
+	Change      string     `json:"change"`
+	ChangeHash  string     `json:"changeHash"`
+	SubmittedBy string     `json:"submittedBy,omitempty"`
+	Profile     string     `json:"profile,omitempty"`
+	Created     time.Time  `json:"created"`
+	Finished    *time.Time `json:"finished,omitempty"`
+	// Parent is the run whose pull requests a follow-up run updates, of
+	// kind FollowUp
+	Parent   string    `json:"parent,omitempty"`
+	FollowUp string    `json:"followUp,omitempty"`
+	Repos    []RunRepo `json:"repos"`
+}
+
+// RunRepo is the job and outcome of a repository in a run.
+type RunRepo struct {
+	Repo           string `json:"repo"`
+	Job            string `json:"job"`
+	Outcome        string `json:"outcome"`
+	FailureClass   string `json:"failureClass,omitempty"`
+	PullRequestURL string `json:"pullRequestURL,omitempty"`
+	// HeadSHA is the head commit of the pull request a follow-up started from
+	HeadSHA  string     `json:"headSHA,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// HistoryFilter selects runs, empty fields match all.
+type HistoryFilter struct {
+	Change string
+	// Repo matches repository URLs containing it
+	Repo    string
+	Outcome string
+	// Parent selects the follow-ups of a run
+	Parent string
+}
+
+// matches reports whether any repository of the run matches the filter.
+func (f HistoryFilter) matches(run *Run) bool {
+	if f.Change != "" && run.Change != f.Change {
+		return false
+	}
+	if f.Parent != "" && run.Parent != f.Parent {
+		return false
+	}
+	for _, repo := range run.Repos {
+		if (f.Repo == "" || strings.Contains(repo.Repo, f.Repo)) && (f.Outcome == "" || repo.Outcome == f.Outcome) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeHash returns the SHA-256 of the change, to tell apart runs of a
+// change name whose content changed.
+func ChangeHash(c *change.Change) string {
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newRunID returns a run ID from the change name and a random suffix, valid
+// as a label value.
+func (k *KubernetesBackend) newRunID(c *change.Change) string {
+	name := k.sanitizeLabel(c.Metadata.Name)
+	if len(name) > 40 {
+		name = strings.TrimRight(name[:40], "-_")
+	}
+	if name == "" {
+		return generateRandomSuffix()
+	}
+	return name + "-" + generateRandomSuffix()
+}
+
+// recordRun stores the history of a run whose jobs were created, and the
+// change to follow it up with.
+func (k *KubernetesBackend) recordRun(ctx context.Context, c *change.Change, runID string, jobs []*batchv1.Job, followUp *FollowUpOptions) error {
+	run := &Run{
+		ID:          runID,
+		Change:      c.Metadata.Name,
+		ChangeHash:  ChangeHash(c),
+		SubmittedBy: k.identity,
+		Profile:     k.profile,
+		Created:     time.Now().UTC(),
+	}
+	if followUp != nil {
+		run.Parent = followUp.Run
+		run.FollowUp = followUp.Kind
+	}
+	for _, job := range jobs {
+		repo := RunRepo{
+			Repo:    job.Annotations[repoAnnotation],
+			Job:     job.Name,
+			Outcome: OutcomeRunning,
+		}
+		if followUp != nil {
+			f := followUp.PullRequests[repo.Repo]
+			repo.PullRequestURL = f.PullRequestURL
+			repo.HeadSHA = f.HeadSHA
+		}
+		run.Repos = append(run.Repos, repo)
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	changeData, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      runConfigMapPrefix + runID,
+			Namespace: k.namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "baca",
+				"app.kubernetes.io/component":  "run",
+				"app.kubernetes.io/managed-by": "baca-cli",
+				runLabel:                       runID,
+				changeLabel:                    k.sanitizeLabel(c.Metadata.Name),
+			},
+			Annotations: map[string]string{},
+		},
+		Data: map[string]string{runKey: string(data), changeKey: string(changeData)},
+	}
+	if k.profile != "" {
+		cm.Labels[profileLabel] = k.profile
+	}
+	if k.identity != "" {
+		cm.Annotations[submittedByAnnotation] = k.identity
+		cm.Labels[submittedByLabel] = k.sanitizeLabel(k.identity)
+	}
+	if err := k.client.Create(ctx, cm); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	return nil
+}
+
+func decodeRun(cm *corev1.ConfigMap) (*Run, error) {
+	run := &Run{}
+	if err := json.Unmarshal([]byte(cm.Data[runKey]), run); err != nil {
+		return nil, fmt.Errorf("invalid run %s: %w", cm.Name, err)
+	}
+	return run, nil
+}
+
+// updateRun records the outcomes of the run's finished jobs. Jobs which are
+// gone without an outcome, e.g. deleted by their TTL, are unknown. Failing
+// to look up the jobs or to store the outcomes is only logged, the run is
+// returned as far as it is known.
+func (k *KubernetesBackend) updateRun(ctx context.Context, cm *corev1.ConfigMap) (*Run, error) {
+	run, err := decodeRun(cm)
+	if err != nil || run.Finished != nil {
+		return run, err
+	}
+
+	changed := false
+	finished := true
+	for i := range run.Repos {
+		repo := &run.Repos[i]
+		if repo.Outcome != OutcomeRunning {
+			continue
+		}
+		job := &batchv1.Job{}
+		err := k.client.Get(ctx, client.ObjectKey{Name: repo.Job, Namespace: k.namespace}, job)
+		switch {
+		case apierrors.IsNotFound(err):
+			repo.Outcome = OutcomeUnknown
+		case err != nil:
+			k.logger.Warn("failed to get job of run", "run", run.ID, "job", repo.Job, "error", err)
+			finished = false
+			continue
+		default:
+			condition, ok := jobFinished(job)
+			if !ok {
+				finished = false
+				continue
+			}
+			pods := &corev1.PodList{}
+			if err := k.client.List(ctx, pods, client.InNamespace(k.namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+				k.logger.Warn("failed to list pods of run", "run", run.ID, "job", repo.Job, "error", err)
+				finished = false
+				continue
+			}
+			result := jobResult(job, pods.Items)
+			repo.Outcome = result.Outcome
+			repo.FailureClass = result.FailureClass
+			if result.PullRequestURL != "" {
+				repo.PullRequestURL = result.PullRequestURL
+			}
+			repo.Finished = &condition.LastTransitionTime.Time
+		}
+		changed = true
+	}
+	if finished {
+		run.Finished = latestFinish(run)
+		if run.Finished == nil {
+			now := time.Now().UTC()
+			run.Finished = &now
+		}
+	}
+	if !changed && !finished {
+		return run, nil
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	cm.Data[runKey] = string(data)
+	// Readers may not be allowed to update, and the metrics watcher may
+	// have recorded the same outcomes concurrently
+	if err := k.client.Update(ctx, cm); err != nil {
+		k.logger.Warn("failed to record job outcomes", "run", run.ID, "error", err)
+	}
+	return run, nil
+}
+
+// latestFinish returns when the last job of the run finished, if known.
+func latestFinish(run *Run) *time.Time {
+	var latest *time.Time
+	for _, repo := range run.Repos {
+		if repo.Finished != nil && (latest == nil || repo.Finished.After(*latest)) {
+			latest = repo.Finished
+		}
+	}
+	return latest
+}
+
+// History returns the runs matching filter, the newest first. Outcomes of
+// finished jobs are recorded on the way. Runs submitted by other users are
+// skipped.
+func (k *KubernetesBackend) History(ctx context.Context, filter HistoryFilter) ([]*Run, error) {
+	selector := client.MatchingLabels{
+		"app.kubernetes.io/name":      "baca",
+		"app.kubernetes.io/component": "run",
+	}
+	if filter.Change != "" {
+		selector[changeLabel] = k.sanitizeLabel(filter.Change)
+	}
+	list := &corev1.ConfigMapList{}
+	if err := k.client.List(ctx, list, client.InNamespace(k.namespace), selector); err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	var runs []*Run
+	for i := range list.Items {
+		cm := &list.Items[i]
+		if k.checkOwner(ctx, cm) != nil {
+			continue
+		}
+		run, err := k.updateRun(ctx, cm)
+		if err != nil {
+			k.logger.Warn("skipping run", "configmap", cm.Name, "error", err)
+			continue
+		}
+		if filter.matches(run) {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Created.After(runs[j].Created)
+	})
+	return runs, nil
+}
+
+// GetRun returns a run by ID, with the outcomes of finished jobs recorded.
+// Runs submitted by other users are refused.
+func (k *KubernetesBackend) GetRun(ctx context.Context, runID string) (*Run, error) {
+	cm, err := k.getRunConfigMap(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	return k.updateRun(ctx, cm)
+}
+
+// PruneHistory deletes the finished runs beyond the newest keep runs or
+// created before, zero values don't limit. Follow-up runs are deleted along
+// with the run they follow up. Runs submitted by other users are kept. It
+// returns the IDs of the deleted runs, with dryRun of the runs to delete.
+func (k *KubernetesBackend) PruneHistory(ctx context.Context, keep int, before time.Time, dryRun bool) ([]string, error) {
+	runs, err := k.History(ctx, HistoryFilter{})
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, run := range pruneRuns(runs, keep, before) {
+		if !dryRun {
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: runConfigMapPrefix + run.ID, Namespace: k.namespace}}
+			if err := k.client.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+				return pruned, fmt.Errorf("failed to delete run %s: %w", run.ID, err)
+			}
+		}
+		pruned = append(pruned, run.ID)
+	}
+	return pruned, nil
+}
+
+// pruneRuns selects the finished runs to prune from runs, the newest first.
+// Follow-ups of a listed run go with it, regardless of their own age.
+func pruneRuns(runs []*Run, keep int, before time.Time) []*Run {
+	ids := map[string]bool{}
+	for _, run := range runs {
+		ids[run.ID] = true
+	}
+	// Runs whose follow-ups are still running are kept, too
+	running := map[string]bool{}
+	for _, run := range runs {
+		if run.Finished == nil {
+			running[run.ID] = true
+			running[run.Parent] = true
+		}
+	}
+
+	prune := map[string]bool{}
+	n := 0
+	for _, run := range runs {
+		if run.Parent != "" && ids[run.Parent] {
+			continue
+		}
+		n++
+		if running[run.ID] {
+			continue
+		}
+		if (keep > 0 && n > keep) || (!before.IsZero() && run.Created.Before(before)) {
+			prune[run.ID] = true
+		}
+	}
+
+	var pruned []*Run
+	for _, run := range runs {
+		if prune[run.ID] || (prune[run.Parent] && ids[run.Parent]) {
+			pruned = append(pruned, run)
+		}
+	}
+	return pruned
+}
+
+// GetRunChange returns the change a run applied. Runs recorded before the
+// change was stored return an error.
+func (k *KubernetesBackend) GetRunChange(ctx context.Context, runID string) (*change.Change, error) {
+	cm, err := k.getRunConfigMap(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[changeKey]
+	if !ok {
+		return nil, fmt.Errorf("run %s has no change recorded", runID)
+	}
+	c := &change.Change{}
+	if err := json.Unmarshal([]byte(data), c); err != nil {
+		return nil, fmt.Errorf("invalid change of run %s: %w", runID, err)
+	}
+	return c, nil
+}
+
+func (k *KubernetesBackend) getRunConfigMap(ctx context.Context, runID string) (*corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{}
+	if err := k.client.Get(ctx, client.ObjectKey{Name: runConfigMapPrefix + runID, Namespace: k.namespace}, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("run %s not found", runID)
+		}
+		return nil, fmt.Errorf("failed to get run: %w", err)
+	}
+	if err := k.checkOwner(ctx, cm); err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+// recordJobResult records the outcome of a finished job in its run, e.g.
+// when the metrics watcher sees it finish.
+func (k *KubernetesBackend) recordJobResult(ctx context.Context, job *batchv1.Job) {
+	if runID := job.Labels[runLabel]; runID != "" {
+		k.refreshRun(ctx, runID)
+	}
+}
+
+// refreshRun records the outcomes of the run's finished jobs, failures are
+// only logged.
+func (k *KubernetesBackend) refreshRun(ctx context.Context, runID string) {
+	cm := &corev1.ConfigMap{}
+	if err := k.client.Get(ctx, client.ObjectKey{Name: runConfigMapPrefix + runID, Namespace: k.namespace}, cm); err != nil {
+		k.logger.Warn("failed to get run", "run", runID, "error", err)
+		return
+	}
+	if _, err := k.updateRun(ctx, cm); err != nil {
+		k.logger.Warn("failed to read run", "run", runID, "error", err)
+	}
+}
+
+// saveRun records the run of the created jobs, failures are only logged.
+func (k *KubernetesBackend) saveRun(ctx context.Context, c *change.Change, runID string, jobs []*batchv1.Job, followUp *FollowUpOptions) {
+	if runID == "" || len(jobs) == 0 {
+		return
+	}
+	if err := k.recordRun(ctx, c, runID, jobs, followUp); err != nil {
+		k.logger.Warn("run history not recorded", "run", runID, "error", err)
+		return
+	}
+	k.logger.Info("run recorded, see 'baca history show "+runID+"'", "run", runID)
+}
+
+// Summary counts the outcomes of the run's repositories.
+func (r *Run) Summary() map[string]int {
+	summary := map[string]int{}
+	for _, repo := range r.Repos {
+		summary[repo.Outcome]++
+	}
+	return summary
+}
@@ -0,0 +1,161 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestHistoryFilter(t *testing.T) {
+	run := &Run{
+		Change: "bump-go",
+		Parent: "bump-go-20260101-120000-abcd",
+		Repos: []RunRepo{
+			{Repo: "https://github.com/manno/fleet", Outcome: metrics.OutcomePullRequest},
+			{Repo: "https://github.com/manno/baca", Outcome: metrics.OutcomeFailed},
+		},
+	}
+	tests := []struct {
+		filter HistoryFilter
+		want   bool
+	}{
+		{HistoryFilter{}, true},
+		{HistoryFilter{Change: "bump-go"}, true},
+		{HistoryFilter{Change: "other"}, false},
+		{HistoryFilter{Repo: "manno/fleet"}, true},
+		{HistoryFilter{Outcome: metrics.OutcomeFailed}, true},
+		{HistoryFilter{Outcome: OutcomeRunning}, false},
+		{HistoryFilter{Parent: "bump-go-20260101-120000-abcd"}, true},
+		{HistoryFilter{Parent: "other"}, false},
+		// Repo and outcome have to match the same repository
+		{HistoryFilter{Repo: "manno/fleet", Outcome: metrics.OutcomeFailed}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.matches(run); got != tt.want {
+			t.Errorf("%+v matches = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	k := &KubernetesBackend{}
+	id := k.newRunID(&change.Change{Metadata: change.Metadata{Name: "Bump Go in all the repositories of the platform team"}})
+	if !strings.HasPrefix(id, "bump-go-in-all-the-repositories-of-the-p-") || len(id) > 63 {
+		t.Errorf("unexpected run ID %s", id)
+	}
+	if id := k.newRunID(&change.Change{}); len(id) != 8 {
+		t.Errorf("expected a random run ID without change name, got %s", id)
+	}
+}
+
+func TestChangeHash(t *testing.T) {
+	c := &change.Change{Kind: "Change", Spec: change.ChangeSpec{Prompt: "Add tests"}}
+	hash := ChangeHash(c)
+	if len(hash) != 64 || hash != ChangeHash(c) {
+		t.Errorf("unexpected hash %s", hash)
+	}
+	c.Spec.Prompt = "Add more tests"
+	if ChangeHash(c) == hash {
+		t.Error("expected the hash to change with the prompt")
+	}
+}
+
+func TestLatestFinish(t *testing.T) {
+	first := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	run := &Run{Repos: []RunRepo{{Finished: &last}, {}, {Finished: &first}}}
+	if got := latestFinish(run); got == nil || !got.Equal(last) {
+		t.Errorf("expected %s, got %v", last, got)
+	}
+	if got := latestFinish(&Run{Repos: []RunRepo{{Outcome: OutcomeUnknown}}}); got != nil {
+		t.Errorf("expected no finish time, got %s", got)
+	}
+}
+
+func TestUpdateRunFailedUpdate(t *testing.T) {
+	finished := metav1.NewTime(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	run := &Run{ID: "bump-go-abcd", Repos: []RunRepo{
+		{Repo: "https://github.com/org/done", Job: "done", Outcome: OutcomeRunning},
+		{Repo: "https://github.com/org/gone", Job: "gone", Outcome: OutcomeRunning},
+	}}
+	data, err := json.Marshal(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: runConfigMapPrefix + run.ID, Namespace: "baca-jobs"},
+		Data:       map[string]string{runKey: string(data)},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "baca-jobs"},
+		Status:     batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: finished}}},
+	}
+
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	k.client = fake.NewClientBuilder().WithObjects(cm, job).WithInterceptorFuncs(interceptor.Funcs{
+		Update: func(context.Context, client.WithWatch, client.Object, ...client.UpdateOption) error {
+			return apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, cm.Name, errors.New("read only"))
+		},
+	}).Build()
+
+	got, err := k.updateRun(t.Context(), cm)
+	if err != nil {
+		t.Fatalf("expected the run despite the failed update, got %v", err)
+	}
+	if got.Repos[0].Outcome != metrics.OutcomeNoChanges || got.Repos[1].Outcome != OutcomeUnknown || got.Finished == nil || !got.Finished.Equal(finished.Time) {
+		t.Errorf("unexpected run %+v", got)
+	}
+}
+
+func TestPruneRuns(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	done := &now
+	run := func(id, parent string, age time.Duration, finished *time.Time) *Run {
+		return &Run{ID: id, Parent: parent, Created: now.Add(-age), Finished: finished}
+	}
+	// Newest first, like History
+	runs := []*Run{
+		run("c-fix", "b", time.Hour, done),
+		run("c", "", 2*time.Hour, done),
+		run("b-fix", "b", 3*time.Hour, done),
+		run("b", "", 4*time.Hour, done),
+		run("a-fix", "a", 5*time.Hour, nil),
+		run("a", "", 6*time.Hour, done),
+		run("orphan-fix", "gone", 7*time.Hour, done),
+		run("z", "", 48*time.Hour, done),
+	}
+	ids := func(runs []*Run) []string {
+		var ids []string
+		for _, run := range runs {
+			ids = append(ids, run.ID)
+		}
+		return ids
+	}
+
+	// a has a running follow-up, b's follow-ups go with it
+	if got := ids(pruneRuns(runs, 1, time.Time{})); strings.Join(got, ",") != "c-fix,b-fix,b,orphan-fix,z" {
+		t.Errorf("pruneRuns keep 1 = %v", got)
+	}
+	if got := ids(pruneRuns(runs, 0, now.Add(-24*time.Hour))); strings.Join(got, ",") != "z" {
+		t.Errorf("pruneRuns older than a day = %v", got)
+	}
+	if got := pruneRuns(runs, 0, time.Time{}); len(got) != 0 {
+		t.Errorf("expected nothing to prune without limits, got %v", ids(got))
+	}
+}
@@ -2,8 +2,10 @@
 package k8s
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"net"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -15,6 +17,15 @@ type KubernetesBackend struct {
 	logger    *slog.Logger
 	client    client.Client
 	clientset *kubernetes.Clientset
+	// lookupIP resolves allowed hosts for network policies, the default
+	// resolver is used if nil
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+	// profile selects the credentials secret, empty for baca-credentials
+	profile string
+	// identity is the Kubernetes user submitting jobs, looked up on demand
+	identity string
+	// refs are the profile's credential references, loaded by apply
+	refs *CredentialRefs
 }
 
 const DefaultImage = "ghcr.io/manno/baca-runner:latest"
@@ -0,0 +1,206 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/manno/baca/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setTerminationLog tells the baca commands in all containers where to write
+// their termination message, which WatchMetrics reads the outcome from.
+func setTerminationLog(spec *corev1.PodSpec) {
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			containers[i].TerminationMessagePath = corev1.TerminationMessagePathDefault
+		}
+	}
+	addEnv(spec, map[string]string{metrics.TerminationLogEnv: corev1.TerminationMessagePathDefault})
+}
+
+// metricsJob returns the change, repository and agent of a job from its
+// labels and annotations.
+func metricsJob(job *batchv1.Job) metrics.Job {
+	return metrics.Job{
+		Change: job.Annotations[changeAnnotation],
+		Repo:   job.Annotations[repoAnnotation],
+		Agent:  job.Labels[agentLabel],
+	}
+}
+
+// jobFinished reports whether the job completed or failed.
+func jobFinished(job *batchv1.Job) (*batchv1.JobCondition, bool) {
+	for i, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i], true
+		}
+	}
+	return nil, false
+}
+
+// jobResult returns the outcome of a finished job from the containers of its
+// latest pod.
+func jobResult(job *batchv1.Job, pods []corev1.Pod) metrics.Result {
+	result := metrics.Result{Job: metricsJob(job)}
+	condition, _ := jobFinished(job)
+
+	var pod *corev1.Pod
+	for i := range pods {
+		if pod == nil || pod.CreationTimestamp.Before(&pods[i].CreationTimestamp) {
+			pod = &pods[i]
+		}
+	}
+	var statuses []corev1.ContainerStatus
+	if pod != nil {
+		statuses = append(append(statuses, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	}
+	for _, status := range statuses {
+		if status.Name == "agent" && status.State.Terminated != nil {
+			t := status.State.Terminated
+			result.AgentDuration = t.FinishedAt.Sub(t.StartedAt.Time)
+		}
+	}
+
+	if condition != nil && condition.Type == batchv1.JobComplete {
+		result.Outcome = metrics.OutcomeNoChanges
+		for _, status := range statuses {
+			if status.Name != "publish" || status.State.Terminated == nil {
+				continue
+			}
+			t, ok := metrics.ParseTermination(status.State.Terminated.Message)
+			if ok && t.PullRequestURL != "" {
+				result.Outcome = metrics.OutcomePullRequest
+				if t.PullRequestUpdated {
+					result.Outcome = metrics.OutcomePullRequestUpdated
+				}
+				result.PullRequestURL = t.PullRequestURL
+				if t.PullRequestCreatedAt != nil {
+					result.PullRequestLatency = t.PullRequestCreatedAt.Sub(job.CreationTimestamp.Time)
+				}
+			}
+		}
+		return result
+	}
+
+	result.Outcome = metrics.OutcomeFailed
+	result.FailureClass = metrics.FailureUnknown
+	if condition != nil && condition.Reason == batchv1.JobReasonDeadlineExceeded {
+		result.FailureClass = metrics.FailureTimeout
+		return result
+	}
+	for _, status := range statuses {
+		t := status.State.Terminated
+		if t == nil || t.ExitCode == 0 {
+			continue
+		}
+		result.FailureClass = status.Name
+		if t.Reason == "OOMKilled" {
+			result.FailureClass = metrics.FailureOOM
+		} else if termination, ok := metrics.ParseTermination(t.Message); ok && termination.FailureClass != "" {
+			result.FailureClass = termination.FailureClass
+		}
+		break
+	}
+	return result
+}
+
+// WatchMetrics polls the jobs in the namespace every interval and records
+// them until ctx is done. Finished jobs are deleted after recordedJobTTL,
+// the interval has to be shorter to see all outcomes. Only jobs created and
+// finished after the start are counted, a restarted watcher would count the
+// others again.
+func (k *KubernetesBackend) WatchMetrics(ctx context.Context, rec *metrics.Recorder, interval time.Duration) error {
+	created := map[types.UID]bool{}
+	finished := map[types.UID]bool{}
+	started := time.Now()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := k.collectMetrics(ctx, rec, started, created, finished); err != nil {
+			k.logger.Warn("failed to collect metrics", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *KubernetesBackend) collectMetrics(ctx context.Context, rec *metrics.Recorder, started time.Time, created, finished map[types.UID]bool) error {
+	jobs := &batchv1.JobList{}
+	if err := k.client.List(ctx, jobs, client.InNamespace(k.namespace), client.MatchingLabels{
+		"app.kubernetes.io/name":      "baca",
+		"app.kubernetes.io/component": "job",
+	}); err != nil {
+		return fmt.Errorf("failed to list jobs: %w", err)
+	}
+	pods := &corev1.PodList{}
+	if err := k.client.List(ctx, pods, client.InNamespace(k.namespace), client.MatchingLabels{
+		"app.kubernetes.io/name": "baca",
+	}); err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+	podsByJob := map[string][]corev1.Pod{}
+	for _, pod := range pods.Items {
+		name := pod.Labels["job-name"]
+		podsByJob[name] = append(podsByJob[name], pod)
+	}
+
+	queued := map[string]int{}
+	running := map[string]int{}
+	seen := map[types.UID]bool{}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		seen[job.UID] = true
+		if !created[job.UID] {
+			created[job.UID] = true
+			if !job.CreationTimestamp.Time.Before(started) {
+				rec.JobCreated(metricsJob(job))
+			}
+		}
+
+		if condition, ok := jobFinished(job); ok {
+			if !finished[job.UID] {
+				finished[job.UID] = true
+				if !condition.LastTransitionTime.Time.Before(started) {
+					rec.JobFinished(jobResult(job, podsByJob[job.Name]))
+				}
+				k.recordJobResult(ctx, job)
+			}
+			continue
+		}
+		change := job.Annotations[changeAnnotation]
+		if scheduled(podsByJob[job.Name]) {
+			running[change]++
+		} else {
+			queued[change]++
+		}
+	}
+	rec.SetQueue(queued, running)
+
+	// Forget deleted jobs
+	for uid := range created {
+		if !seen[uid] {
+			delete(created, uid)
+			delete(finished, uid)
+		}
+	}
+	return nil
+}
+
+// scheduled reports whether any of the pods is assigned to a node.
+func scheduled(pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			return true
+		}
+	}
+	return false
+}
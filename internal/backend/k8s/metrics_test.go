@@ -0,0 +1,193 @@
+package k8s
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRenderChangeMetricsLabels(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind:     "Change",
+		Metadata: change.Metadata{Name: "Bump Go"},
+		Spec: change.ChangeSpec{
+			Prompt: "Bump Go to 1.25",
+			Repos:  []string{"https://github.com/example/repo1"},
+			Agent:  "gemini-cli",
+		},
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := jobs[0]
+	if job.Labels[changeLabel] != "bump-go" || job.Annotations[changeAnnotation] != "Bump Go" || job.Labels[agentLabel] != "gemini-cli" {
+		t.Errorf("unexpected labels %v and annotations %v", job.Labels, job.Annotations)
+	}
+	if got := metricsJob(job); got.Change != "Bump Go" || got.Repo != c.Spec.Repos[0] || got.Agent != "gemini-cli" {
+		t.Errorf("unexpected metrics job %+v", got)
+	}
+
+	spec := job.Spec.Template.Spec
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		if !hasEnv(container, metrics.TerminationLogEnv, corev1.TerminationMessagePathDefault) || container.TerminationMessagePath != corev1.TerminationMessagePathDefault {
+			t.Errorf("expected container %s to write its termination message", container.Name)
+		}
+	}
+}
+
+func TestJobResult(t *testing.T) {
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	prCreated := created.Add(10 * time.Minute)
+	job := func(condition batchv1.JobConditionType, reason string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				CreationTimestamp: metav1.NewTime(created),
+				Annotations:       map[string]string{changeAnnotation: "bump-go", repoAnnotation: "https://github.com/manno/fleet"},
+				Labels:            map[string]string{agentLabel: "copilot-cli"},
+			},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Reason: reason}}},
+		}
+	}
+	terminated := func(name string, exitCode int32, reason, message string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{Name: name, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			ExitCode:   exitCode,
+			Reason:     reason,
+			Message:    message,
+			StartedAt:  metav1.NewTime(created.Add(time.Minute)),
+			FinishedAt: metav1.NewTime(created.Add(6 * time.Minute)),
+		}}}
+	}
+	pod := func(statuses ...corev1.ContainerStatus) corev1.Pod {
+		p := corev1.Pod{}
+		for _, status := range statuses {
+			if status.Name == "publish" {
+				p.Status.ContainerStatuses = append(p.Status.ContainerStatuses, status)
+			} else {
+				p.Status.InitContainerStatuses = append(p.Status.InitContainerStatuses, status)
+			}
+		}
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		job     *batchv1.Job
+		pods    []corev1.Pod
+		outcome string
+		class   string
+		latency time.Duration
+	}{
+		{
+			name:    "pull request",
+			job:     job(batchv1.JobComplete, ""),
+			pods:    []corev1.Pod{pod(terminated("agent", 0, "", ""), terminated("publish", 0, "", `{"pullRequestURL":"https://github.com/manno/fleet/pull/1","pullRequestCreatedAt":"`+prCreated.Format(time.RFC3339)+`"}`))},
+			outcome: metrics.OutcomePullRequest,
+			latency: 10 * time.Minute,
+		},
+		{
+			name:    "no changes",
+			job:     job(batchv1.JobComplete, ""),
+			pods:    []corev1.Pod{pod(terminated("agent", 0, "", ""), terminated("publish", 0, "", ""))},
+			outcome: metrics.OutcomeNoChanges,
+		},
+		{
+			name:    "guardrails",
+			job:     job(batchv1.JobFailed, batchv1.JobReasonBackoffLimitExceeded),
+			pods:    []corev1.Pod{pod(terminated("agent", 1, "Error", `{"failureClass":"guardrails"}`))},
+			outcome: metrics.OutcomeFailed,
+			class:   metrics.FailureGuardrails,
+		},
+		{
+			name:    "out of memory",
+			job:     job(batchv1.JobFailed, batchv1.JobReasonBackoffLimitExceeded),
+			pods:    []corev1.Pod{pod(terminated("agent", 137, "OOMKilled", ""))},
+			outcome: metrics.OutcomeFailed,
+			class:   metrics.FailureOOM,
+		},
+		{
+			name:    "failed container",
+			job:     job(batchv1.JobFailed, batchv1.JobReasonBackoffLimitExceeded),
+			pods:    []corev1.Pod{pod(terminated("fork-setup", 1, "Error", "panic: runtime error"))},
+			outcome: metrics.OutcomeFailed,
+			class:   "fork-setup",
+		},
+		{
+			name:    "deadline",
+			job:     job(batchv1.JobFailed, batchv1.JobReasonDeadlineExceeded),
+			outcome: metrics.OutcomeFailed,
+			class:   metrics.FailureTimeout,
+		},
+		{
+			name:    "without pod",
+			job:     job(batchv1.JobFailed, batchv1.JobReasonBackoffLimitExceeded),
+			outcome: metrics.OutcomeFailed,
+			class:   metrics.FailureUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := jobResult(tt.job, tt.pods)
+			if got.Outcome != tt.outcome || got.FailureClass != tt.class || got.PullRequestLatency != tt.latency {
+				t.Errorf("unexpected result %+v", got)
+			}
+			if got.Change != "bump-go" || got.Agent != "copilot-cli" {
+				t.Errorf("unexpected job %+v", got.Job)
+			}
+			if len(tt.pods) > 0 && len(tt.pods[0].Status.InitContainerStatuses) > 0 && tt.pods[0].Status.InitContainerStatuses[0].Name == "agent" && got.AgentDuration != 5*time.Minute {
+				t.Errorf("expected agent duration 5m, got %s", got.AgentDuration)
+			}
+		})
+	}
+}
+
+func TestCollectMetricsAfterRestart(t *testing.T) {
+	started := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	job := func(name string, created, finished time.Time) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "baca-jobs",
+				UID:               types.UID(name),
+				CreationTimestamp: metav1.NewTime(created),
+				Labels:            map[string]string{"app.kubernetes.io/name": "baca", "app.kubernetes.io/component": "job", agentLabel: "gemini-cli"},
+				Annotations:       map[string]string{changeAnnotation: "bump-go", repoAnnotation: "https://github.com/org/" + name},
+			},
+		}
+		if !finished.IsZero() {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(finished)}}
+		}
+		return job
+	}
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	k.client = fake.NewClientBuilder().WithObjects(
+		// counted by the watcher before the restart
+		job("old", started.Add(-time.Hour), started.Add(-time.Minute)),
+		// created before, finished after the restart
+		job("running", started.Add(-time.Hour), started.Add(time.Minute)),
+		job("new", started.Add(time.Second), started.Add(time.Minute)),
+	).Build()
+
+	rec := metrics.NewRecorder()
+	if err := k.collectMetrics(t.Context(), rec, started, map[types.UID]bool{}, map[types.UID]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(rec.Registry, "baca_jobs_created_total"); n != 1 {
+		t.Errorf("expected only the new job to be counted as created, got %d series", n)
+	}
+	if n := testutil.CollectAndCount(rec.Registry, "baca_jobs_finished_total"); n != 2 {
+		t.Errorf("expected the jobs finished after the start to be counted, got %d series", n)
+	}
+}
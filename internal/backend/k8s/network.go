@@ -0,0 +1,273 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/manno/baca/internal/agent"
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/forge"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// runIDLabel selects the pods of one job, for its network policy
+	runIDLabel = "baca.io/run-id"
+	// defaultDenyPolicy is installed by setup, it blocks egress of all BACA
+	// pods except DNS, the per-job policies add the allowlist.
+	defaultDenyPolicy = "baca-default-deny-egress"
+)
+
+// NetworkOptions restrict the egress of job pods. It is read from the
+// `network` key of the config file.
+type NetworkOptions struct {
+	// Enabled creates a network policy per job. It is also enabled if setup
+	// installed the default deny policy in the namespace.
+	Enabled bool
+	// Allowlist for all changes, in addition to the change's spec.network
+	change.NetworkSpec `mapstructure:",squash"`
+	// DNS selects the cluster DNS pods, the only DNS servers jobs may query
+	DNS DNSOptions
+}
+
+// DNSOptions select the pods of the cluster DNS service. The defaults match
+// CoreDNS and kube-dns as deployed by most distributions.
+type DNSOptions struct {
+	// Namespace of the DNS pods, default kube-system
+	Namespace string
+	// PodLabels of the DNS pods, default k8s-app=kube-dns
+	PodLabels map[string]string
+}
+
+// peer returns the network policy peer selecting the DNS pods.
+func (o DNSOptions) peer() networkingv1.NetworkPolicyPeer {
+	namespace, labels := o.Namespace, o.PodLabels
+	if namespace == "" {
+		namespace = "kube-system"
+	}
+	if len(labels) == 0 {
+		labels = map[string]string{"k8s-app": "kube-dns"}
+	}
+	return networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: namespace}},
+		PodSelector:       &metav1.LabelSelector{MatchLabels: labels},
+	}
+}
+
+// forgeHosts lists hosts a forge serves its API from, besides its own host.
+var forgeHosts = map[string][]string{
+	"github.com": {"api.github.com", "codeload.github.com", "objects.githubusercontent.com"},
+}
+
+// egressHosts returns the hosts a job for upstream needs: the forge, the
+// agent's API, the change's resources and the configured allowlists.
+func egressHosts(c *change.Change, upstream forge.Repo, opts NetworkOptions) []string {
+	hosts := map[string]bool{upstream.Host: true}
+	for _, host := range forgeHosts[upstream.Host] {
+		hosts[host] = true
+	}
+	if config, ok := agent.GetConfig(c.Spec.Agent); ok {
+		for _, host := range config.Hosts {
+			hosts[host] = true
+		}
+	}
+	for _, resource := range append([]string{c.Spec.AgentsMD}, c.Spec.Resources...) {
+		if u, err := url.Parse(resource); err == nil && u.Hostname() != "" {
+			hosts[u.Hostname()] = true
+		}
+	}
+	for _, host := range append(opts.AllowedHosts, c.Spec.Network.AllowedHosts...) {
+		hosts[host] = true
+	}
+
+	result := make([]string, 0, len(hosts))
+	for host := range hosts {
+		result = append(result, host)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// collectorAddress returns the host and port of the OTLP endpoint the jobs
+// export their spans to, ok is false without tracing.
+func collectorAddress(endpoint string) (host string, port int32, ok bool) {
+	u, err := url.Parse(endpoint)
+	if endpoint == "" || err != nil || u.Hostname() == "" {
+		return "", 0, false
+	}
+	port = 443
+	if u.Scheme == "http" {
+		port = 80
+	}
+	if p, err := strconv.ParseInt(u.Port(), 10, 32); err == nil {
+		port = int32(p)
+	}
+	return u.Hostname(), port, true
+}
+
+// renderNetworkPolicy allows the pods of job to reach the cluster DNS, the
+// hosts on port 443, the tracing collector on its port and the CIDRs on all
+// ports. NetworkPolicies can't match host names, so the hosts are resolved
+// now: hosts whose addresses change, e.g. behind a CDN, may need their
+// ranges in the CIDR allowlist. So does a collector whose name only
+// resolves in the cluster.
+func (k *KubernetesBackend) renderNetworkPolicy(ctx context.Context, job *batchv1.Job, hosts, cidrs []string, dns DNSOptions, tracingEndpoint string) (*networkingv1.NetworkPolicy, error) {
+	lookup := k.lookupIP
+	if lookup == nil {
+		lookup = func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		}
+	}
+
+	hostPeers, err := resolvePeers(ctx, lookup, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	https := intstr.FromInt32(443)
+	tcp := corev1.ProtocolTCP
+	egress := []networkingv1.NetworkPolicyEgressRule{dnsEgressRule(dns)}
+	if len(hostPeers) > 0 {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+			To:    hostPeers,
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &https}},
+		})
+	}
+	if host, port, ok := collectorAddress(tracingEndpoint); ok {
+		peers, err := resolvePeers(ctx, lookup, []string{host})
+		if err != nil {
+			// Tracing is optional, the job runs without its spans
+			k.logger.Warn("tracing collector not allowed by the network policy, add its pods' CIDR to network.allowedCIDRs", "error", err)
+		} else {
+			collector := intstr.FromInt32(port)
+			egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+				To:    peers,
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &collector}},
+			})
+		}
+	}
+	if len(cidrs) > 0 {
+		rule := networkingv1.NetworkPolicyEgressRule{}
+		for _, cidr := range cidrs {
+			rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		egress = append(egress, rule)
+	}
+
+	return &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: job.Namespace,
+			Labels:    job.Labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{runIDLabel: job.Name}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}, nil
+}
+
+// resolvePeers returns a peer for each address of the hosts.
+func resolvePeers(ctx context.Context, lookup func(context.Context, string) ([]net.IP, error), hosts []string) ([]networkingv1.NetworkPolicyPeer, error) {
+	var peers []networkingv1.NetworkPolicyPeer
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		ips, err := lookup(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve allowed host %s: %w", host, err)
+		}
+		for _, ip := range ips {
+			cidr := ip.String() + "/32"
+			if ip.To4() == nil {
+				cidr = ip.String() + "/128"
+			}
+			if !seen[cidr] {
+				seen[cidr] = true
+				peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+			}
+		}
+	}
+	return peers, nil
+}
+
+// dnsEgressRule allows name resolution by the cluster DNS pods. Other DNS
+// servers could be used to tunnel data out.
+func dnsEgressRule(opts DNSOptions) networkingv1.NetworkPolicyEgressRule {
+	dns := intstr.FromInt32(53)
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{opts.peer()},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &dns},
+			{Protocol: &tcp, Port: &dns},
+		},
+	}
+}
+
+// networkPoliciesEnabled reports whether setup installed the default deny
+// policy, jobs then need their own policy to reach anything.
+func (k *KubernetesBackend) networkPoliciesEnabled(ctx context.Context) (bool, error) {
+	policy := &networkingv1.NetworkPolicy{}
+	err := k.client.Get(ctx, client.ObjectKey{Name: defaultDenyPolicy, Namespace: k.namespace}, policy)
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to look up network policy %s: %w", defaultDenyPolicy, err)
+	}
+	return true, nil
+}
+
+// SetupNetworkPolicy installs a policy denying all egress of BACA pods in
+// the namespace, except to the cluster DNS. Jobs get a policy with their
+// allowlist.
+func (k *KubernetesBackend) SetupNetworkPolicy(ctx context.Context, dns DNSOptions) error {
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaultDenyPolicy,
+			Namespace: k.namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "baca",
+				"app.kubernetes.io/managed-by": "baca-cli",
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "baca"}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      []networkingv1.NetworkPolicyEgressRule{dnsEgressRule(dns)},
+		},
+	}
+
+	if err := k.client.Create(ctx, policy); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create network policy: %w", err)
+		}
+		existing := &networkingv1.NetworkPolicy{}
+		if err := k.client.Get(ctx, client.ObjectKeyFromObject(policy), existing); err != nil {
+			return fmt.Errorf("failed to get network policy: %w", err)
+		}
+		existing.Spec = policy.Spec
+		if err := k.client.Update(ctx, existing); err != nil {
+			return fmt.Errorf("failed to update network policy: %w", err)
+		}
+		k.logger.Info("network policy updated", "name", policy.Name)
+		return nil
+	}
+	k.logger.Info("network policy created", "name", policy.Name)
+	return nil
+}
@@ -0,0 +1,135 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/forge"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEgressHosts(t *testing.T) {
+	c := &change.Change{Spec: change.ChangeSpec{
+		Agent:     "gemini-cli",
+		AgentsMD:  "https://raw.githubusercontent.com/org/docs/main/AGENTS.md",
+		Resources: []string{"https://docs.example.com/guide.md", "not a url"},
+		Network:   change.NetworkSpec{AllowedHosts: []string{"proxy.golang.org"}},
+	}}
+	opts := NetworkOptions{NetworkSpec: change.NetworkSpec{AllowedHosts: []string{"registry.npmjs.org"}}}
+
+	hosts := egressHosts(c, forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}, opts)
+	for _, want := range []string{
+		"github.com", "api.github.com",
+		"generativelanguage.googleapis.com",
+		"raw.githubusercontent.com", "docs.example.com",
+		"proxy.golang.org", "registry.npmjs.org",
+	} {
+		if !slices.Contains(hosts, want) {
+			t.Errorf("expected %s in %v", want, hosts)
+		}
+	}
+	if slices.Contains(hosts, "api.githubcopilot.com") {
+		t.Errorf("expected no copilot hosts for gemini-cli, got %v", hosts)
+	}
+}
+
+func TestRenderNetworkPolicy(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	k.lookupIP = func(_ context.Context, host string) ([]net.IP, error) {
+		return map[string][]net.IP{
+			"github.com":     {net.ParseIP("140.82.121.4")},
+			"api.github.com": {net.ParseIP("140.82.121.4"), net.ParseIP("2606:50c0::1")},
+		}[host], nil
+	}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "baca-org-repo-1234", Namespace: "baca-jobs"}}
+
+	policy, err := k.renderNetworkPolicy(t.Context(), job, []string{"github.com", "api.github.com"}, []string{"10.0.0.0/8"}, DNSOptions{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if policy.Spec.PodSelector.MatchLabels[runIDLabel] != job.Name {
+		t.Errorf("expected policy to select the job's pods, got %v", policy.Spec.PodSelector)
+	}
+
+	egress := policy.Spec.Egress
+	if len(egress) != 3 {
+		t.Fatalf("expected DNS, hosts and CIDR rules, got %+v", egress)
+	}
+	dns := egress[0].To
+	if len(dns) != 1 || dns[0].NamespaceSelector.MatchLabels[corev1.LabelMetadataName] != "kube-system" || dns[0].PodSelector.MatchLabels["k8s-app"] != "kube-dns" {
+		t.Errorf("expected DNS to the kube-dns pods only, got %+v", dns)
+	}
+	var cidrs []string
+	for _, peer := range egress[1].To {
+		cidrs = append(cidrs, peer.IPBlock.CIDR)
+	}
+	if !slices.Equal(cidrs, []string{"140.82.121.4/32", "2606:50c0::1/128"}) || egress[1].Ports[0].Port.IntValue() != 443 {
+		t.Errorf("unexpected host rule %+v", egress[1])
+	}
+	if egress[2].To[0].IPBlock.CIDR != "10.0.0.0/8" || len(egress[2].Ports) != 0 {
+		t.Errorf("unexpected CIDR rule %+v", egress[2])
+	}
+}
+
+func TestDNSEgressRuleConfigured(t *testing.T) {
+	rule := dnsEgressRule(DNSOptions{Namespace: "openshift-dns", PodLabels: map[string]string{"dns.operator.openshift.io/daemonset-dns": "default"}})
+	peer := rule.To[0]
+	if peer.NamespaceSelector.MatchLabels[corev1.LabelMetadataName] != "openshift-dns" || peer.PodSelector.MatchLabels["dns.operator.openshift.io/daemonset-dns"] != "default" || len(peer.PodSelector.MatchLabels) != 1 {
+		t.Errorf("unexpected DNS peer %+v", peer)
+	}
+	if len(rule.Ports) != 2 || rule.Ports[0].Port.IntValue() != 53 {
+		t.Errorf("unexpected DNS ports %+v", rule.Ports)
+	}
+}
+
+func TestRenderNetworkPolicyCollector(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	k.lookupIP = func(_ context.Context, host string) ([]net.IP, error) {
+		if host == "otel.example.com" {
+			return []net.IP{net.ParseIP("203.0.113.7")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "baca-org-repo-1234", Namespace: "baca-jobs"}}
+
+	policy, err := k.renderNetworkPolicy(t.Context(), job, nil, nil, DNSOptions{}, "http://otel.example.com:4318")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	egress := policy.Spec.Egress
+	if len(egress) != 2 || egress[1].To[0].IPBlock.CIDR != "203.0.113.7/32" || egress[1].Ports[0].Port.IntValue() != 4318 {
+		t.Errorf("expected a rule for the collector, got %+v", egress)
+	}
+
+	// Names only resolving in the cluster need a CIDR, the job runs anyway
+	policy, err = k.renderNetworkPolicy(t.Context(), job, nil, nil, DNSOptions{}, "http://otel-collector.monitoring:4318")
+	if err != nil || len(policy.Spec.Egress) != 1 {
+		t.Errorf("expected only the DNS rule, got %+v, %v", policy.Spec.Egress, err)
+	}
+}
+
+func TestCollectorAddress(t *testing.T) {
+	for endpoint, want := range map[string]struct {
+		host string
+		port int32
+	}{
+		"http://otel-collector.monitoring:4318": {"otel-collector.monitoring", 4318},
+		"https://otlp.example.com":              {"otlp.example.com", 443},
+		"http://10.0.0.5/v1/traces":             {"10.0.0.5", 80},
+	} {
+		host, port, ok := collectorAddress(endpoint)
+		if !ok || host != want.host || port != want.port {
+			t.Errorf("collectorAddress(%q) = %q, %d, %v", endpoint, host, port, ok)
+		}
+	}
+	if _, _, ok := collectorAddress(""); ok {
+		t.Error("expected no collector without tracing")
+	}
+}
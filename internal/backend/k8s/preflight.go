@@ -0,0 +1,143 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manno/baca/internal/preflight"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// access is a permission baca needs in the namespace. Optional ones are only
+// needed by some features, they are reported as warnings.
+type access struct {
+	verb, group, resource, subresource, name string
+	optional                                 string // what fails without it
+}
+
+func (k *KubernetesBackend) requiredAccess() []access {
+	return []access{
+		{verb: "create", group: "batch", resource: "jobs"},
+		{verb: "get", group: "batch", resource: "jobs"},
+		{verb: "list", resource: "pods"},
+		{verb: "get", resource: "pods", subresource: "log"},
+		{verb: "get", resource: "configmaps"},
+		{verb: "create", resource: "configmaps", optional: "runs aren't recorded in the history"},
+		{verb: "update", resource: "configmaps", optional: "job outcomes aren't recorded in the history"},
+		{verb: "get", resource: "secrets", name: k.secretName(), optional: "pod logs are only masked by patterns"},
+		{verb: "create", group: "networking.k8s.io", resource: "networkpolicies", optional: "jobs fail with network policies enabled"},
+	}
+}
+
+func (a access) String() string {
+	s := a.verb + " " + a.resource
+	if a.subresource != "" {
+		s += "/" + a.subresource
+	}
+	if a.name != "" {
+		s += " " + a.name
+	}
+	return s
+}
+
+// Preflight checks the namespace, the permissions of the current user in it
+// and the profile's credentials secret.
+func (k *KubernetesBackend) Preflight(ctx context.Context) preflight.Report {
+	var report preflight.Report
+
+	check := "namespace " + k.namespace
+	_, err := k.clientset.CoreV1().Namespaces().Get(ctx, k.namespace, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		report = append(report, preflight.Warnf(check, "doesn't exist yet, baca setup creates it"))
+	case apierrors.IsForbidden(err):
+		// Namespaced users often can't read the namespace itself
+		report = append(report, preflight.Warnf(check, "can't be read, assuming it exists"))
+	case err != nil:
+		return append(report, preflight.Failf(check, "cluster unreachable: %v", err))
+	default:
+		report = append(report, preflight.Passf(check, "exists"))
+	}
+
+	for _, a := range k.requiredAccess() {
+		report = append(report, k.checkAccess(ctx, a))
+	}
+
+	check = "secret " + k.secretName()
+	err = k.client.Get(ctx, client.ObjectKey{Name: k.secretName(), Namespace: k.namespace}, &corev1.Secret{})
+	switch {
+	case apierrors.IsNotFound(err):
+		report = append(report, preflight.Warnf(check, "doesn't exist, run baca setup unless all credentials are referenced"))
+	case apierrors.IsForbidden(err):
+		report = append(report, preflight.Warnf(check, "can't be read"))
+	case err != nil:
+		report = append(report, preflight.Failf(check, "%v", err))
+	default:
+		report = append(report, preflight.Passf(check, "exists"))
+	}
+	return report
+}
+
+func (k *KubernetesBackend) checkAccess(ctx context.Context, a access) preflight.Result {
+	check := "rbac " + a.String()
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   k.namespace,
+				Verb:        a.verb,
+				Group:       a.group,
+				Resource:    a.resource,
+				Subresource: a.subresource,
+				Name:        a.name,
+			},
+		},
+	}
+	result, err := k.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	switch {
+	case err != nil:
+		return preflight.Warnf(check, "can't review access: %v", err)
+	case result.Status.Allowed:
+		return preflight.Passf(check, "allowed")
+	case a.optional != "":
+		return preflight.Warnf(check, "denied, %s", a.optional)
+	}
+	return preflight.Failf(check, "denied")
+}
+
+// Credentials returns the profile's credentials, including those referenced
+// in other secrets. Credentials injected from Vault can't be read.
+func (k *KubernetesBackend) Credentials(ctx context.Context) (map[string]string, error) {
+	if err := k.loadCredentialRefs(ctx); err != nil {
+		return nil, err
+	}
+
+	credentials := map[string]string{}
+	secret := &corev1.Secret{}
+	err := k.client.Get(ctx, client.ObjectKey{Name: k.secretName(), Namespace: k.namespace}, secret)
+	switch {
+	case apierrors.IsNotFound(err) && k.refs != nil:
+	case err != nil:
+		return nil, fmt.Errorf("failed to get credentials secret: %w", err)
+	default:
+		for key, value := range secret.Data {
+			credentials[key] = string(value)
+		}
+	}
+
+	for _, key := range k.refs.Keys() {
+		ref, ok := k.refs.secretRef(key)
+		if !ok {
+			continue
+		}
+		secret := &corev1.Secret{}
+		if err := k.client.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: k.namespace}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get referenced secret %s: %w", ref.Name, err)
+		}
+		credentials[key] = string(secret.Data[ref.Key])
+	}
+	return credentials, nil
+}
@@ -0,0 +1,409 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	refsConfigMapKey = "refs.json"
+	// vaultSecretsDir is where the Vault Agent injector renders secrets,
+	// baca loads credentials from files there into its environment
+	vaultSecretsDir = "/vault/secrets"
+	// credentialFilesEnv names the directory for baca to load credentials
+	// from, see secrets.LoadDir
+	credentialFilesEnv = "BACA_CREDENTIALS_FILES"
+)
+
+// CredentialRefs point to credentials BACA doesn't store itself, so tokens
+// never pass through the machine running setup. They are kept in the
+// profile's baca-credential-refs ConfigMap and wired into the jobs by apply.
+type CredentialRefs struct {
+	// Secrets maps credentials to keys of existing secrets in the namespace
+	Secrets map[string]SecretKeyRef `json:"secrets,omitempty"`
+	// Vault injects credentials with the Vault Agent injector
+	Vault *VaultRefs `json:"vault,omitempty"`
+}
+
+type SecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// VaultRefs configure the Vault Agent injector. The agent logs in with the
+// token of the job's service account, using Vault's Kubernetes auth method.
+type VaultRefs struct {
+	Role           string            `json:"role"`                     // Kubernetes auth role
+	ServiceAccount string            `json:"serviceAccount,omitempty"` // Service account of the jobs
+	Secrets        map[string]string `json:"secrets"`                  // Credential to "path#field"
+}
+
+// ParseSecretKeyRef parses "secret/key".
+func ParseSecretKeyRef(s string) (SecretKeyRef, error) {
+	name, key, ok := strings.Cut(s, "/")
+	if !ok || name == "" || key == "" {
+		return SecretKeyRef{}, fmt.Errorf("invalid secret reference %q: must be secret/key", s)
+	}
+	return SecretKeyRef{Name: name, Key: key}, nil
+}
+
+// checkSecretRefs refuses references to the credentials of other profiles,
+// the jobs could otherwise use another user's tokens.
+func (k *KubernetesBackend) checkSecretRefs(refs *CredentialRefs) error {
+	if refs == nil {
+		return nil
+	}
+	for key, ref := range refs.Secrets {
+		if ref.Name != k.secretName() && (ref.Name == defaultSecretName || strings.HasPrefix(ref.Name, defaultSecretName+"-")) {
+			return fmt.Errorf("credential reference %s: %s holds the credentials of another profile", key, ref.Name)
+		}
+	}
+	return nil
+}
+
+// ParseVaultRef parses "path#field" of a KV v2 secret.
+func ParseVaultRef(s string) (path, field string, err error) {
+	path, field, ok := strings.Cut(s, "#")
+	if !ok || path == "" || field == "" {
+		return "", "", fmt.Errorf("invalid vault reference %q: must be path#field, e.g. secret/data/baca#github_token", s)
+	}
+	return path, field, nil
+}
+
+// Keys returns the names of all referenced credentials.
+func (r *CredentialRefs) Keys() []string {
+	var keys []string
+	if r == nil {
+		return keys
+	}
+	for key := range r.Secrets {
+		keys = append(keys, key)
+	}
+	if r.Vault != nil {
+		for key := range r.Vault.Secrets {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func refsConfigMapName(profile string) string {
+	if profile == "" {
+		return "baca-credential-refs"
+	}
+	return "baca-credential-refs-" + profile
+}
+
+// SetupCredentialRefs stores the references for apply, owned by the current
+// user unless it is the default profile.
+func (k *KubernetesBackend) SetupCredentialRefs(ctx context.Context, refs *CredentialRefs) error {
+	if err := k.checkSecretRefs(refs); err != nil {
+		return err
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return fmt.Errorf("failed to encode credential references: %w", err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      refsConfigMapName(k.profile),
+			Namespace: k.namespace,
+		},
+		Data: map[string]string{refsConfigMapKey: string(data)},
+	}
+	if k.profile != "" {
+		cm.Labels = map[string]string{profileLabel: k.profile}
+	}
+	if err := k.claimProfile(ctx, cm); err != nil {
+		return err
+	}
+
+	if err := k.client.Create(ctx, cm); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create credential references: %w", err)
+		}
+		existing := &corev1.ConfigMap{}
+		if err := k.client.Get(ctx, client.ObjectKeyFromObject(cm), existing); err != nil {
+			return fmt.Errorf("failed to get credential references: %w", err)
+		}
+		if err := k.claimProfile(ctx, existing); err != nil {
+			return err
+		}
+		existing.Data = cm.Data
+		if err := k.client.Update(ctx, existing); err != nil {
+			return fmt.Errorf("failed to update credential references: %w", err)
+		}
+	}
+	k.logger.Info("credential references stored", "name", cm.Name, "credentials", refs.Keys())
+	return nil
+}
+
+// ForgetCredentialRefs drops the references of credentials stored in the
+// profile's secret now, which would take precedence otherwise. The
+// ConfigMap is deleted once no references are left.
+func (k *KubernetesBackend) ForgetCredentialRefs(ctx context.Context, keys []string) error {
+	cm := &corev1.ConfigMap{}
+	err := k.client.Get(ctx, client.ObjectKey{Name: refsConfigMapName(k.profile), Namespace: k.namespace}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to get credential references: %w", err)
+	}
+	if err := k.checkOwner(ctx, cm); err != nil {
+		return err
+	}
+	refs := &CredentialRefs{}
+	if err := json.Unmarshal([]byte(cm.Data[refsConfigMapKey]), refs); err != nil {
+		return fmt.Errorf("invalid credential references in %s: %w", cm.Name, err)
+	}
+
+	var forgotten []string
+	for _, key := range keys {
+		_, secret := refs.Secrets[key]
+		delete(refs.Secrets, key)
+		vault := false
+		if refs.Vault != nil {
+			_, vault = refs.Vault.Secrets[key]
+			delete(refs.Vault.Secrets, key)
+		}
+		if secret || vault {
+			forgotten = append(forgotten, key)
+		}
+	}
+	if len(forgotten) == 0 {
+		return nil
+	}
+	if refs.Vault != nil && len(refs.Vault.Secrets) == 0 {
+		refs.Vault = nil
+	}
+
+	if len(refs.Keys()) == 0 {
+		if err := k.client.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete credential references: %w", err)
+		}
+		k.logger.Info("credential references deleted, the credentials are stored now", "name", cm.Name)
+		return nil
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return fmt.Errorf("failed to encode credential references: %w", err)
+	}
+	cm.Data[refsConfigMapKey] = string(data)
+	if err := k.client.Update(ctx, cm); err != nil {
+		return fmt.Errorf("failed to update credential references: %w", err)
+	}
+	k.logger.Info("credential references dropped, the credentials are stored now", "name", cm.Name, "credentials", forgotten)
+	return nil
+}
+
+// loadCredentialRefs reads the profile's references, if there are any.
+func (k *KubernetesBackend) loadCredentialRefs(ctx context.Context) error {
+	cm := &corev1.ConfigMap{}
+	err := k.client.Get(ctx, client.ObjectKey{Name: refsConfigMapName(k.profile), Namespace: k.namespace}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to get credential references: %w", err)
+	}
+
+	if err := k.checkOwner(ctx, cm); err != nil {
+		return fmt.Errorf("can't use profile %q: %w", k.profile, err)
+	}
+	refs := &CredentialRefs{}
+	if err := json.Unmarshal([]byte(cm.Data[refsConfigMapKey]), refs); err != nil {
+		return fmt.Errorf("invalid credential references in %s: %w", cm.Name, err)
+	}
+	if err := k.checkSecretRefs(refs); err != nil {
+		return fmt.Errorf("invalid credential references in %s: %w", cm.Name, err)
+	}
+	k.refs = refs
+	k.logger.Info("using credential references", "credentials", refs.Keys())
+	return nil
+}
+
+// credentialEnv returns the environment variable for a credential, from its
+// reference or the profile's secret.
+func (k *KubernetesBackend) credentialEnv(name string) corev1.EnvVar {
+	ref := SecretKeyRef{Name: k.secretName(), Key: name}
+	if r, ok := k.refs.secretRef(name); ok {
+		ref = r
+	}
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+				Key:                  ref.Key,
+				Optional:             boolPtr(true),
+			},
+		},
+	}
+}
+
+func (r *CredentialRefs) secretRef(name string) (SecretKeyRef, bool) {
+	if r == nil {
+		return SecretKeyRef{}, false
+	}
+	ref, ok := r.Secrets[name]
+	return ref, ok
+}
+
+// refEnv returns the variables for all secret references, they take
+// precedence over the profile's secret.
+func (k *KubernetesBackend) refEnv() []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, key := range k.refs.Keys() {
+		if _, ok := k.refs.secretRef(key); ok {
+			env = append(env, k.credentialEnv(key))
+		}
+	}
+	return env
+}
+
+// credentialsVolume projects the profile's secret and all secret references
+// into one directory, for the secret scanning of baca publish.
+func (k *KubernetesBackend) credentialsVolume() corev1.Volume {
+	sources := []corev1.VolumeProjection{{
+		Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{Name: k.secretName()},
+			Optional:             boolPtr(k.refs != nil),
+		},
+	}}
+	for _, key := range k.refs.Keys() {
+		if ref, ok := k.refs.secretRef(key); ok {
+			sources = append(sources, corev1.VolumeProjection{
+				Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+					Items:                []corev1.KeyToPath{{Key: ref.Key, Path: key}},
+					Optional:             boolPtr(true),
+				},
+			})
+		}
+	}
+	return corev1.Volume{
+		Name: "credentials",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+		},
+	}
+}
+
+// vaultAnnotations configure the Vault Agent injector to render each
+// credential into a file before the containers start. The agent container
+// is left out, it only gets its own credentials from secrets.
+func (k *KubernetesBackend) vaultAnnotations(containers []string) map[string]string {
+	if k.refs == nil || k.refs.Vault == nil {
+		return nil
+	}
+	annotations := map[string]string{
+		"vault.hashicorp.com/agent-inject": "true",
+		"vault.hashicorp.com/role":         k.refs.Vault.Role,
+		// Jobs must terminate, so there is no sidecar renewing secrets
+		"vault.hashicorp.com/agent-pre-populate-only": "true",
+		"vault.hashicorp.com/agent-init-first":        "true",
+		"vault.hashicorp.com/agent-inject-containers": strings.Join(containers, ","),
+	}
+	for key, ref := range k.refs.Vault.Secrets {
+		path, field, err := ParseVaultRef(ref)
+		if err != nil {
+			continue
+		}
+		annotations["vault.hashicorp.com/agent-inject-secret-"+key] = path
+		annotations["vault.hashicorp.com/agent-inject-template-"+key] = fmt.Sprintf(`{{- with secret %q -}}{{ .Data.data.%s }}{{- end -}}`, path, field)
+	}
+	return annotations
+}
+
+// ExternalSecretStore selects the store of the External Secrets Operator.
+type ExternalSecretStore struct {
+	Kind string // SecretStore or ClusterSecretStore
+	Name string
+}
+
+// ParseExternalSecretStore parses "name" or "ClusterSecretStore/name".
+func ParseExternalSecretStore(s string) (ExternalSecretStore, error) {
+	kind, name, ok := strings.Cut(s, "/")
+	if !ok {
+		return ExternalSecretStore{Kind: "SecretStore", Name: s}, nil
+	}
+	if kind != "SecretStore" && kind != "ClusterSecretStore" {
+		return ExternalSecretStore{}, fmt.Errorf("invalid secret store kind %q: must be SecretStore or ClusterSecretStore", kind)
+	}
+	return ExternalSecretStore{Kind: kind, Name: name}, nil
+}
+
+var externalSecretGVK = schema.GroupVersionKind{Group: "external-secrets.io", Version: "v1beta1", Kind: "ExternalSecret"}
+
+// SetupExternalSecret creates an ExternalSecret, which makes the External
+// Secrets Operator sync the profile's secret from store. remoteRefs maps
+// credentials to "key" or "key#property" in the store.
+func (k *KubernetesBackend) SetupExternalSecret(ctx context.Context, store ExternalSecretStore, remoteRefs map[string]string) error {
+	keys := make([]string, 0, len(remoteRefs))
+	for key := range remoteRefs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var data []any
+	for _, key := range keys {
+		remoteKey, property, _ := strings.Cut(remoteRefs[key], "#")
+		remoteRef := map[string]any{"key": remoteKey}
+		if property != "" {
+			remoteRef["property"] = property
+		}
+		data = append(data, map[string]any{"secretKey": key, "remoteRef": remoteRef})
+	}
+
+	es := &unstructured.Unstructured{}
+	es.SetGroupVersionKind(externalSecretGVK)
+	es.SetName(k.secretName())
+	es.SetNamespace(k.namespace)
+	if k.profile != "" {
+		es.SetLabels(map[string]string{profileLabel: k.profile})
+	}
+	spec := map[string]any{
+		"refreshInterval": "1h",
+		"secretStoreRef":  map[string]any{"kind": store.Kind, "name": store.Name},
+		"target":          map[string]any{"name": k.secretName(), "creationPolicy": "Owner"},
+		"data":            data,
+	}
+	if err := unstructured.SetNestedField(es.Object, spec, "spec"); err != nil {
+		return fmt.Errorf("failed to build external secret: %w", err)
+	}
+	if err := k.claimProfile(ctx, es); err != nil {
+		return err
+	}
+
+	if err := k.client.Create(ctx, es); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create external secret (is the External Secrets Operator installed?): %w", err)
+		}
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(externalSecretGVK)
+		if err := k.client.Get(ctx, client.ObjectKeyFromObject(es), existing); err != nil {
+			return fmt.Errorf("failed to get external secret: %w", err)
+		}
+		if err := k.claimProfile(ctx, existing); err != nil {
+			return err
+		}
+		existing.Object["spec"] = es.Object["spec"]
+		if err := k.client.Update(ctx, existing); err != nil {
+			return fmt.Errorf("failed to update external secret: %w", err)
+		}
+	}
+	k.logger.Info("external secret stored", "name", es.GetName(), "store", store.Name, "credentials", keys)
+	return nil
+}
@@ -0,0 +1,184 @@
+package k8s
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/change"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseCredentialRefs(t *testing.T) {
+	ref, err := ParseSecretKeyRef("team-secrets/github-token")
+	if err != nil || ref.Name != "team-secrets" || ref.Key != "github-token" {
+		t.Errorf("unexpected secret reference %+v, %v", ref, err)
+	}
+	for _, s := range []string{"team-secrets", "/key", "secret/"} {
+		if _, err := ParseSecretKeyRef(s); err == nil {
+			t.Errorf("ParseSecretKeyRef(%q): expected error", s)
+		}
+	}
+
+	path, field, err := ParseVaultRef("secret/data/baca#github_token")
+	if err != nil || path != "secret/data/baca" || field != "github_token" {
+		t.Errorf("unexpected vault reference %q, %q, %v", path, field, err)
+	}
+	if _, _, err := ParseVaultRef("secret/data/baca"); err == nil {
+		t.Error("expected error for vault reference without field")
+	}
+
+	store, err := ParseExternalSecretStore("ClusterSecretStore/vault")
+	if err != nil || store.Kind != "ClusterSecretStore" || store.Name != "vault" {
+		t.Errorf("unexpected store %+v, %v", store, err)
+	}
+	if store, _ := ParseExternalSecretStore("vault"); store.Kind != "SecretStore" {
+		t.Errorf("expected SecretStore by default, got %+v", store)
+	}
+	if _, err := ParseExternalSecretStore("Secret/vault"); err == nil {
+		t.Error("expected error for invalid store kind")
+	}
+}
+
+func TestRenderChangeCredentialRefs(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	k.refs = &CredentialRefs{
+		Secrets: map[string]SecretKeyRef{
+			"GITHUB_TOKEN":   {Name: "team-secrets", Key: "github-token"},
+			"GEMINI_API_KEY": {Name: "team-secrets", Key: "gemini"},
+		},
+		Vault: &VaultRefs{
+			Role:           "baca",
+			ServiceAccount: "baca-jobs",
+			Secrets:        map[string]string{"GITLAB_TOKEN": "secret/data/baca#gitlab"},
+		},
+	}
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos:  []string{"https://github.com/org/repo1"},
+			Agent:  "gemini-cli",
+		},
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	pod := jobs[0].Spec.Template
+	spec := pod.Spec
+
+	containers := map[string]corev1.Container{}
+	for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		containers[c.Name] = c
+	}
+	if ref := envSecretRef(containers["agent"], "GEMINI_API_KEY"); ref == nil || ref.Name != "team-secrets" || ref.Key != "gemini" {
+		t.Errorf("expected agent to reference team-secrets/gemini, got %+v", ref)
+	}
+	if ref := envSecretRef(containers["agent"], "GITHUB_TOKEN"); ref != nil {
+		t.Errorf("expected agent not to get GITHUB_TOKEN, got %+v", ref)
+	}
+	if ref := envSecretRef(containers["publish"], "GITHUB_TOKEN"); ref == nil || ref.Name != "team-secrets" || ref.Key != "github-token" {
+		t.Errorf("expected publish to reference team-secrets/github-token, got %+v", ref)
+	}
+	if !hasEnv(containers["publish"], credentialFilesEnv, vaultSecretsDir) {
+		t.Errorf("expected publish to load credentials from %s", vaultSecretsDir)
+	}
+	if from := containers["publish"].EnvFrom; len(from) != 1 || from[0].SecretRef.Optional == nil || !*from[0].SecretRef.Optional {
+		t.Errorf("expected the credentials secret to be optional, got %+v", from)
+	}
+
+	var volume *corev1.Volume
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == "credentials" {
+			volume = &spec.Volumes[i]
+		}
+	}
+	if volume == nil || volume.Projected == nil || len(volume.Projected.Sources) != 3 {
+		t.Fatalf("expected projected credentials volume with 3 sources, got %+v", volume)
+	}
+
+	if spec.ServiceAccountName != "baca-jobs" {
+		t.Errorf("expected service account baca-jobs, got %q", spec.ServiceAccountName)
+	}
+	annotations := pod.Annotations
+	if annotations["vault.hashicorp.com/role"] != "baca" || annotations["vault.hashicorp.com/agent-inject-secret-GITLAB_TOKEN"] != "secret/data/baca" {
+		t.Errorf("unexpected vault annotations %v", annotations)
+	}
+	if injected := strings.Split(annotations["vault.hashicorp.com/agent-inject-containers"], ","); slices.Contains(injected, "agent") || !slices.Contains(injected, "publish") {
+		t.Errorf("expected vault to inject into all containers but the agent, got %v", injected)
+	}
+	if len(restrictedViolations(spec)) > 0 {
+		t.Errorf("unexpected violations %v", restrictedViolations(spec))
+	}
+}
+
+func envSecretRef(c corev1.Container, name string) *corev1.SecretKeySelector {
+	for _, env := range c.Env {
+		if env.Name == name && env.ValueFrom != nil {
+			return env.ValueFrom.SecretKeyRef
+		}
+	}
+	return nil
+}
+
+func hasEnv(c corev1.Container, name, value string) bool {
+	for _, env := range c.Env {
+		if env.Name == name && env.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckSecretRefs(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil))).WithProfile("alice")
+	for _, name := range []string{"team-secrets", "baca-credentials-alice", "baca-credentialsx"} {
+		refs := &CredentialRefs{Secrets: map[string]SecretKeyRef{"GITHUB_TOKEN": {Name: name, Key: "GITHUB_TOKEN"}}}
+		if err := k.checkSecretRefs(refs); err != nil {
+			t.Errorf("reference to %s: unexpected error %v", name, err)
+		}
+	}
+	for _, name := range []string{"baca-credentials", "baca-credentials-bob"} {
+		refs := &CredentialRefs{Secrets: map[string]SecretKeyRef{"GITHUB_TOKEN": {Name: name, Key: "GITHUB_TOKEN"}}}
+		if err := k.checkSecretRefs(refs); err == nil || !strings.Contains(err.Error(), "another profile") {
+			t.Errorf("reference to %s: expected error, got %v", name, err)
+		}
+	}
+}
+
+func TestSetupExternalSecretOwner(t *testing.T) {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(externalSecretGVK)
+	existing.SetName("baca-credentials-alice")
+	existing.SetNamespace("baca-jobs")
+	existing.SetAnnotations(map[string]string{submittedByAnnotation: "bob"})
+	store := ExternalSecretStore{Kind: "ClusterSecretStore", Name: "vault"}
+	refs := map[string]string{"GITHUB_TOKEN": "baca/github#token"}
+
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil))).WithProfile("alice")
+	k.identity = "alice"
+	k.client = fake.NewClientBuilder().WithObjects(existing).Build()
+	if err := k.SetupExternalSecret(t.Context(), store, refs); err == nil || !strings.Contains(err.Error(), "belongs to bob") {
+		t.Errorf("expected other user's external secret to be refused, got %v", err)
+	}
+
+	k.client = fake.NewClientBuilder().Build()
+	if err := k.SetupExternalSecret(t.Context(), store, refs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(externalSecretGVK)
+	if err := k.client.Get(t.Context(), client.ObjectKey{Name: "baca-credentials-alice", Namespace: "baca-jobs"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if owner := got.GetAnnotations()[submittedByAnnotation]; owner != "alice" {
+		t.Errorf("expected the external secret to be claimed by alice, got %q", owner)
+	}
+}
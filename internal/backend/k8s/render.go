@@ -0,0 +1,104 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// NewOffline returns a backend without cluster access. It can only be used to
+// render manifests, e.g. for `baca apply --dry-run=client`.
+func NewOffline(namespace string, logger *slog.Logger) *KubernetesBackend {
+	return &KubernetesBackend{
+		namespace: namespace,
+		logger:    logger,
+	}
+}
+
+// WriteManifests writes the jobs as a multi-document YAML stream or a JSON
+// list to w.
+func WriteManifests(w io.Writer, jobs []*batchv1.Job, format string) error {
+	if format == "json" {
+		list := &batchv1.JobList{}
+		list.APIVersion = "v1"
+		list.Kind = "List"
+		for _, job := range jobs {
+			list.Items = append(list.Items, *withTypeMeta(job))
+		}
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal jobs: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	for i, job := range jobs {
+		data, err := marshalManifest(job, format)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := fmt.Fprintln(w, "---"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteManifestsToDir writes one file per job into dir, named after the job.
+func WriteManifestsToDir(dir string, jobs []*batchv1.Job, format string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, job := range jobs {
+		data, err := marshalManifest(job, format)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, job.Name+"."+format)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return fmt.Errorf("failed to write manifest %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func marshalManifest(job *batchv1.Job, format string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch format {
+	case "yaml":
+		data, err = yaml.Marshal(withTypeMeta(job))
+	case "json":
+		data, err = json.MarshalIndent(withTypeMeta(job), "", "  ")
+		data = append(data, '\n')
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job %s: %w", job.Name, err)
+	}
+	return data, nil
+}
+
+// withTypeMeta makes sure apiVersion and kind are set, the typed client
+// clears them on objects returned by the API server.
+func withTypeMeta(job *batchv1.Job) *batchv1.Job {
+	job = job.DeepCopy()
+	job.SetGroupVersionKind(batchv1.SchemeGroupVersion.WithKind("Job"))
+	return job
+}
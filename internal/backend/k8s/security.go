@@ -0,0 +1,199 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultUID is the baca user of the runner image
+	defaultUID = 10001
+	// homeDir is writable, agents keep their configuration and caches there
+	homeDir = "/home/baca"
+)
+
+// SecurityOptions harden the job pods. The zero value meets the "restricted"
+// Pod Security Standard. It is read from the `security` key of the config
+// file.
+type SecurityOptions struct {
+	RunAsUser int64 // UID of all containers, default 10001
+	// AllowRoot runs the containers as the image's user, e.g. for custom
+	// images without a non-root user. The pods are no longer "restricted".
+	AllowRoot bool
+	// WritableRootFilesystem is for images writing outside of the home,
+	// /tmp and /workspace directories.
+	WritableRootFilesystem bool
+	// RuntimeClassName runs the pods in a sandboxed runtime, e.g. gvisor
+	// or kata, which must be installed in the cluster.
+	RuntimeClassName string
+}
+
+func (o SecurityOptions) podSecurityContext() *corev1.PodSecurityContext {
+	sc := &corev1.PodSecurityContext{
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if o.AllowRoot {
+		return sc
+	}
+	uid := o.RunAsUser
+	if uid == 0 {
+		uid = defaultUID
+	}
+	sc.RunAsNonRoot = boolPtr(true)
+	sc.RunAsUser = &uid
+	sc.RunAsGroup = &uid
+	// Makes the workspace and mounted secrets accessible to the user
+	sc.FSGroup = &uid
+	return sc
+}
+
+func (o SecurityOptions) containerSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: boolPtr(false),
+		ReadOnlyRootFilesystem:   boolPtr(!o.WritableRootFilesystem),
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+}
+
+// harden applies the options to the pod: security contexts, writable
+// volumes for the home and temp directories and the runtime class.
+func (o SecurityOptions) harden(spec *corev1.PodSpec) {
+	spec.SecurityContext = o.podSecurityContext()
+	if o.RuntimeClassName != "" {
+		spec.RuntimeClassName = &o.RuntimeClassName
+	}
+
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{Name: "home", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	)
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			c := &containers[i]
+			c.SecurityContext = o.containerSecurityContext()
+			c.VolumeMounts = append(c.VolumeMounts,
+				corev1.VolumeMount{Name: "home", MountPath: homeDir},
+				corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"},
+			)
+			c.Env = append(c.Env, corev1.EnvVar{Name: "HOME", Value: homeDir})
+		}
+	}
+}
+
+// restrictedVolumes are the volume types the "restricted" standard allows.
+var restrictedVolumes = map[string]func(corev1.VolumeSource) bool{
+	"configMap":             func(v corev1.VolumeSource) bool { return v.ConfigMap != nil },
+	"csi":                   func(v corev1.VolumeSource) bool { return v.CSI != nil },
+	"downwardAPI":           func(v corev1.VolumeSource) bool { return v.DownwardAPI != nil },
+	"emptyDir":              func(v corev1.VolumeSource) bool { return v.EmptyDir != nil },
+	"ephemeral":             func(v corev1.VolumeSource) bool { return v.Ephemeral != nil },
+	"persistentVolumeClaim": func(v corev1.VolumeSource) bool { return v.PersistentVolumeClaim != nil },
+	"projected":             func(v corev1.VolumeSource) bool { return v.Projected != nil },
+	"secret":                func(v corev1.VolumeSource) bool { return v.Secret != nil },
+}
+
+// restrictedViolations checks a pod against the "restricted" Pod Security
+// Standard, so a job is rejected before Pod Security Admission refuses to
+// create its pod.
+func restrictedViolations(spec corev1.PodSpec) []string {
+	var violations []string
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		violations = append(violations, "host namespaces must not be shared")
+	}
+	for _, v := range spec.Volumes {
+		allowed := false
+		for _, isType := range restrictedVolumes {
+			if isType(v.VolumeSource) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			violations = append(violations, fmt.Sprintf("volume %s has a forbidden type", v.Name))
+		}
+	}
+
+	pod := spec.SecurityContext
+	if pod == nil {
+		pod = &corev1.PodSecurityContext{}
+	}
+	for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		sc := c.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			violations = append(violations, c.Name+": must not be privileged")
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			violations = append(violations, c.Name+": allowPrivilegeEscalation must be false")
+		}
+		if !isTrue(sc.RunAsNonRoot, pod.RunAsNonRoot) {
+			violations = append(violations, c.Name+": runAsNonRoot must be true")
+		}
+		if uid := sc.RunAsUser; (uid != nil && *uid == 0) || (uid == nil && pod.RunAsUser != nil && *pod.RunAsUser == 0) {
+			violations = append(violations, c.Name+": runAsUser must not be 0")
+		}
+		seccomp := sc.SeccompProfile
+		if seccomp == nil {
+			seccomp = pod.SeccompProfile
+		}
+		if seccomp == nil || (seccomp.Type != corev1.SeccompProfileTypeRuntimeDefault && seccomp.Type != corev1.SeccompProfileTypeLocalhost) {
+			violations = append(violations, c.Name+": seccompProfile must be RuntimeDefault or Localhost")
+		}
+		if sc.Capabilities == nil || !hasCapability(sc.Capabilities.Drop, "ALL") {
+			violations = append(violations, c.Name+": capabilities must drop ALL")
+		} else {
+			for _, add := range sc.Capabilities.Add {
+				if add != "NET_BIND_SERVICE" {
+					violations = append(violations, fmt.Sprintf("%s: capability %s must not be added", c.Name, add))
+				}
+			}
+		}
+	}
+	return violations
+}
+
+func isTrue(container, pod *bool) bool {
+	if container != nil {
+		return *container
+	}
+	return pod != nil && *pod
+}
+
+func hasCapability(caps []corev1.Capability, name corev1.Capability) bool {
+	for _, c := range caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupPodSecurity labels the namespace to enforce a Pod Security Standard,
+// e.g. "restricted".
+func (k *KubernetesBackend) SetupPodSecurity(ctx context.Context, level string) error {
+	switch level {
+	case "privileged", "baseline", "restricted":
+	default:
+		return fmt.Errorf("invalid pod security level %q: must be privileged, baseline or restricted", level)
+	}
+
+	ns := &corev1.Namespace{}
+	if err := k.client.Get(ctx, client.ObjectKey{Name: k.namespace}, ns); err != nil {
+		return fmt.Errorf("failed to get namespace: %w", err)
+	}
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	ns.Labels["pod-security.kubernetes.io/enforce"] = level
+	ns.Labels["pod-security.kubernetes.io/enforce-version"] = "latest"
+	if err := k.client.Update(ctx, ns); err != nil {
+		return fmt.Errorf("failed to label namespace: %w", err)
+	}
+	k.logger.Info("pod security level enforced", "namespace", k.namespace, "level", level)
+	return nil
+}
@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/change"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRenderChangeSecurity(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos:  []string{"https://github.com/example/repo1"},
+			Agent:  "gemini-cli",
+		},
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{Security: SecurityOptions{RuntimeClassName: "gvisor"}})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	spec := jobs[0].Spec.Template.Spec
+	if violations := restrictedViolations(spec); len(violations) > 0 {
+		t.Errorf("expected a restricted pod, got %v", violations)
+	}
+	if *spec.SecurityContext.RunAsUser != defaultUID || spec.RuntimeClassName == nil || *spec.RuntimeClassName != "gvisor" {
+		t.Errorf("unexpected pod security %+v, runtime class %v", spec.SecurityContext, spec.RuntimeClassName)
+	}
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		if !*container.SecurityContext.ReadOnlyRootFilesystem {
+			t.Errorf("%s: expected read-only root filesystem", container.Name)
+		}
+		mounts := map[string]bool{}
+		for _, m := range container.VolumeMounts {
+			mounts[m.MountPath] = true
+		}
+		if !mounts["/workspace"] || !mounts["/tmp"] || !mounts[homeDir] {
+			t.Errorf("%s: expected writable workspace, tmp and home, got %v", container.Name, container.VolumeMounts)
+		}
+	}
+
+	jobs, err = k.RenderChange(c, ApplyOptions{Security: SecurityOptions{AllowRoot: true, WritableRootFilesystem: true}})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	spec = jobs[0].Spec.Template.Spec
+	if spec.SecurityContext.RunAsNonRoot != nil || *spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem {
+		t.Errorf("expected root and a writable root filesystem to be allowed, got %+v", spec.SecurityContext)
+	}
+}
+
+func TestRestrictedViolations(t *testing.T) {
+	spec := corev1.PodSpec{
+		HostNetwork: true,
+		Volumes: []corev1.Volume{{
+			Name:         "host",
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}},
+		}},
+		Containers: []corev1.Container{{
+			Name: "runner",
+			SecurityContext: &corev1.SecurityContext{
+				Privileged:   boolPtr(true),
+				Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}, Add: []corev1.Capability{"SYS_ADMIN"}},
+			},
+		}},
+	}
+
+	got := strings.Join(restrictedViolations(spec), "\n")
+	for _, want := range []string{
+		"host namespaces",
+		"volume host",
+		"runner: must not be privileged",
+		"runner: allowPrivilegeEscalation",
+		"runner: runAsNonRoot",
+		"runner: seccompProfile",
+		"runner: capability SYS_ADMIN",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected violation %q in:\n%s", want, got)
+		}
+	}
+}
@@ -3,13 +3,19 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"sort"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (k *KubernetesBackend) Setup(ctx context.Context, credentials map[string]string) error {
+// Setup creates the namespace and stores the credentials in the profile's
+// secret, owned by the current user unless it is the default profile. Keys
+// of an existing secret which aren't given are kept. The time
+// each key was stored and, if known, expires is recorded as annotations.
+func (k *KubernetesBackend) Setup(ctx context.Context, credentials map[string]string, expires map[string]time.Time) error {
 	k.logger.Info("setting up kubernetes backend", "namespace", k.namespace)
 
 	// Create namespace if it doesn't exist
@@ -29,17 +35,37 @@ func (k *KubernetesBackend) Setup(ctx context.Context, credentials map[string]st
 		k.logger.Info("namespace created", "namespace", k.namespace)
 	}
 
+	// Referenced credentials are not stored, the secret may be owned by the
+	// External Secrets Operator
+	if len(credentials) == 0 {
+		k.logger.Info("no credentials to store")
+		return nil
+	}
+
 	// Create secret with all provided credentials
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "baca-credentials",
+			Name:      k.secretName(),
 			Namespace: k.namespace,
 		},
 		Type:       corev1.SecretTypeOpaque,
 		StringData: credentials,
 	}
+	if k.profile != "" {
+		secret.Labels = map[string]string{profileLabel: k.profile}
+	}
+	if err := k.claimProfile(ctx, secret); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(credentials))
+	for key := range credentials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	now := time.Now()
+	annotateCredentials(secret, keys, expires, now)
 
-	k.logger.Info("storing credentials", "count", len(credentials))
+	k.logger.Info("storing credentials", "secret", secret.Name, "count", len(credentials))
 
 	if err := k.client.Create(ctx, secret); err != nil {
 		// Check if it already exists and update
@@ -49,7 +75,11 @@ func (k *KubernetesBackend) Setup(ctx context.Context, credentials map[string]st
 		}
 
 		// Update existing secret
+		if err := k.claimProfile(ctx, existingSecret); err != nil {
+			return err
+		}
 		existingSecret.StringData = secret.StringData
+		annotateCredentials(existingSecret, keys, expires, now)
 		if err := k.client.Update(ctx, existingSecret); err != nil {
 			return fmt.Errorf("failed to update secret: %w", err)
 		}
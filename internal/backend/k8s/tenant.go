@@ -0,0 +1,104 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// submittedByAnnotation holds the Kubernetes user which submitted a job
+	// or run, or set up a profile, submittedByLabel a label-safe form of it
+	submittedByAnnotation = "baca.io/submitted-by"
+	submittedByLabel      = "baca.io/submitted-by"
+	profileLabel          = "baca.io/profile"
+
+	defaultSecretName = "baca-credentials"
+)
+
+// profilePattern matches names which are valid as a secret name suffix
+var profilePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,40}[a-z0-9])?$`)
+
+// ValidateProfile checks a credentials profile name, empty is the default.
+func ValidateProfile(profile string) error {
+	if profile != "" && !profilePattern.MatchString(profile) {
+		return fmt.Errorf("invalid profile %q: must be lower case alphanumeric or '-', up to 42 characters", profile)
+	}
+	return nil
+}
+
+// CredentialsSecretName returns the secret holding a profile's credentials,
+// baca-credentials for the default profile.
+func CredentialsSecretName(profile string) string {
+	if profile == "" {
+		return defaultSecretName
+	}
+	return defaultSecretName + "-" + profile
+}
+
+// WithProfile selects the credentials profile for setup and the jobs, so
+// users or teams sharing a namespace each use their own credentials.
+func (k *KubernetesBackend) WithProfile(profile string) *KubernetesBackend {
+	k.profile = profile
+	return k
+}
+
+func (k *KubernetesBackend) secretName() string {
+	return CredentialsSecretName(k.profile)
+}
+
+// Identity returns the user name the cluster authenticates the client as.
+func (k *KubernetesBackend) Identity(ctx context.Context) (string, error) {
+	if k.identity != "" {
+		return k.identity, nil
+	}
+	review, err := k.clientset.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the current user: %w", err)
+	}
+	k.identity = review.Status.UserInfo.Username
+	return k.identity, nil
+}
+
+// claimProfile makes the current user the owner of a profile's object, e.g.
+// its credentials, or checks that they own it already. Objects of the
+// default profile belong to everyone.
+func (k *KubernetesBackend) claimProfile(ctx context.Context, obj metav1.Object) error {
+	if k.profile == "" {
+		return nil
+	}
+	identity, err := k.Identity(ctx)
+	if err != nil {
+		return fmt.Errorf("profile %q needs an owner: %w", k.profile, err)
+	}
+	if err := k.checkOwner(ctx, obj); err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[submittedByAnnotation] = identity
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// checkOwner refuses to operate on objects another user submitted. Objects
+// without the annotation, e.g. from older versions, belong to everyone.
+func (k *KubernetesBackend) checkOwner(ctx context.Context, obj metav1.Object) error {
+	owner := obj.GetAnnotations()[submittedByAnnotation]
+	if owner == "" {
+		return nil
+	}
+	identity, err := k.Identity(ctx)
+	if err != nil {
+		return err
+	}
+	if owner != identity {
+		return fmt.Errorf("%s belongs to %s, not %s", obj.GetName(), owner, identity)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/change"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestValidateProfile(t *testing.T) {
+	for _, profile := range []string{"", "alice", "team-a", "a1"} {
+		if err := ValidateProfile(profile); err != nil {
+			t.Errorf("ValidateProfile(%q): unexpected error %v", profile, err)
+		}
+	}
+	for _, profile := range []string{"Alice", "-a", "a-", "a_b", strings.Repeat("a", 43)} {
+		if err := ValidateProfile(profile); err == nil {
+			t.Errorf("ValidateProfile(%q): expected error", profile)
+		}
+	}
+}
+
+func TestRenderChangeProfile(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil))).WithProfile("alice")
+	k.identity = "alice@example.com"
+	c := &change.Change{
+		Kind: "Change",
+		Spec: change.ChangeSpec{
+			Prompt: "Add tests",
+			Repos:  []string{"https://ghe.example.com/org/repo1"},
+			Agent:  "gemini-cli",
+		},
+	}
+
+	jobs, err := k.RenderChange(c, ApplyOptions{Forges: map[string]string{"ghe.example.com": "github"}})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	job := jobs[0]
+	if job.Labels[profileLabel] != "alice" || job.Labels[submittedByLabel] != "alice-example-com" || job.Annotations[submittedByAnnotation] != "alice@example.com" {
+		t.Errorf("unexpected labels %v, annotations %v", job.Labels, job.Annotations)
+	}
+
+	data, err := yaml.Marshal(job.Spec.Template.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "baca-credentials-alice"); n == 0 || strings.Count(string(data), "baca-credentials") != n {
+		t.Errorf("expected only the profile's secret to be referenced:\n%s", data)
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	k.identity = "alice"
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "baca-job"}}
+	if err := k.checkOwner(t.Context(), job); err != nil {
+		t.Errorf("expected unlabeled job to be accessible, got %v", err)
+	}
+	job.Annotations = map[string]string{submittedByAnnotation: "alice"}
+	if err := k.checkOwner(t.Context(), job); err != nil {
+		t.Errorf("expected own job to be accessible, got %v", err)
+	}
+	job.Annotations[submittedByAnnotation] = "bob"
+	if err := k.checkOwner(t.Context(), job); err == nil || !strings.Contains(err.Error(), "belongs to bob") {
+		t.Errorf("expected other user's job to be refused, got %v", err)
+	}
+}
+
+func TestClaimProfile(t *testing.T) {
+	k := NewOffline("baca-jobs", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	k.identity = "alice"
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "baca-credentials"}}
+	if err := k.claimProfile(t.Context(), secret); err != nil || secret.Annotations[submittedByAnnotation] != "" {
+		t.Errorf("expected the default profile to belong to everyone, got %v, %v", secret.Annotations, err)
+	}
+
+	k.WithProfile("alice")
+	secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "baca-credentials-alice"}}
+	if err := k.claimProfile(t.Context(), secret); err != nil || secret.Annotations[submittedByAnnotation] != "alice" {
+		t.Errorf("expected the profile to be claimed, got %v, %v", secret.Annotations, err)
+	}
+
+	k.identity = "bob"
+	if err := k.claimProfile(t.Context(), secret); err == nil || !strings.Contains(err.Error(), "belongs to alice") {
+		t.Errorf("expected other user's profile to be refused, got %v", err)
+	}
+}
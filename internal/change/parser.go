@@ -2,7 +2,11 @@ package change
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,6 +22,11 @@ func LoadFromFile(path string) (*Change, error) {
 		return nil, fmt.Errorf("failed to parse change file: %w", err)
 	}
 
+	if change.Metadata.Name == "" {
+		base := filepath.Base(path)
+		change.Metadata.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
 	if err := validate(&change); err != nil {
 		return nil, fmt.Errorf("invalid change definition: %w", err)
 	}
@@ -42,5 +51,54 @@ func validate(c *Change) error {
 		return fmt.Errorf("spec.agent is required")
 	}
 
+	mode, err := ParsePublishMode(string(c.Spec.Publish.Mode))
+	if err != nil {
+		return fmt.Errorf("spec.publish.mode: %w", err)
+	}
+	c.Spec.Publish.Mode = mode
+
+	g := c.Spec.Guardrails
+	if g.MaxFiles < 0 || g.MaxLinesAdded < 0 || g.MaxLinesRemoved < 0 {
+		return fmt.Errorf("spec.guardrails: limits must not be negative")
+	}
+	if g.OnViolation != "" && g.OnViolation != "fail" && g.OnViolation != "revert" {
+		return fmt.Errorf("spec.guardrails.onViolation: must be fail or revert, got %q", g.OnViolation)
+	}
+
+	if err := ValidateNetwork(c.Spec.Network); err != nil {
+		return err
+	}
+
+	return ValidateCommit(c.Spec.Commit)
+}
+
+// ValidateNetwork checks the egress allowlist, e.g. from the config file.
+func ValidateNetwork(n NetworkSpec) error {
+	for _, host := range n.AllowedHosts {
+		if host == "" || strings.ContainsAny(host, "/: ") {
+			return fmt.Errorf("spec.network.allowedHosts: %q is not a host name", host)
+		}
+	}
+	for _, cidr := range n.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("spec.network.allowedCIDRs: %w", err)
+		}
+	}
+	return nil
+}
+
+// coAuthorPattern matches the "Name <email>" form of git identities
+var coAuthorPattern = regexp.MustCompile(`^[^<>]+ <[^<>\s]+@[^<>\s]+>$`)
+
+// ValidateCommit checks the commit options, e.g. after merging defaults.
+func ValidateCommit(c CommitSpec) error {
+	for _, coAuthor := range c.CoAuthors {
+		if !coAuthorPattern.MatchString(coAuthor) {
+			return fmt.Errorf("spec.commit.coAuthors: %q is not in the form 'Name <email>'", coAuthor)
+		}
+	}
+	if c.Author.Email != "" && !strings.Contains(c.Author.Email, "@") {
+		return fmt.Errorf("spec.commit.author.email: %q is not an email address", c.Author.Email)
+	}
 	return nil
 }
@@ -1,17 +1,114 @@
 package change
 
+import (
+	"fmt"
+	"slices"
+)
+
 type Change struct {
 	Kind       string     `yaml:"kind"`
 	APIVersion string     `yaml:"apiVersion"`
+	Metadata   Metadata   `yaml:"metadata,omitempty"`
 	Spec       ChangeSpec `yaml:"spec"`
 }
 
+// Metadata identifies a change, e.g. in job labels and metrics.
+type Metadata struct {
+	// Name defaults to the change file's name without extension
+	Name string `yaml:"name,omitempty"`
+}
+
 type ChangeSpec struct {
-	AgentsMD  string   `yaml:"agentsmd"`
-	Resources []string `yaml:"resources"`
-	Prompt    string   `yaml:"prompt"`
-	Repos     []string `yaml:"repos"`
-	Agent     string   `yaml:"agent"`
-	Image     string   `yaml:"image,omitempty"`
-	Branch    string   `yaml:"branch,omitempty"` // Git branch to checkout (default: "main")
+	AgentsMD   string         `yaml:"agentsmd"`
+	Resources  []string       `yaml:"resources"`
+	Prompt     string         `yaml:"prompt"`
+	Repos      []string       `yaml:"repos"`
+	Agent      string         `yaml:"agent"`
+	Image      string         `yaml:"image,omitempty"`
+	Branch     string         `yaml:"branch,omitempty"` // Git branch to checkout (default: "main")
+	Publish    PublishSpec    `yaml:"publish,omitempty"`
+	Commit     CommitSpec     `yaml:"commit,omitempty"`
+	Guardrails GuardrailsSpec `yaml:"guardrails,omitempty"`
+	Network    NetworkSpec    `yaml:"network,omitempty"`
+}
+
+// PublishMode selects where the agent's branch is pushed to.
+type PublishMode string
+
+const (
+	// PublishFork pushes to a staging fork and opens a cross-fork pull request
+	PublishFork PublishMode = "fork"
+	// PublishBranch pushes to the repository itself and opens a same-repo
+	// pull request, so CI with secrets runs on it
+	PublishBranch PublishMode = "branch"
+)
+
+type PublishSpec struct {
+	Mode PublishMode `yaml:"mode,omitempty"` // fork (default) or branch
+}
+
+// CommitSpec controls authorship and signing of the agent's commit. Unset
+// fields are filled from the `commit` key of the config file by apply.
+type CommitSpec struct {
+	Author    AuthorSpec `yaml:"author,omitempty"`
+	CoAuthors []string   `yaml:"coAuthors,omitempty"` // "Name <email>", added as Co-authored-by trailers
+	SignOff   bool       `yaml:"signOff,omitempty"`   // Add a Signed-off-by trailer (DCO)
+	Sign      bool       `yaml:"sign,omitempty"`      // Sign with GIT_SIGNING_KEY from the credentials secret
+}
+
+type AuthorSpec struct {
+	Name  string `yaml:"name,omitempty"`
+	Email string `yaml:"email,omitempty"`
+}
+
+// Merge fills unset fields from defaults, co-authors are combined without
+// duplicates.
+func (c *CommitSpec) Merge(defaults CommitSpec) {
+	if c.Author.Name == "" {
+		c.Author.Name = defaults.Author.Name
+	}
+	if c.Author.Email == "" {
+		c.Author.Email = defaults.Author.Email
+	}
+	for _, coAuthor := range defaults.CoAuthors {
+		if !slices.Contains(c.CoAuthors, coAuthor) {
+			c.CoAuthors = append(c.CoAuthors, coAuthor)
+		}
+	}
+	c.SignOff = c.SignOff || defaults.SignOff
+	c.Sign = c.Sign || defaults.Sign
+}
+
+// NetworkSpec adds egress destinations for the job's pods, if network
+// policies are enabled. The forge, the agent's API and the config file's
+// `network` allowlist are always allowed.
+type NetworkSpec struct {
+	AllowedHosts []string `yaml:"allowedHosts,omitempty"` // e.g. proxy.golang.org, port 443
+	AllowedCIDRs []string `yaml:"allowedCIDRs,omitempty"` // e.g. 10.0.0.0/8, all ports
+}
+
+// GuardrailsSpec limits the changes an agent may make. It is checked after
+// the agent ran, before anything is committed. Zero values disable a limit.
+type GuardrailsSpec struct {
+	MaxFiles        int      `yaml:"maxFiles,omitempty"`
+	MaxLinesAdded   int      `yaml:"maxLinesAdded,omitempty"`
+	MaxLinesRemoved int      `yaml:"maxLinesRemoved,omitempty"`
+	AllowedPaths    []string `yaml:"allowedPaths,omitempty"`   // Globs, if set only matching files may change
+	ForbiddenPaths  []string `yaml:"forbiddenPaths,omitempty"` // Globs, e.g. ".github/workflows/"
+	ForbidBinary    bool     `yaml:"forbidBinary,omitempty"`
+	ForbidDeletions bool     `yaml:"forbidDeletions,omitempty"`
+	// OnViolation is "fail" (default) to fail the job, or "revert" to revert
+	// offending files. Limits on the whole diff always fail.
+	OnViolation string `yaml:"onViolation,omitempty"`
+}
+
+// ParsePublishMode validates a publish mode, empty means PublishFork.
+func ParsePublishMode(s string) (PublishMode, error) {
+	switch PublishMode(s) {
+	case "", PublishFork:
+		return PublishFork, nil
+	case PublishBranch:
+		return PublishBranch, nil
+	}
+	return "", fmt.Errorf("unsupported publish mode %q: must be fork or branch", s)
 }
@@ -0,0 +1,22 @@
+package change
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCommitSpecMerge(t *testing.T) {
+	c := CommitSpec{Author: AuthorSpec{Name: "Platform Bot"}, CoAuthors: []string{"Jane Doe <jane@example.com>"}}
+	c.Merge(CommitSpec{
+		Author:    AuthorSpec{Name: "Release Bot", Email: "release-bot@example.com"},
+		CoAuthors: []string{"Jane Doe <jane@example.com>", "John Roe <john@example.com>"},
+		SignOff:   true,
+	})
+
+	if c.Author.Name != "Platform Bot" || c.Author.Email != "release-bot@example.com" || !c.SignOff {
+		t.Errorf("unexpected merge result %+v", c)
+	}
+	if want := []string{"Jane Doe <jane@example.com>", "John Roe <john@example.com>"}; !slices.Equal(c.CoAuthors, want) {
+		t.Errorf("CoAuthors = %v, want %v", c.CoAuthors, want)
+	}
+}
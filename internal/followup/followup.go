@@ -0,0 +1,131 @@
+// Package followup builds the prompts and files of follow-up jobs, which run
+// the agent again on the head branch of a pull request created by BACA, to
+// fix its failing CI checks or address review comments.
+package followup
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+// CILogsFile holds the logs of the failing checks.
+const CILogsFile = "ci-logs.md"
+
+// MaxLogLines is the number of lines kept from the end of each check log,
+// where the errors usually are.
+const MaxLogLines = 300
+
+// MaxLogsSize is the total size of the check logs in CILogsFile, split
+// evenly across the checks. The file is stored in a ConfigMap, which is
+// limited to 1 MiB.
+const MaxLogsSize = 512 << 10
+
+// maxLineLength truncates long lines, e.g. minified output
+const maxLineLength = 500
+
+// CheckLog is a failing check and its log, Log is empty if the forge has
+// none.
+type CheckLog struct {
+	forge.Check
+	Log string
+}
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07`)
+
+// TailLog returns the last maxLines lines of a log, without terminal escape
+// codes and carriage returns.
+func TailLog(log string, maxLines int) string {
+	log = ansiPattern.ReplaceAllString(log, "")
+	log = strings.ReplaceAll(log, "\r\n", "\n")
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	var out []string
+	if len(lines) > maxLines {
+		out = append(out, fmt.Sprintf("[... %d lines omitted ...]", len(lines)-maxLines))
+		lines = lines[len(lines)-maxLines:]
+	}
+	for _, line := range lines {
+		// Progress output overwrites the line, only the last state counts
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		if len(line) > maxLineLength {
+			line = line[:maxLineLength] + " [...]"
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// tailSize returns the last lines of text which fit into size bytes.
+func tailSize(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	omitted := 0
+	for len(text) > size {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			omitted += len(text)
+			text = ""
+			break
+		}
+		omitted += i + 1
+		text = text[i+1:]
+	}
+	return fmt.Sprintf("[... %d bytes omitted ...]\n%s", omitted, text)
+}
+
+// CILogs renders the failing checks and the tails of their logs as
+// markdown, within MaxLogsSize.
+func CILogs(checks []CheckLog) string {
+	withLogs := 0
+	for _, check := range checks {
+		if check.Log != "" {
+			withLogs++
+		}
+	}
+	size := MaxLogsSize
+	if withLogs > 0 {
+		size /= withLogs
+	}
+
+	var b strings.Builder
+	b.WriteString("# Failing CI checks\n")
+	for _, check := range checks {
+		fmt.Fprintf(&b, "\n## %s\n\n", check.Name)
+		if check.URL != "" {
+			fmt.Fprintf(&b, "Details: %s\n\n", check.URL)
+		}
+		if check.Log == "" {
+			b.WriteString("No log available.\n")
+			continue
+		}
+		fmt.Fprintf(&b, "```\n%s\n```\n", tailSize(TailLog(check.Log, MaxLogLines), size))
+	}
+	return b.String()
+}
+
+// CIPrompt returns the prompt to fix the failing checks of a pull request
+// created for the original prompt, with the logs in dir.
+func CIPrompt(original, dir string, checks []CheckLog) string {
+	var names []string
+	for _, check := range checks {
+		names = append(names, check.Name)
+	}
+	return fmt.Sprintf(`This branch has the changes of a pull request whose CI checks fail: %s.
+
+Fix the cause of the failures on top of the existing changes. Keep the fix
+minimal and don't disable, skip or weaken tests or checks. If a failure is
+unrelated to the changes, e.g. a flaky test or an infrastructure problem,
+don't change anything.
+
+The end of each failing check's log is in %s.
+
+The pull request was created for this task:
+
+%s`, strings.Join(names, ", "), path.Join(dir, CILogsFile), original)
+}
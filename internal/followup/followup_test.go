@@ -0,0 +1,68 @@
+package followup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestTailLog(t *testing.T) {
+	log := "line 1\r\nline 2\n\x1b[31merror: boom\x1b[0m\nprogress 10%\rprogress 100%\n"
+	if got := TailLog(log, 10); got != "line 1\nline 2\nerror: boom\nprogress 100%" {
+		t.Errorf("TailLog() = %q", got)
+	}
+	if got := TailLog(log, 2); got != "[... 2 lines omitted ...]\nerror: boom\nprogress 100%" {
+		t.Errorf("TailLog() = %q", got)
+	}
+}
+
+func TestCILogs(t *testing.T) {
+	logs := CILogs([]CheckLog{
+		{Check: forge.Check{Name: "test", URL: "https://ci.example.com/1"}, Log: "--- FAIL: TestAdd\n"},
+		{Check: forge.Check{Name: "ci/legacy"}},
+	})
+	for _, want := range []string{"## test\n", "Details: https://ci.example.com/1", "```\n--- FAIL: TestAdd\n```", "## ci/legacy\n\nNo log available."} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("CILogs() is missing %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestCILogsSize(t *testing.T) {
+	line := strings.Repeat("x", maxLineLength) + "\n"
+	var checks []CheckLog
+	for _, name := range []string{"lint", "test", "build", "e2e", "race", "docs"} {
+		checks = append(checks, CheckLog{Check: forge.Check{Name: name}, Log: strings.Repeat(line, MaxLogLines-1) + "error: " + name + "\n"})
+	}
+	logs := CILogs(checks)
+	if len(logs) > MaxLogsSize+1024 {
+		t.Errorf("expected the logs within %d bytes, got %d", MaxLogsSize, len(logs))
+	}
+	for _, check := range checks {
+		if !strings.Contains(logs, "error: "+check.Name+"\n```") {
+			t.Errorf("expected the end of the %s log to be kept", check.Name)
+		}
+	}
+	if !strings.Contains(logs, "bytes omitted ...]") {
+		t.Error("expected the omitted bytes to be marked")
+	}
+}
+
+func TestTailSize(t *testing.T) {
+	if got := tailSize("a\nb\nc", 3); got != "[... 2 bytes omitted ...]\nb\nc" {
+		t.Errorf("tailSize() = %q", got)
+	}
+	if got := tailSize("abc", 3); got != "abc" {
+		t.Errorf("tailSize() = %q", got)
+	}
+}
+
+func TestCIPrompt(t *testing.T) {
+	prompt := CIPrompt("Add tests", "/workspace/follow-up", []CheckLog{{Check: forge.Check{Name: "test"}}, {Check: forge.Check{Name: "lint"}}})
+	for _, want := range []string{"fail: test, lint.", "/workspace/follow-up/ci-logs.md", "this task:\n\nAdd tests"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("CIPrompt() is missing %q:\n%s", want, prompt)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package followup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+// ReviewThreadsFile holds the review threads a follow-up addresses, as JSON
+// for `baca publish` to reply to them.
+const ReviewThreadsFile = "review-threads.json"
+
+// ReplyMarker ends the replies to review threads, threads whose last comment
+// is a reply with it were answered already.
+const ReplyMarker = "<!-- baca:follow-up -->"
+
+// Unanswered returns the threads with comments by trusted authors which
+// aren't answered by a reply of publisher, the user publishing the
+// follow-ups, yet. Only the trusted authors' comments are kept. Comments by
+// others are ignored, they would make the agent follow anyone's
+// instructions, and so is the marker in them, which would hide comments.
+func Unanswered(threads []forge.ReviewThread, publisher string, trusted func(author string) bool) []forge.ReviewThread {
+	var unanswered []forge.ReviewThread
+	for _, thread := range threads {
+		var comments []forge.ReviewComment
+		pending := false
+		for _, comment := range thread.Comments {
+			reply := comment.Author == publisher && strings.Contains(comment.Body, ReplyMarker)
+			if reply {
+				pending = false
+			}
+			if !trusted(comment.Author) {
+				continue
+			}
+			comments = append(comments, comment)
+			if !reply {
+				pending = true
+			}
+		}
+		if pending {
+			thread.Comments = comments
+			unanswered = append(unanswered, thread)
+		}
+	}
+	return unanswered
+}
+
+// ReviewPrompt returns the prompt to address the review threads of a pull
+// request created for the original prompt. The comments are part of the
+// prompt, so the PR metadata generated afterwards summarizes the changes
+// for them.
+func ReviewPrompt(original string, threads []forge.ReviewThread) string {
+	var b strings.Builder
+	b.WriteString(`This branch has the changes of a pull request. Reviewers left comments on
+it which aren't resolved yet.
+
+Address each comment on top of the existing changes. Keep the changes
+minimal and limited to what the comments ask for. If a comment is a
+question or you disagree with it, don't change anything for it.
+
+`)
+	for i, thread := range threads {
+		fmt.Fprintf(&b, "## Comment %d", i+1)
+		switch {
+		case thread.Path != "" && thread.Line > 0:
+			fmt.Fprintf(&b, " on %s line %d", thread.Path, thread.Line)
+		case thread.Path != "":
+			fmt.Fprintf(&b, " on %s", thread.Path)
+		}
+		b.WriteString("\n\n")
+		for _, comment := range thread.Comments {
+			fmt.Fprintf(&b, "%s wrote:\n\n%s\n\n", comment.Author, quote(comment.Body))
+		}
+	}
+	fmt.Fprintf(&b, "The pull request was created for this task:\n\n%s", original)
+	return b.String()
+}
+
+// quote formats text as markdown quote.
+func quote(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Reply returns the reply to a review thread followed up by commit, with the
+// summary from the PR metadata body without its prompt section. The same
+// reply goes to all threads of the follow-up and the agent may have left a
+// comment alone, so it doesn't claim the comment was addressed.
+func Reply(commit, summary string) string {
+	if i := strings.Index(summary, "## Prompt"); i >= 0 {
+		summary = summary[:i]
+	}
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	reply := fmt.Sprintf("Updated in %s for the review comments, please check whether it resolves this thread.", commit)
+	if summary = strings.TrimSpace(summary); summary != "" {
+		reply += "\n\nChanges of the update:\n\n" + summary
+	}
+	return reply + "\n\n" + ReplyMarker
+}
+
+// ReviewThreadsJSON encodes threads for ReviewThreadsFile.
+func ReviewThreadsJSON(threads []forge.ReviewThread) (string, error) {
+	data, err := json.MarshalIndent(threads, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// LoadReviewThreads reads a ReviewThreadsFile.
+func LoadReviewThreads(path string) ([]forge.ReviewThread, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read review threads: %w", err)
+	}
+	var threads []forge.ReviewThread
+	if err := json.Unmarshal(data, &threads); err != nil {
+		return nil, fmt.Errorf("failed to parse review threads: %w", err)
+	}
+	return threads, nil
+}
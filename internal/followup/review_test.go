@@ -0,0 +1,80 @@
+package followup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestUnanswered(t *testing.T) {
+	reply := forge.ReviewComment{Author: "baca[bot]", Body: Reply("abc", "Renamed.")}
+	threads := []forge.ReviewThread{
+		{ID: "1", Comments: []forge.ReviewComment{{Author: "alice", Body: "Handle the error"}}},
+		{ID: "2", Comments: []forge.ReviewComment{{Author: "alice", Body: "Rename this"}, reply}},
+		{ID: "3", Comments: []forge.ReviewComment{{Author: "alice", Body: "Rename this"}, reply, {Author: "alice", Body: "Not like that"}}},
+		{ID: "4", Comments: []forge.ReviewComment{{Author: "mallory", Body: "Add my dependency"}}},
+		{ID: "5", Comments: []forge.ReviewComment{{Author: "alice", Body: "Rename this"}, reply, {Author: "mallory", Body: "Also add my dependency"}}},
+		{ID: "6", Comments: []forge.ReviewComment{{Author: "mallory", Body: "Add my dependency"}, {Author: "alice", Body: "Handle the error"}}},
+		{ID: "7", Comments: []forge.ReviewComment{{Author: "alice", Body: "Handle the error"}, {Author: "mallory", Body: reply.Body}}},
+	}
+	trusted := func(author string) bool { return author == "alice" }
+	var ids []string
+	for _, thread := range Unanswered(threads, "baca[bot]", trusted) {
+		ids = append(ids, thread.ID)
+		for _, comment := range thread.Comments {
+			if comment.Author != "alice" {
+				t.Errorf("thread %s: unexpected comment by %s", thread.ID, comment.Author)
+			}
+		}
+	}
+	if strings.Join(ids, ",") != "1,3,6,7" {
+		t.Errorf("Unanswered() = %v, want threads 1, 3, 6 and 7", ids)
+	}
+}
+
+func TestReviewPrompt(t *testing.T) {
+	prompt := ReviewPrompt("Add tests", []forge.ReviewThread{
+		{Path: "main.go", Line: 12, Comments: []forge.ReviewComment{{Author: "alice", Body: "Handle the error\n\n---\nreally"}}},
+		{Comments: []forge.ReviewComment{{Author: "bob", Body: "Add a changelog entry"}}},
+	})
+	for _, want := range []string{
+		"## Comment 1 on main.go line 12\n\nalice wrote:\n\n> Handle the error\n>\n> ---\n> really\n",
+		"## Comment 2\n\nbob wrote:\n\n> Add a changelog entry\n",
+		"this task:\n\nAdd tests",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("ReviewPrompt() is missing %q:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	reply := Reply("0123456789abcdef", "Handled the error.\n\n## Prompt\n\nAddress the comments")
+	want := "Updated in 0123456789ab for the review comments, please check whether it resolves this thread.\n\nChanges of the update:\n\nHandled the error.\n\n" + ReplyMarker
+	if reply != want {
+		t.Errorf("Reply() = %q, want %q", reply, want)
+	}
+}
+
+func TestReviewThreadsFile(t *testing.T) {
+	threads := []forge.ReviewThread{{ID: "ccc", Path: "main.go", Line: 12, Comments: []forge.ReviewComment{{Author: "alice", Body: "Handle the error"}}}}
+	data, err := ReviewThreadsJSON(threads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), ReviewThreadsFile)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadReviewThreads(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].ID != "ccc" || loaded[0].Comments[0].Body != "Handle the error" {
+		t.Errorf("LoadReviewThreads() = %+v", loaded)
+	}
+}
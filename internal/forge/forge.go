@@ -0,0 +1,168 @@
+package forge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when a repository or pull request doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when a token is invalid, expired or lacks
+	// permissions.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotSupported is returned for operations a forge doesn't offer.
+	ErrNotSupported = errors.New("not supported by forge")
+)
+
+// Kind selects the Provider implementation for a host.
+type Kind string
+
+const (
+	GitHub Kind = "github"
+	GitLab Kind = "gitlab"
+	// Gitea also covers Forgejo, which serves the same API
+	Gitea Kind = "gitea"
+)
+
+// Provider implements the operations BACA needs on a forge to set up a fork,
+// push to it and open a pull (or merge) request against the original
+// repository.
+type Provider interface {
+	// CurrentUser returns the user name the provider authenticates as.
+	CurrentUser(ctx context.Context) (string, error)
+	// GetRepository returns the repository or an error wrapping ErrNotFound.
+	GetRepository(ctx context.Context, repo Repo) (*Repository, error)
+	// CreateFork forks upstream into owner, or into the authenticated
+	// user's account if owner is empty.
+	CreateFork(ctx context.Context, upstream Repo, owner string) error
+	// SyncFork updates branch of the fork from its upstream repository.
+	SyncFork(ctx context.Context, fork Repo, branch string) error
+	// CreatePullRequest opens a pull request against upstream.
+	CreatePullRequest(ctx context.Context, upstream Repo, pr NewPullRequest) (*PullRequest, error)
+	// EditPullRequest updates title and body of an existing pull request.
+	EditPullRequest(ctx context.Context, upstream Repo, number int, edit PullRequestEdit) error
+	// AddLabels adds existing labels of upstream to a pull request.
+	AddLabels(ctx context.Context, upstream Repo, number int, labels []string) error
+	// PullRequestStatus returns the state, CI checks and reviews of a pull
+	// request against upstream.
+	PullRequestStatus(ctx context.Context, upstream Repo, number int) (*PullRequestStatus, error)
+	// CheckLog returns the log of a check from PullRequestStatus, or an
+	// error wrapping ErrNotSupported if the forge has none for it.
+	CheckLog(ctx context.Context, upstream Repo, check Check) (string, error)
+	// ReviewThreads returns the unresolved review threads of a pull request
+	// against upstream, or an error wrapping ErrNotSupported.
+	ReviewThreads(ctx context.Context, upstream Repo, number int) ([]ReviewThread, error)
+	// ReplyToReviewThread adds a comment to a thread from ReviewThreads.
+	ReplyToReviewThread(ctx context.Context, upstream Repo, number int, thread ReviewThread, body string) error
+	// CanPush reports whether user has write access to repo, or returns an
+	// error wrapping ErrNotSupported.
+	CanPush(ctx context.Context, repo Repo, user string) (bool, error)
+	// GitCredentials returns the basic auth credentials for git over https.
+	GitCredentials() (username, password string)
+}
+
+// Repository is a repository as reported by the forge.
+type Repository struct {
+	Repo
+	Fork          bool
+	DefaultBranch string
+	// Importing is true while an asynchronously created fork is not ready yet.
+	Importing bool
+}
+
+// NewPullRequest describes a pull request from HeadBranch in Head.
+type NewPullRequest struct {
+	Title      string
+	Body       string
+	Head       Repo
+	HeadBranch string
+	Base       string
+}
+
+// PullRequestEdit holds the new title and body, empty fields are unchanged.
+type PullRequestEdit struct {
+	Title string
+	Body  string
+}
+
+type PullRequest struct {
+	Number int
+	URL    string
+}
+
+// tokenEnv maps forge kinds to the environment variable, and key in the
+// credentials secret, holding their token.
+var tokenEnv = map[Kind]string{
+	GitHub: "GITHUB_TOKEN",
+	GitLab: "GITLAB_TOKEN",
+	Gitea:  "GITEA_TOKEN",
+}
+
+// TokenEnv returns the environment variable holding the default token for
+// the forge kind, e.g. GITHUB_TOKEN.
+func TokenEnv(kind Kind) string {
+	return tokenEnv[kind]
+}
+
+// HostTokenEnv returns the environment variable holding the token for a
+// specific host, e.g. GITHUB_TOKEN_GHE_EXAMPLE_COM for a GitHub Enterprise
+// Server at ghe.example.com.
+func HostTokenEnv(kind Kind, host string) string {
+	key := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, host)
+	return tokenEnv[kind] + "_" + key
+}
+
+// defaultHosts are the public hosts the default token of a forge kind,
+// e.g. GITHUB_TOKEN, is meant for.
+var defaultHosts = map[Kind]string{
+	GitHub: "github.com",
+	GitLab: "gitlab.com",
+	Gitea:  "codeberg.org",
+}
+
+// DefaultHost returns the public host of the forge kind, the only host its
+// default token is used for. Other hosts need a host specific token.
+func DefaultHost(kind Kind) string {
+	return defaultHosts[kind]
+}
+
+// defaultKinds maps the well-known public forges.
+var defaultKinds = map[string]Kind{
+	"github.com":   GitHub,
+	"gitlab.com":   GitLab,
+	"codeberg.org": Gitea,
+}
+
+// KindForHost returns the forge kind for host. The hosts mapping, e.g. from
+// the `forges` config key, takes precedence over the well-known defaults.
+func KindForHost(host string, hosts map[string]string) (Kind, error) {
+	if kind, ok := hosts[host]; ok {
+		return ParseKind(kind)
+	}
+	if kind, ok := defaultKinds[host]; ok {
+		return kind, nil
+	}
+	return "", fmt.Errorf("unknown forge for host %q: add it to the forges config, e.g. 'forges: {%s: gitlab}'", host, host)
+}
+
+// ParseKind validates a forge kind name, "forgejo" is an alias for gitea.
+func ParseKind(s string) (Kind, error) {
+	switch Kind(s) {
+	case GitHub, GitLab, Gitea:
+		return Kind(s), nil
+	case "forgejo":
+		return Gitea, nil
+	}
+	return "", fmt.Errorf("unsupported forge %q", s)
+}
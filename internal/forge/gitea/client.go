@@ -0,0 +1,314 @@
+// Package gitea implements forge.Provider for the Gitea REST API (v1), which
+// Forgejo serves as well.
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+// APIError is returned for unsuccessful API responses.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gitea api: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return forge.ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return forge.ErrUnauthorized
+	}
+	return nil
+}
+
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+var _ forge.Provider = &Client{}
+
+// NewClient returns a client for the API at baseURL, e.g.
+// https://gitea.example.com/api/v1, authenticating with token.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// BaseURL returns the API URL for a Gitea host.
+func BaseURL(host string) string {
+	return "https://" + host + "/api/v1"
+}
+
+type user struct {
+	Login string `json:"login"`
+}
+
+type repository struct {
+	Name          string `json:"name"`
+	Fork          bool   `json:"fork"`
+	DefaultBranch string `json:"default_branch"`
+	Owner         user   `json:"owner"`
+}
+
+type pullRequest struct {
+	Number    int    `json:"number"`
+	HTMLURL   string `json:"html_url"`
+	State     string `json:"state"`
+	Merged    bool   `json:"merged"`
+	Mergeable bool   `json:"mergeable"`
+	Head      struct {
+		Ref  string      `json:"ref"`
+		SHA  string      `json:"sha"`
+		Repo *repository `json:"repo"`
+	} `json:"head"`
+}
+
+func (c *Client) CurrentUser(ctx context.Context) (string, error) {
+	var u user
+	if err := c.do(ctx, http.MethodGet, "/user", nil, &u); err != nil {
+		return "", err
+	}
+	return u.Login, nil
+}
+
+func (c *Client) GetRepository(ctx context.Context, repo forge.Repo) (*forge.Repository, error) {
+	var r repository
+	if err := c.do(ctx, http.MethodGet, repoPath(repo), nil, &r); err != nil {
+		return nil, err
+	}
+	return &forge.Repository{
+		Repo:          forge.Repo{Host: repo.Host, Owner: r.Owner.Login, Name: r.Name},
+		Fork:          r.Fork,
+		DefaultBranch: r.DefaultBranch,
+	}, nil
+}
+
+// CreateFork forks upstream into the organization owner, or into the
+// authenticated user's account if owner is empty.
+func (c *Client) CreateFork(ctx context.Context, upstream forge.Repo, owner string) error {
+	body := map[string]string{}
+	if owner != "" {
+		body["organization"] = owner
+	}
+	return c.do(ctx, http.MethodPost, repoPath(upstream)+"/forks", body, nil)
+}
+
+// SyncFork uses the merge-upstream endpoint, which older Gitea releases
+// don't have.
+func (c *Client) SyncFork(ctx context.Context, fork forge.Repo, branch string) error {
+	body := map[string]string{"branch": branch}
+	err := c.do(ctx, http.MethodPost, repoPath(fork)+"/merge-upstream", body, nil)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+		return forge.ErrNotSupported
+	}
+	return err
+}
+
+func (c *Client) CreatePullRequest(ctx context.Context, upstream forge.Repo, pr forge.NewPullRequest) (*forge.PullRequest, error) {
+	head := pr.HeadBranch
+	if pr.Head.Owner != upstream.Owner {
+		head = pr.Head.Owner + ":" + pr.HeadBranch
+	}
+	body := map[string]string{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"head":  head,
+		"base":  pr.Base,
+	}
+
+	var created pullRequest
+	if err := c.do(ctx, http.MethodPost, repoPath(upstream)+"/pulls", body, &created); err != nil {
+		return nil, err
+	}
+	return &forge.PullRequest{Number: created.Number, URL: created.HTMLURL}, nil
+}
+
+func (c *Client) EditPullRequest(ctx context.Context, upstream forge.Repo, number int, edit forge.PullRequestEdit) error {
+	body := map[string]string{}
+	if edit.Title != "" {
+		body["title"] = edit.Title
+	}
+	if edit.Body != "" {
+		body["body"] = edit.Body
+	}
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("%s/pulls/%d", repoPath(upstream), number), body, nil)
+}
+
+// AddLabels passes label names, which Gitea accepts since 1.19 in place of
+// label IDs.
+func (c *Client) AddLabels(ctx context.Context, upstream forge.Repo, number int, labels []string) error {
+	body := map[string][]string{"labels": labels}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("%s/issues/%d/labels", repoPath(upstream), number), body, nil)
+}
+
+// PullRequestStatus reads the commit statuses of the head commit as checks,
+// Gitea and Forgejo Actions report their jobs as commit statuses.
+func (c *Client) PullRequestStatus(ctx context.Context, upstream forge.Repo, number int) (*forge.PullRequestStatus, error) {
+	var pr pullRequest
+	path := fmt.Sprintf("%s/pulls/%d", repoPath(upstream), number)
+	if err := c.do(ctx, http.MethodGet, path, nil, &pr); err != nil {
+		return nil, err
+	}
+	status := &forge.PullRequestStatus{
+		PullRequest: forge.PullRequest{Number: pr.Number, URL: pr.HTMLURL},
+		State:       forge.StateOpen,
+		Mergeable:   &pr.Mergeable,
+		HeadBranch:  pr.Head.Ref,
+		HeadSHA:     pr.Head.SHA,
+	}
+	switch {
+	case pr.Merged:
+		status.State = forge.StateMerged
+	case pr.State == "closed":
+		status.State = forge.StateClosed
+	}
+	if pr.Head.Repo != nil {
+		status.Head = forge.Repo{Host: upstream.Host, Owner: pr.Head.Repo.Owner.Login, Name: pr.Head.Repo.Name}
+	}
+
+	var combined struct {
+		Statuses []struct {
+			Context   string `json:"context"`
+			Status    string `json:"status"`
+			TargetURL string `json:"target_url"`
+		} `json:"statuses"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/commits/%s/status", repoPath(upstream), pr.Head.SHA), nil, &combined); err != nil {
+		return nil, err
+	}
+	for _, s := range combined.Statuses {
+		check := forge.Check{Name: s.Context, State: forge.CheckPending, URL: s.TargetURL}
+		switch s.Status {
+		case "success", "warning":
+			check.State = forge.CheckSuccess
+		case "failure", "error":
+			check.State = forge.CheckFailure
+		}
+		status.Checks = append(status.Checks, check)
+	}
+
+	var reviews []struct {
+		User      user   `json:"user"`
+		State     string `json:"state"`
+		Dismissed bool   `json:"dismissed"`
+	}
+	if err := c.do(ctx, http.MethodGet, path+"/reviews", nil, &reviews); err != nil {
+		return nil, err
+	}
+	latest := map[string]string{}
+	for _, review := range reviews {
+		if review.Dismissed {
+			continue
+		}
+		switch review.State {
+		case "APPROVED":
+			latest[review.User.Login] = forge.ReviewApproved
+		case "REQUEST_CHANGES":
+			latest[review.User.Login] = forge.ReviewChangesRequested
+		}
+	}
+	status.Review = forge.ReviewState(latest)
+	return status, nil
+}
+
+// CheckLog is not supported, commit statuses don't identify the Actions job
+// they report.
+func (c *Client) CheckLog(context.Context, forge.Repo, forge.Check) (string, error) {
+	return "", forge.ErrNotSupported
+}
+
+// ReviewThreads is not supported, Gitea doesn't offer replies to review
+// comments.
+func (c *Client) ReviewThreads(context.Context, forge.Repo, int) ([]forge.ReviewThread, error) {
+	return nil, forge.ErrNotSupported
+}
+
+// ReplyToReviewThread is not supported, see ReviewThreads.
+func (c *Client) ReplyToReviewThread(context.Context, forge.Repo, int, forge.ReviewThread, string) error {
+	return forge.ErrNotSupported
+}
+
+// CanPush is not supported, it is only needed for review threads.
+func (c *Client) CanPush(context.Context, forge.Repo, string) (bool, error) {
+	return false, forge.ErrNotSupported
+}
+
+// GitCredentials returns the token as password, Gitea ignores the user name
+// for token authentication.
+func (c *Client) GitCredentials() (string, string) {
+	return "oauth2", c.token
+}
+
+func repoPath(repo forge.Repo) string {
+	return fmt.Sprintf("/repos/%s/%s", repo.Owner, repo.Name)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "token "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%s %s: %w", method, path, &APIError{StatusCode: resp.StatusCode, Message: apiErr.Message})
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+	}
+	return nil
+}
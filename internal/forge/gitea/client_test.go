@@ -0,0 +1,130 @@
+package gitea
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestClient(t *testing.T) {
+	var gotFork, gotPR map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"token is required"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"login":"alice"}`))
+	})
+	mux.HandleFunc("GET /repos/{owner}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("owner") + "/" + r.PathValue("name") {
+		case "org/project":
+			_, _ = w.Write([]byte(`{"name":"project","fork":false,"default_branch":"main","owner":{"login":"org"}}`))
+		case "alice/project":
+			_, _ = w.Write([]byte(`{"name":"project","fork":true,"default_branch":"main","owner":{"login":"alice"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"The target couldn't be found."}`))
+		}
+	})
+	mux.HandleFunc("POST /repos/org/project/forks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotFork)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("POST /repos/org/project/pulls", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotPR)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number":3,"html_url":"https://codeberg.org/org/project/pulls/3"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := t.Context()
+	c := NewClient(server.URL, "test-token")
+	upstream := forge.Repo{Host: "codeberg.org", Owner: "org", Name: "project"}
+	fork := forge.Repo{Host: "codeberg.org", Owner: "alice", Name: "project"}
+
+	login, err := c.CurrentUser(ctx)
+	if err != nil || login != "alice" {
+		t.Fatalf("CurrentUser() = %q, %v", login, err)
+	}
+
+	repo, err := c.GetRepository(ctx, fork)
+	if err != nil || !repo.Fork || repo.Repo != fork {
+		t.Fatalf("GetRepository(fork) = %+v, %v", repo, err)
+	}
+	_, err = c.GetRepository(ctx, forge.Repo{Host: "codeberg.org", Owner: "alice", Name: "missing"})
+	if !errors.Is(err, forge.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := c.CreateFork(ctx, upstream, "team"); err != nil {
+		t.Fatal(err)
+	}
+	if gotFork["organization"] != "team" {
+		t.Errorf("unexpected fork request %v", gotFork)
+	}
+
+	// The test server has no merge-upstream endpoint, like older Gitea releases
+	if err := c.SyncFork(ctx, fork, "main"); !errors.Is(err, forge.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+
+	pr, err := c.CreatePullRequest(ctx, upstream, forge.NewPullRequest{
+		Title:      "Title",
+		Body:       "Body",
+		Head:       fork,
+		HeadBranch: "baca-1",
+		Base:       "main",
+	})
+	if err != nil || pr.Number != 3 {
+		t.Fatalf("CreatePullRequest() = %+v, %v", pr, err)
+	}
+	if gotPR["head"] != "alice:baca-1" || gotPR["base"] != "main" {
+		t.Errorf("unexpected pull request %v", gotPR)
+	}
+
+	user, password := c.GitCredentials()
+	if user != "oauth2" || password != "test-token" {
+		t.Errorf("unexpected git credentials %s", user)
+	}
+}
+
+func TestPullRequestStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/org/repo/pulls/5", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"number":5,"html_url":"https://codeberg.org/org/repo/pulls/5","state":"closed","merged":false,"mergeable":false,
+			"head":{"ref":"baca-1","sha":"abc123","repo":{"name":"repo","owner":{"login":"alice"}}}}`))
+	})
+	mux.HandleFunc("GET /repos/org/repo/commits/abc123/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"state":"failure","statuses":[{"context":"ci / test","status":"failure","target_url":"https://codeberg.org/org/repo/actions/runs/1"}]}`))
+	})
+	mux.HandleFunc("GET /repos/org/repo/pulls/5/reviews", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"user":{"login":"bob"},"state":"REQUEST_CHANGES"},{"user":{"login":"carol"},"state":"REQUEST_CHANGES","dismissed":true}]`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	status, err := NewClient(server.URL, "test-token").PullRequestStatus(t.Context(), forge.Repo{Host: "codeberg.org", Owner: "org", Name: "repo"}, 5)
+	if err != nil {
+		t.Fatalf("PullRequestStatus() error = %v", err)
+	}
+	if status.State != forge.StateClosed || status.Mergeable == nil || *status.Mergeable {
+		t.Errorf("unexpected state: %+v", status)
+	}
+	if status.Head.FullName() != "alice/repo" || status.HeadSHA != "abc123" {
+		t.Errorf("unexpected head: %+v", status)
+	}
+	if status.CheckState() != forge.CheckFailure || len(status.FailedChecks()) != 1 {
+		t.Errorf("unexpected checks: %+v", status.Checks)
+	}
+	if status.Review != forge.ReviewChangesRequested {
+		t.Errorf("Review = %q, want changes-requested", status.Review)
+	}
+}
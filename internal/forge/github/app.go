@@ -0,0 +1,228 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+// App authenticates as a GitHub App to mint short-lived installation tokens.
+type App struct {
+	baseURL string
+	id      string
+	key     *rsa.PrivateKey
+	now     func() time.Time
+}
+
+// NewApp returns an App for the API at baseURL. The private key is the PEM
+// file downloaded from the app's settings page.
+func NewApp(baseURL, appID string, privateKey []byte) (*App, error) {
+	if appID == "" {
+		return nil, errors.New("github app id is empty")
+	}
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &App{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		id:      appID,
+		key:     key,
+		now:     time.Now,
+	}, nil
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("github app private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse github app private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("github app private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// jwt returns a token authenticating as the app itself, valid for 9 minutes.
+// It is backdated a minute to allow for clock drift.
+func (a *App) jwt() (string, error) {
+	now := a.now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign github app jwt: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// RepoToken returns an installation token that can only access repo.
+func (a *App) RepoToken(ctx context.Context, repo forge.Repo) (string, error) {
+	return a.installationToken(ctx, repoPath(repo)+"/installation", []string{repo.Name})
+}
+
+// OwnerToken returns an installation token for all repositories the app can
+// access in the organization or user account owner.
+func (a *App) OwnerToken(ctx context.Context, owner string) (string, error) {
+	token, err := a.installationToken(ctx, "/orgs/"+owner+"/installation", nil)
+	if errors.Is(err, forge.ErrNotFound) {
+		return a.installationToken(ctx, "/users/"+owner+"/installation", nil)
+	}
+	return token, err
+}
+
+func (a *App) installationToken(ctx context.Context, installationPath string, repositories []string) (string, error) {
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", err
+	}
+	c := NewClient(a.baseURL, jwt)
+
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodGet, installationPath, nil, &installation); err != nil {
+		return "", fmt.Errorf("failed to find github app installation: %w", err)
+	}
+
+	body := map[string]any{}
+	if len(repositories) > 0 {
+		body["repositories"] = repositories
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/app/installations/%d/access_tokens", installation.ID), body, &token); err != nil {
+		return "", fmt.Errorf("failed to create installation token: %w", err)
+	}
+	return token.Token, nil
+}
+
+// AppProvider authenticates as installations of a GitHub App. Fork setup,
+// clone and push use a token of the fork owner's installation, pull requests
+// a token of the upstream installation that is scoped to the upstream
+// repository.
+type AppProvider struct {
+	owner    string
+	fork     *Client
+	upstream *Client
+}
+
+var _ forge.Provider = &AppProvider{}
+
+// NewAppProvider mints the installation tokens for a job that forks upstream
+// into forkOwner. If forkOwner owns upstream a single token scoped to
+// upstream is used.
+func NewAppProvider(ctx context.Context, app *App, upstream forge.Repo, forkOwner string) (*AppProvider, error) {
+	if forkOwner == "" {
+		return nil, errors.New("github app authentication requires a fork organization, use --fork-org")
+	}
+
+	upstreamToken, err := app.RepoToken(ctx, upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token for %s: %w", upstream.FullName(), err)
+	}
+	forkToken := upstreamToken
+	if forkOwner != upstream.Owner {
+		forkToken, err = app.OwnerToken(ctx, forkOwner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token for %s: %w", forkOwner, err)
+		}
+	}
+
+	return &AppProvider{
+		owner:    forkOwner,
+		fork:     NewClient(app.baseURL, forkToken),
+		upstream: NewClient(app.baseURL, upstreamToken),
+	}, nil
+}
+
+// CurrentUser returns the fork owner, installation tokens don't belong to a
+// user.
+func (p *AppProvider) CurrentUser(context.Context) (string, error) {
+	return p.owner, nil
+}
+
+func (p *AppProvider) GetRepository(ctx context.Context, repo forge.Repo) (*forge.Repository, error) {
+	return p.fork.GetRepository(ctx, repo)
+}
+
+// CreateFork forks into the fork owner, there is no user account to fall
+// back to.
+func (p *AppProvider) CreateFork(ctx context.Context, upstream forge.Repo, owner string) error {
+	if owner == "" {
+		owner = p.owner
+	}
+	return p.fork.CreateFork(ctx, upstream, owner)
+}
+
+func (p *AppProvider) SyncFork(ctx context.Context, fork forge.Repo, branch string) error {
+	return p.fork.SyncFork(ctx, fork, branch)
+}
+
+func (p *AppProvider) CreatePullRequest(ctx context.Context, upstream forge.Repo, pr forge.NewPullRequest) (*forge.PullRequest, error) {
+	return p.upstream.CreatePullRequest(ctx, upstream, pr)
+}
+
+func (p *AppProvider) EditPullRequest(ctx context.Context, upstream forge.Repo, number int, edit forge.PullRequestEdit) error {
+	return p.upstream.EditPullRequest(ctx, upstream, number, edit)
+}
+
+func (p *AppProvider) AddLabels(ctx context.Context, upstream forge.Repo, number int, labels []string) error {
+	return p.upstream.AddLabels(ctx, upstream, number, labels)
+}
+
+func (p *AppProvider) PullRequestStatus(ctx context.Context, upstream forge.Repo, number int) (*forge.PullRequestStatus, error) {
+	return p.upstream.PullRequestStatus(ctx, upstream, number)
+}
+
+func (p *AppProvider) CheckLog(ctx context.Context, upstream forge.Repo, check forge.Check) (string, error) {
+	return p.upstream.CheckLog(ctx, upstream, check)
+}
+
+func (p *AppProvider) ReviewThreads(ctx context.Context, upstream forge.Repo, number int) ([]forge.ReviewThread, error) {
+	return p.upstream.ReviewThreads(ctx, upstream, number)
+}
+
+func (p *AppProvider) CanPush(ctx context.Context, repo forge.Repo, user string) (bool, error) {
+	return p.upstream.CanPush(ctx, repo, user)
+}
+
+func (p *AppProvider) ReplyToReviewThread(ctx context.Context, upstream forge.Repo, number int, thread forge.ReviewThread, body string) error {
+	return p.upstream.ReplyToReviewThread(ctx, upstream, number, thread, body)
+}
+
+func (p *AppProvider) GitCredentials() (string, string) {
+	return p.fork.GitCredentials()
+}
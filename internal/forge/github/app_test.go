@@ -0,0 +1,137 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestAppProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	// verifyJWT checks the app JWT signature and issuer
+	verifyJWT := func(r *http.Request) bool {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return false
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], sig) != nil {
+			return false
+		}
+		var claims struct {
+			Iss string `json:"iss"`
+		}
+		data, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		return json.Unmarshal(data, &claims) == nil && claims.Iss == "42"
+	}
+
+	scopes := map[string][]string{}
+	var prAuth, forkAuth string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/org/project/installation", func(w http.ResponseWriter, r *http.Request) {
+		if !verifyJWT(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":1}`))
+	})
+	mux.HandleFunc("GET /orgs/forks/installation", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+	})
+	mux.HandleFunc("GET /users/forks/installation", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":2}`))
+	})
+	mux.HandleFunc("POST /app/installations/{id}/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		if !verifyJWT(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Repositories []string `json:"repositories"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		scopes[r.PathValue("id")] = body.Repositories
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token":"token-%s"}`, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /repos/org/project/forks", func(w http.ResponseWriter, r *http.Request) {
+		forkAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("POST /repos/org/project/pulls", func(w http.ResponseWriter, r *http.Request) {
+		prAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number":7,"html_url":"https://github.com/org/project/pull/7"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	app, err := NewApp(server.URL, "42", keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := t.Context()
+	upstream := forge.Repo{Host: "github.com", Owner: "org", Name: "project"}
+
+	if _, err := NewAppProvider(ctx, app, upstream, ""); err == nil {
+		t.Fatal("expected error without fork owner")
+	}
+
+	p, err := NewAppProvider(ctx, app, upstream, "forks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes["1"]) != 1 || scopes["1"][0] != "project" {
+		t.Errorf("expected upstream token scoped to project, got %v", scopes["1"])
+	}
+	if scopes["2"] != nil {
+		t.Errorf("expected unscoped fork owner token, got %v", scopes["2"])
+	}
+
+	if owner, err := p.CurrentUser(ctx); err != nil || owner != "forks" {
+		t.Errorf("CurrentUser() = %q, %v", owner, err)
+	}
+	if err := p.CreateFork(ctx, upstream, ""); err != nil {
+		t.Fatal(err)
+	}
+	if forkAuth != "Bearer token-2" {
+		t.Errorf("expected fork owner token for fork, got %q", forkAuth)
+	}
+	if _, err := p.CreatePullRequest(ctx, upstream, forge.NewPullRequest{Head: forge.Repo{Host: "github.com", Owner: "forks", Name: "project"}, HeadBranch: "baca-1", Base: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if prAuth != "Bearer token-1" {
+		t.Errorf("expected upstream token for pull request, got %q", prAuth)
+	}
+	if _, password := p.GitCredentials(); password != "token-2" {
+		t.Errorf("expected fork owner token for git, got %q", password)
+	}
+}
+
+func TestNewAppInvalidKey(t *testing.T) {
+	if _, err := NewApp(DefaultBaseURL, "42", []byte("not a key")); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
@@ -0,0 +1,532 @@
+// Package github implements forge.Provider for the GitHub REST API.
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+// DefaultBaseURL is the API endpoint for github.com.
+const DefaultBaseURL = "https://api.github.com"
+
+// APIError is returned for unsuccessful API responses.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("github api: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return forge.ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return forge.ErrUnauthorized
+	}
+	return nil
+}
+
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+var _ forge.Provider = &Client{}
+
+// BaseURL returns the API URL for a GitHub host, GitHub Enterprise Server
+// serves the API below /api/v3.
+func BaseURL(host string) string {
+	if host == "github.com" {
+		return DefaultBaseURL
+	}
+	return "https://" + host + "/api/v3"
+}
+
+// NewClient returns a client for the API at baseURL, authenticating with token.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type user struct {
+	Login string `json:"login"`
+}
+
+type repository struct {
+	Name          string `json:"name"`
+	Fork          bool   `json:"fork"`
+	DefaultBranch string `json:"default_branch"`
+	Owner         user   `json:"owner"`
+}
+
+type pullRequest struct {
+	Number    int    `json:"number"`
+	HTMLURL   string `json:"html_url"`
+	State     string `json:"state"`
+	Merged    bool   `json:"merged"`
+	Mergeable *bool  `json:"mergeable"`
+	Head      struct {
+		Ref  string      `json:"ref"`
+		SHA  string      `json:"sha"`
+		Repo *repository `json:"repo"`
+	} `json:"head"`
+}
+
+func (c *Client) CurrentUser(ctx context.Context) (string, error) {
+	var u user
+	if err := c.do(ctx, http.MethodGet, "/user", nil, &u); err != nil {
+		return "", err
+	}
+	return u.Login, nil
+}
+
+// TokenInfo describes the token a client authenticates with.
+type TokenInfo struct {
+	Login string
+	// Scopes of a classic token, fine-grained tokens have permissions
+	// instead, which the API doesn't report
+	Scopes      []string
+	FineGrained bool
+	// Expires is zero for tokens without expiration
+	Expires time.Time
+}
+
+// TokenInfo returns the user and scopes of the client's token.
+func (c *Client) TokenInfo(ctx context.Context) (*TokenInfo, error) {
+	var u user
+	header, err := c.doHeader(ctx, http.MethodGet, "/user", nil, &u)
+	if err != nil {
+		return nil, err
+	}
+	info := &TokenInfo{
+		Login:       u.Login,
+		FineGrained: strings.HasPrefix(c.token, "github_pat_"),
+	}
+	for _, scope := range strings.Split(header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			info.Scopes = append(info.Scopes, scope)
+		}
+	}
+	if expires := header.Get("GitHub-Authentication-Token-Expiration"); expires != "" {
+		// e.g. "2026-11-01 00:00:00 UTC"
+		if t, err := time.Parse("2006-01-02 15:04:05 MST", expires); err == nil {
+			info.Expires = t
+		}
+	}
+	return info, nil
+}
+
+// Membership is the authenticated user's membership in an organization.
+type Membership struct {
+	State string `json:"state"` // active or pending
+	Role  string `json:"role"`  // admin or member
+	// MembersCanCreateRepositories is only reported to organization owners,
+	// it is nil otherwise
+	MembersCanCreateRepositories *bool `json:"-"`
+}
+
+// OrgMembership returns the authenticated user's membership in org, it wraps
+// forge.ErrNotFound if the user isn't a member.
+func (c *Client) OrgMembership(ctx context.Context, org string) (*Membership, error) {
+	var m Membership
+	if err := c.do(ctx, http.MethodGet, "/user/memberships/orgs/"+org, nil, &m); err != nil {
+		return nil, err
+	}
+	var o struct {
+		MembersCanCreateRepositories *bool `json:"members_can_create_repositories"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/orgs/"+org, nil, &o); err != nil {
+		return nil, err
+	}
+	m.MembersCanCreateRepositories = o.MembersCanCreateRepositories
+	return &m, nil
+}
+
+func (c *Client) GetRepository(ctx context.Context, repo forge.Repo) (*forge.Repository, error) {
+	var r repository
+	if err := c.do(ctx, http.MethodGet, repoPath(repo), nil, &r); err != nil {
+		return nil, err
+	}
+	return &forge.Repository{
+		Repo:          forge.Repo{Host: repo.Host, Owner: r.Owner.Login, Name: r.Name},
+		Fork:          r.Fork,
+		DefaultBranch: r.DefaultBranch,
+	}, nil
+}
+
+// CreateFork forks upstream. Forking happens asynchronously on GitHub's side.
+func (c *Client) CreateFork(ctx context.Context, upstream forge.Repo, owner string) error {
+	body := map[string]any{"default_branch_only": false}
+	if owner != "" {
+		body["organization"] = owner
+	}
+	return c.do(ctx, http.MethodPost, repoPath(upstream)+"/forks", body, nil)
+}
+
+func (c *Client) SyncFork(ctx context.Context, fork forge.Repo, branch string) error {
+	body := map[string]string{"branch": branch}
+	return c.do(ctx, http.MethodPost, repoPath(fork)+"/merge-upstream", body, nil)
+}
+
+func (c *Client) CreatePullRequest(ctx context.Context, upstream forge.Repo, pr forge.NewPullRequest) (*forge.PullRequest, error) {
+	head := pr.HeadBranch
+	if pr.Head.Owner != upstream.Owner {
+		head = pr.Head.Owner + ":" + pr.HeadBranch
+	}
+	body := map[string]string{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"head":  head,
+		"base":  pr.Base,
+	}
+
+	var created pullRequest
+	if err := c.do(ctx, http.MethodPost, repoPath(upstream)+"/pulls", body, &created); err != nil {
+		return nil, err
+	}
+	return &forge.PullRequest{Number: created.Number, URL: created.HTMLURL}, nil
+}
+
+func (c *Client) EditPullRequest(ctx context.Context, upstream forge.Repo, number int, edit forge.PullRequestEdit) error {
+	body := map[string]string{}
+	if edit.Title != "" {
+		body["title"] = edit.Title
+	}
+	if edit.Body != "" {
+		body["body"] = edit.Body
+	}
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("%s/pulls/%d", repoPath(upstream), number), body, nil)
+}
+
+// AddLabels uses the issues API, pull requests are issues on GitHub.
+func (c *Client) AddLabels(ctx context.Context, upstream forge.Repo, number int, labels []string) error {
+	body := map[string][]string{"labels": labels}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("%s/issues/%d/labels", repoPath(upstream), number), body, nil)
+}
+
+// PullRequestStatus combines the check runs and commit statuses of the head
+// commit, and the latest review of each reviewer.
+func (c *Client) PullRequestStatus(ctx context.Context, upstream forge.Repo, number int) (*forge.PullRequestStatus, error) {
+	var pr pullRequest
+	path := fmt.Sprintf("%s/pulls/%d", repoPath(upstream), number)
+	if err := c.do(ctx, http.MethodGet, path, nil, &pr); err != nil {
+		return nil, err
+	}
+	status := &forge.PullRequestStatus{
+		PullRequest: forge.PullRequest{Number: pr.Number, URL: pr.HTMLURL},
+		State:       forge.StateOpen,
+		Mergeable:   pr.Mergeable,
+		HeadBranch:  pr.Head.Ref,
+		HeadSHA:     pr.Head.SHA,
+	}
+	switch {
+	case pr.Merged:
+		status.State = forge.StateMerged
+	case pr.State == "closed":
+		status.State = forge.StateClosed
+	}
+	// The head repository is null if the fork was deleted
+	if pr.Head.Repo != nil {
+		status.Head = forge.Repo{Host: upstream.Host, Owner: pr.Head.Repo.Owner.Login, Name: pr.Head.Repo.Name}
+	}
+
+	var runs struct {
+		CheckRuns []struct {
+			ID         int64  `json:"id"`
+			Name       string `json:"name"`
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+			HTMLURL    string `json:"html_url"`
+		} `json:"check_runs"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/commits/%s/check-runs?per_page=100", repoPath(upstream), pr.Head.SHA), nil, &runs); err != nil {
+		return nil, err
+	}
+	for _, run := range runs.CheckRuns {
+		check := forge.Check{ID: strconv.FormatInt(run.ID, 10), Name: run.Name, State: forge.CheckPending, URL: run.HTMLURL}
+		if run.Status == "completed" {
+			switch run.Conclusion {
+			case "success", "neutral", "skipped":
+				check.State = forge.CheckSuccess
+			default:
+				check.State = forge.CheckFailure
+			}
+		}
+		status.Checks = append(status.Checks, check)
+	}
+
+	var combined struct {
+		Statuses []struct {
+			Context   string `json:"context"`
+			State     string `json:"state"`
+			TargetURL string `json:"target_url"`
+		} `json:"statuses"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/commits/%s/status", repoPath(upstream), pr.Head.SHA), nil, &combined); err != nil {
+		return nil, err
+	}
+	for _, s := range combined.Statuses {
+		check := forge.Check{Name: s.Context, State: forge.CheckPending, URL: s.TargetURL}
+		switch s.State {
+		case "success":
+			check.State = forge.CheckSuccess
+		case "failure", "error":
+			check.State = forge.CheckFailure
+		}
+		status.Checks = append(status.Checks, check)
+	}
+
+	var reviews []struct {
+		User  user   `json:"user"`
+		State string `json:"state"`
+	}
+	if err := c.do(ctx, http.MethodGet, path+"/reviews?per_page=100", nil, &reviews); err != nil {
+		return nil, err
+	}
+	// Reviews are listed in chronological order, comments don't change the
+	// reviewer's state
+	latest := map[string]string{}
+	for _, review := range reviews {
+		switch review.State {
+		case "APPROVED":
+			latest[review.User.Login] = forge.ReviewApproved
+		case "CHANGES_REQUESTED":
+			latest[review.User.Login] = forge.ReviewChangesRequested
+		case "DISMISSED":
+			delete(latest, review.User.Login)
+		}
+	}
+	status.Review = forge.ReviewState(latest)
+	return status, nil
+}
+
+// CheckLog returns the log of a GitHub Actions job, the check runs of other
+// apps have no log and wrap forge.ErrNotFound. Commit statuses aren't
+// supported.
+func (c *Client) CheckLog(ctx context.Context, upstream forge.Repo, check forge.Check) (string, error) {
+	if check.ID == "" {
+		return "", forge.ErrNotSupported
+	}
+	// The API redirects to the log file, the redirect drops the token
+	var log []byte
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/actions/jobs/%s/logs", repoPath(upstream), check.ID), nil, &log); err != nil {
+		return "", err
+	}
+	return string(log), nil
+}
+
+const reviewThreadsQuery = `query($owner: String!, $name: String!, $number: Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      reviewThreads(first: 100) {
+        nodes {
+          isResolved
+          path
+          line
+          comments(first: 100) {
+            nodes { databaseId body url author { login } }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// ReviewThreads returns the unresolved review threads of a pull request. The
+// REST API doesn't report whether a thread is resolved, so they are queried
+// with GraphQL. The thread ID is the ID of its first comment.
+func (c *Client) ReviewThreads(ctx context.Context, upstream forge.Repo, number int) ([]forge.ReviewThread, error) {
+	var data struct {
+		Repository struct {
+			PullRequest *struct {
+				ReviewThreads struct {
+					Nodes []struct {
+						IsResolved bool   `json:"isResolved"`
+						Path       string `json:"path"`
+						Line       int    `json:"line"`
+						Comments   struct {
+							Nodes []struct {
+								DatabaseID int64  `json:"databaseId"`
+								Body       string `json:"body"`
+								URL        string `json:"url"`
+								Author     *user  `json:"author"`
+							} `json:"nodes"`
+						} `json:"comments"`
+					} `json:"nodes"`
+				} `json:"reviewThreads"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	}
+	vars := map[string]any{"owner": upstream.Owner, "name": upstream.Name, "number": number}
+	if err := c.graphql(ctx, reviewThreadsQuery, vars, &data); err != nil {
+		return nil, err
+	}
+	if data.Repository.PullRequest == nil {
+		return nil, fmt.Errorf("pull request %d of %s: %w", number, upstream.FullName(), forge.ErrNotFound)
+	}
+
+	var threads []forge.ReviewThread
+	for _, node := range data.Repository.PullRequest.ReviewThreads.Nodes {
+		if node.IsResolved || len(node.Comments.Nodes) == 0 {
+			continue
+		}
+		thread := forge.ReviewThread{
+			ID:   strconv.FormatInt(node.Comments.Nodes[0].DatabaseID, 10),
+			Path: node.Path,
+			Line: node.Line,
+		}
+		for _, comment := range node.Comments.Nodes {
+			// The author is null for deleted accounts
+			author := "ghost"
+			if comment.Author != nil {
+				author = comment.Author.Login
+			}
+			thread.Comments = append(thread.Comments, forge.ReviewComment{Author: author, Body: comment.Body, URL: comment.URL})
+		}
+		threads = append(threads, thread)
+	}
+	return threads, nil
+}
+
+// ReplyToReviewThread replies to the first comment of the thread.
+func (c *Client) ReplyToReviewThread(ctx context.Context, upstream forge.Repo, number int, thread forge.ReviewThread, body string) error {
+	path := fmt.Sprintf("%s/pulls/%d/comments/%s/replies", repoPath(upstream), number, thread.ID)
+	return c.do(ctx, http.MethodPost, path, map[string]string{"body": body}, nil)
+}
+
+// CanPush checks the user's permission on the repository, users who aren't
+// collaborators are not found.
+func (c *Client) CanPush(ctx context.Context, repo forge.Repo, user string) (bool, error) {
+	var permission struct {
+		Permission string `json:"permission"`
+	}
+	path := fmt.Sprintf("%s/collaborators/%s/permission", repoPath(repo), url.PathEscape(user))
+	if err := c.do(ctx, http.MethodGet, path, nil, &permission); err != nil {
+		if errors.Is(err, forge.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	// maintain and triage are reported as write and read
+	return permission.Permission == "admin" || permission.Permission == "write", nil
+}
+
+func (c *Client) GitCredentials() (string, string) {
+	return "x-access-token", c.token
+}
+
+// graphql runs a GraphQL query. GitHub Enterprise Server serves the endpoint
+// at /api/graphql, next to the REST API at /api/v3.
+func (c *Client) graphql(ctx context.Context, query string, vars map[string]any, out any) error {
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	endpoint := strings.TrimSuffix(c.baseURL, "/v3") + "/graphql"
+	if err := c.do(ctx, http.MethodPost, endpoint, map[string]any{"query": query, "variables": vars}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		// Errors are reported with status 200
+		statusCode := http.StatusUnprocessableEntity
+		if resp.Errors[0].Type == "NOT_FOUND" {
+			statusCode = http.StatusNotFound
+		}
+		return fmt.Errorf("POST graphql: %w", &APIError{StatusCode: statusCode, Message: resp.Errors[0].Message})
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("POST graphql: failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func repoPath(repo forge.Repo) string {
+	return fmt.Sprintf("/repos/%s/%s", repo.Owner, repo.Name)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	_, err := c.doHeader(ctx, method, path, in, out)
+	return err
+}
+
+// doHeader is do, returning the response headers.
+func (c *Client) doHeader(ctx context.Context, method, path string, in, out any) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// Paths outside the REST API are absolute, see graphql
+	endpoint := path
+	if !strings.Contains(path, "://") {
+		endpoint = c.baseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("%s %s: %w", method, path, &APIError{StatusCode: resp.StatusCode, Message: apiErr.Message})
+	}
+
+	if out == nil {
+		return resp.Header, nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		if *raw, err = io.ReadAll(io.LimitReader(resp.Body, forge.MaxLogSize)); err != nil {
+			return nil, fmt.Errorf("%s %s: failed to read response: %w", method, path, err)
+		}
+		return resp.Header, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+	}
+	return resp.Header, nil
+}
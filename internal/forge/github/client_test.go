@@ -0,0 +1,286 @@
+package github
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestClient(t *testing.T) {
+	var gotPR struct {
+		Title string `json:"title"`
+		Head  string `json:"head"`
+		Base  string `json:"base"`
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"Bad credentials"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"login":"octocat"}`))
+	})
+	mux.HandleFunc("GET /repos/octocat/fork", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"fork","full_name":"octocat/fork","fork":true,"owner":{"login":"octocat"}}`))
+	})
+	mux.HandleFunc("GET /repos/octocat/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+	})
+	mux.HandleFunc("POST /repos/org/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&gotPR); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number":7,"html_url":"https://github.com/org/repo/pull/7","state":"open"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := t.Context()
+	c := NewClient(server.URL, "test-token")
+
+	login, err := c.CurrentUser(ctx)
+	if err != nil || login != "octocat" {
+		t.Fatalf("CurrentUser() = %q, %v", login, err)
+	}
+
+	repo, err := c.GetRepository(ctx, forge.Repo{Host: "github.com", Owner: "octocat", Name: "fork"})
+	if err != nil || !repo.Fork || repo.FullName() != "octocat/fork" {
+		t.Fatalf("GetRepository() = %+v, %v", repo, err)
+	}
+
+	_, err = c.GetRepository(ctx, forge.Repo{Host: "github.com", Owner: "octocat", Name: "missing"})
+	if !errors.Is(err, forge.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	pr, err := c.CreatePullRequest(ctx, forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}, forge.NewPullRequest{
+		Title:      `Fix "quoted" title`,
+		Body:       "body",
+		Head:       forge.Repo{Host: "github.com", Owner: "octocat", Name: "repo"},
+		HeadBranch: "baca-1",
+		Base:       "main",
+	})
+	if err != nil || pr.Number != 7 {
+		t.Fatalf("CreatePullRequest() = %+v, %v", pr, err)
+	}
+	if gotPR.Title != `Fix "quoted" title` || gotPR.Head != "octocat:baca-1" {
+		t.Errorf("unexpected pull request request: %+v", gotPR)
+	}
+
+	_, err = NewClient(server.URL, "wrong").CurrentUser(ctx)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Bad credentials" {
+		t.Errorf("expected APIError 401, got %v", err)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	if got := BaseURL("github.com"); got != DefaultBaseURL {
+		t.Errorf("BaseURL(github.com) = %s", got)
+	}
+	if got := BaseURL("ghe.example.com"); got != "https://ghe.example.com/api/v3" {
+		t.Errorf("BaseURL(ghe.example.com) = %s", got)
+	}
+}
+
+func TestTokenInfo(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		w.Header().Set("GitHub-Authentication-Token-Expiration", "2026-11-01 00:00:00 UTC")
+		_, _ = w.Write([]byte(`{"login":"octocat"}`))
+	})
+	mux.HandleFunc("GET /user/memberships/orgs/my-team", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"state":"active","role":"member"}`))
+	})
+	mux.HandleFunc("GET /orgs/my-team", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"login":"my-team","members_can_create_repositories":true}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := t.Context()
+	c := NewClient(server.URL, "ghp_test")
+	info, err := c.TokenInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Login != "octocat" || info.FineGrained || len(info.Scopes) != 2 || info.Scopes[1] != "read:org" {
+		t.Errorf("unexpected token info %+v", info)
+	}
+	if info.Expires.Format("2006-01-02") != "2026-11-01" {
+		t.Errorf("unexpected expiration %v", info.Expires)
+	}
+
+	if info, _ := NewClient(server.URL, "github_pat_test").TokenInfo(ctx); !info.FineGrained {
+		t.Error("expected fine-grained token")
+	}
+
+	m, err := c.OrgMembership(ctx, "my-team")
+	if err != nil || m.State != "active" || m.MembersCanCreateRepositories == nil || !*m.MembersCanCreateRepositories {
+		t.Errorf("OrgMembership() = %+v, %v", m, err)
+	}
+	if _, err := c.OrgMembership(ctx, "other"); !errors.Is(err, forge.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPullRequestStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/org/repo/pulls/7", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"number":7,"html_url":"https://github.com/org/repo/pull/7","state":"open","merged":false,"mergeable":true,
+			"head":{"ref":"baca-1","sha":"abc123","repo":{"name":"repo","owner":{"login":"octocat"}}}}`))
+	})
+	mux.HandleFunc("GET /repos/org/repo/commits/abc123/check-runs", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"check_runs":[
+			{"name":"lint","status":"completed","conclusion":"success"},
+			{"name":"test","status":"completed","conclusion":"failure","html_url":"https://github.com/org/repo/runs/1"},
+			{"name":"e2e","status":"in_progress","conclusion":null}]}`))
+	})
+	mux.HandleFunc("GET /repos/org/repo/commits/abc123/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"state":"success","statuses":[{"context":"ci/legacy","state":"success"}]}`))
+	})
+	mux.HandleFunc("GET /repos/org/repo/pulls/7/reviews", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"user":{"login":"alice"},"state":"CHANGES_REQUESTED"},
+			{"user":{"login":"alice"},"state":"COMMENTED"},
+			{"user":{"login":"alice"},"state":"APPROVED"},
+			{"user":{"login":"bob"},"state":"COMMENTED"}]`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	status, err := NewClient(server.URL, "test-token").PullRequestStatus(t.Context(), forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}, 7)
+	if err != nil {
+		t.Fatalf("PullRequestStatus() error = %v", err)
+	}
+	if status.State != forge.StateOpen || status.Mergeable == nil || !*status.Mergeable {
+		t.Errorf("unexpected state: %+v", status)
+	}
+	if status.Head.FullName() != "octocat/repo" || status.HeadBranch != "baca-1" || status.HeadSHA != "abc123" {
+		t.Errorf("unexpected head: %+v", status)
+	}
+	if len(status.Checks) != 4 || status.CheckState() != forge.CheckFailure {
+		t.Errorf("unexpected checks: %+v", status.Checks)
+	}
+	if failed := status.FailedChecks(); len(failed) != 1 || failed[0].Name != "test" || failed[0].URL == "" {
+		t.Errorf("unexpected failed checks: %+v", failed)
+	}
+	if status.Review != forge.ReviewApproved {
+		t.Errorf("Review = %q, want approved", status.Review)
+	}
+}
+
+func TestCheckLog(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/org/repo/actions/jobs/42/logs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/blob/42.txt", http.StatusFound)
+	})
+	mux.HandleFunc("GET /blob/42.txt", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("--- FAIL: TestAdd\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := t.Context()
+	c := NewClient(server.URL, "test-token")
+	upstream := forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}
+
+	log, err := c.CheckLog(ctx, upstream, forge.Check{ID: "42", Name: "test"})
+	if err != nil || log != "--- FAIL: TestAdd\n" {
+		t.Fatalf("CheckLog() = %q, %v", log, err)
+	}
+	if _, err := c.CheckLog(ctx, upstream, forge.Check{Name: "ci/legacy"}); !errors.Is(err, forge.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported for a commit status, got %v", err)
+	}
+}
+
+func TestReviewThreads(t *testing.T) {
+	var gotReply map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Variables map[string]any `json:"variables"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Variables["number"] != float64(7) {
+			_, _ = w.Write([]byte(`{"data":{"repository":{"pullRequest":null}},"errors":[{"type":"NOT_FOUND","message":"Could not resolve to a PullRequest"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"repository":{"pullRequest":{"reviewThreads":{"nodes":[
+			{"isResolved":true,"path":"a.go","line":1,"comments":{"nodes":[{"databaseId":1,"body":"done","author":{"login":"alice"}}]}},
+			{"isResolved":false,"path":"main.go","line":12,"comments":{"nodes":[
+				{"databaseId":2,"body":"Handle the error","url":"https://github.com/org/repo/pull/7#discussion_r2","author":{"login":"alice"}},
+				{"databaseId":3,"body":"+1","author":null}
+			]}}
+		]}}}}}`))
+	})
+	mux.HandleFunc("POST /repos/org/repo/pulls/7/comments/2/replies", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotReply)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := t.Context()
+	c := NewClient(server.URL, "test-token")
+	upstream := forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}
+
+	threads, err := c.ReviewThreads(ctx, upstream, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 1 {
+		t.Fatalf("expected only the unresolved thread, got %+v", threads)
+	}
+	thread := threads[0]
+	if thread.ID != "2" || thread.Path != "main.go" || thread.Line != 12 || len(thread.Comments) != 2 {
+		t.Errorf("unexpected thread %+v", thread)
+	}
+	if thread.Comments[0].Author != "alice" || thread.Comments[1].Author != "ghost" {
+		t.Errorf("unexpected authors %+v", thread.Comments)
+	}
+
+	if err := c.ReplyToReviewThread(ctx, upstream, 7, thread, "Fixed"); err != nil {
+		t.Fatal(err)
+	}
+	if gotReply["body"] != "Fixed" {
+		t.Errorf("unexpected reply %v", gotReply)
+	}
+
+	if _, err := c.ReviewThreads(ctx, upstream, 8); !errors.Is(err, forge.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing pull request, got %v", err)
+	}
+}
+
+func TestCanPush(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/org/repo/collaborators/{user}/permission", func(w http.ResponseWriter, r *http.Request) {
+		permission, ok := map[string]string{"alice": "admin", "bob": "write", "carol": "read"}[r.PathValue("user")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"permission":"` + permission + `"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := NewClient(server.URL, "test-token")
+	upstream := forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}
+	for user, want := range map[string]bool{"alice": true, "bob": true, "carol": false, "mallory": false} {
+		got, err := c.CanPush(t.Context(), upstream, user)
+		if err != nil || got != want {
+			t.Errorf("CanPush(%s) = %v, %v, want %v", user, got, err, want)
+		}
+	}
+}
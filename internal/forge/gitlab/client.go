@@ -0,0 +1,430 @@
+// Package gitlab implements forge.Provider for the GitLab REST API (v4),
+// for gitlab.com and self-hosted instances.
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+// APIError is returned for unsuccessful API responses.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gitlab api: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return forge.ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return forge.ErrUnauthorized
+	}
+	return nil
+}
+
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+var _ forge.Provider = &Client{}
+
+// NewClient returns a client for the API at baseURL, e.g.
+// https://gitlab.example.com/api/v4, authenticating with token.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// BaseURL returns the API URL for a GitLab host.
+func BaseURL(host string) string {
+	return "https://" + host + "/api/v4"
+}
+
+type project struct {
+	ID                int    `json:"id"`
+	Path              string `json:"path"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+	ImportStatus      string `json:"import_status"`
+	ForkedFromProject *struct {
+		ID int `json:"id"`
+	} `json:"forked_from_project"`
+	Namespace struct {
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+}
+
+type mergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+}
+
+func (c *Client) CurrentUser(ctx context.Context) (string, error) {
+	var u struct {
+		Username string `json:"username"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/user", nil, &u); err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+func (c *Client) GetRepository(ctx context.Context, repo forge.Repo) (*forge.Repository, error) {
+	p, err := c.getProject(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	return &forge.Repository{
+		Repo:          forge.Repo{Host: repo.Host, Owner: p.Namespace.FullPath, Name: p.Path},
+		Fork:          p.ForkedFromProject != nil,
+		DefaultBranch: p.DefaultBranch,
+		Importing:     p.ImportStatus != "" && p.ImportStatus != "none" && p.ImportStatus != "finished",
+	}, nil
+}
+
+// CreateFork forks upstream into the owner namespace (group or user).
+func (c *Client) CreateFork(ctx context.Context, upstream forge.Repo, owner string) error {
+	body := map[string]string{}
+	if owner != "" {
+		body["namespace_path"] = owner
+	}
+	return c.do(ctx, http.MethodPost, projectPath(upstream)+"/fork", body, nil)
+}
+
+// SyncFork is not available in the GitLab API, `baca clone` fast-forwards the
+// fork's branch to upstream's instead.
+func (c *Client) SyncFork(context.Context, forge.Repo, string) error {
+	return forge.ErrNotSupported
+}
+
+// CreatePullRequest creates a merge request from the head project, which is
+// the fork, into upstream.
+func (c *Client) CreatePullRequest(ctx context.Context, upstream forge.Repo, pr forge.NewPullRequest) (*forge.PullRequest, error) {
+	body := map[string]any{
+		"title":         pr.Title,
+		"description":   pr.Body,
+		"source_branch": pr.HeadBranch,
+		"target_branch": pr.Base,
+	}
+	if pr.Head != upstream {
+		target, err := c.getProject(ctx, upstream)
+		if err != nil {
+			return nil, err
+		}
+		body["target_project_id"] = target.ID
+	}
+
+	var mr mergeRequest
+	if err := c.do(ctx, http.MethodPost, projectPath(pr.Head)+"/merge_requests", body, &mr); err != nil {
+		return nil, err
+	}
+	return &forge.PullRequest{Number: mr.IID, URL: mr.WebURL}, nil
+}
+
+func (c *Client) EditPullRequest(ctx context.Context, upstream forge.Repo, number int, edit forge.PullRequestEdit) error {
+	body := map[string]string{}
+	if edit.Title != "" {
+		body["title"] = edit.Title
+	}
+	if edit.Body != "" {
+		body["description"] = edit.Body
+	}
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("%s/merge_requests/%d", projectPath(upstream), number), body, nil)
+}
+
+func (c *Client) AddLabels(ctx context.Context, upstream forge.Repo, number int, labels []string) error {
+	body := map[string]string{"add_labels": strings.Join(labels, ",")}
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("%s/merge_requests/%d", projectPath(upstream), number), body, nil)
+}
+
+// PullRequestStatus reads the jobs of the merge request's head pipeline as
+// checks. GitLab reports requested changes only in the detailed merge status,
+// not per reviewer.
+func (c *Client) PullRequestStatus(ctx context.Context, upstream forge.Repo, number int) (*forge.PullRequestStatus, error) {
+	var mr struct {
+		mergeRequest
+		State               string `json:"state"`
+		SHA                 string `json:"sha"`
+		SourceBranch        string `json:"source_branch"`
+		SourceProjectID     int    `json:"source_project_id"`
+		HasConflicts        bool   `json:"has_conflicts"`
+		DetailedMergeStatus string `json:"detailed_merge_status"`
+		HeadPipeline        *struct {
+			ID        int `json:"id"`
+			ProjectID int `json:"project_id"`
+		} `json:"head_pipeline"`
+	}
+	path := fmt.Sprintf("%s/merge_requests/%d", projectPath(upstream), number)
+	if err := c.do(ctx, http.MethodGet, path, nil, &mr); err != nil {
+		return nil, err
+	}
+	status := &forge.PullRequestStatus{
+		PullRequest: forge.PullRequest{Number: mr.IID, URL: mr.WebURL},
+		State:       forge.StateOpen,
+		HeadBranch:  mr.SourceBranch,
+		HeadSHA:     mr.SHA,
+	}
+	switch mr.State {
+	case "merged":
+		status.State = forge.StateMerged
+	case "closed":
+		status.State = forge.StateClosed
+	}
+	switch mr.DetailedMergeStatus {
+	case "checking", "unchecked", "preparing":
+	default:
+		mergeable := !mr.HasConflicts
+		status.Mergeable = &mergeable
+	}
+
+	var source project
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/projects/%d", mr.SourceProjectID), nil, &source); err != nil {
+		return nil, err
+	}
+	status.Head = forge.Repo{Host: upstream.Host, Owner: source.Namespace.FullPath, Name: source.Path}
+
+	if mr.HeadPipeline != nil {
+		var jobs []struct {
+			ID           int    `json:"id"`
+			Name         string `json:"name"`
+			Status       string `json:"status"`
+			AllowFailure bool   `json:"allow_failure"`
+			WebURL       string `json:"web_url"`
+		}
+		jobsPath := fmt.Sprintf("/projects/%d/pipelines/%d/jobs?per_page=100", mr.HeadPipeline.ProjectID, mr.HeadPipeline.ID)
+		if err := c.do(ctx, http.MethodGet, jobsPath, nil, &jobs); err != nil {
+			return nil, err
+		}
+		for _, job := range jobs {
+			// Jobs of fork pipelines are in the fork project
+			id := fmt.Sprintf("%d/%d", mr.HeadPipeline.ProjectID, job.ID)
+			check := forge.Check{ID: id, Name: job.Name, State: forge.CheckPending, URL: job.WebURL}
+			switch job.Status {
+			case "success":
+				check.State = forge.CheckSuccess
+			case "failed", "canceled":
+				check.State = forge.CheckFailure
+				if job.AllowFailure {
+					check.State = forge.CheckSuccess
+				}
+			case "skipped", "manual":
+				continue
+			}
+			status.Checks = append(status.Checks, check)
+		}
+	}
+
+	var approvals struct {
+		ApprovedBy []struct {
+			User struct {
+				Username string `json:"username"`
+			} `json:"user"`
+		} `json:"approved_by"`
+	}
+	if err := c.do(ctx, http.MethodGet, path+"/approvals", nil, &approvals); err != nil {
+		return nil, err
+	}
+	latest := map[string]string{}
+	for _, approval := range approvals.ApprovedBy {
+		latest[approval.User.Username] = forge.ReviewApproved
+	}
+	if mr.DetailedMergeStatus == "requested_changes" {
+		latest[""] = forge.ReviewChangesRequested
+	}
+	status.Review = forge.ReviewState(latest)
+	return status, nil
+}
+
+// CheckLog returns the trace of a pipeline job, the check ID is
+// "<project ID>/<job ID>".
+func (c *Client) CheckLog(ctx context.Context, _ forge.Repo, check forge.Check) (string, error) {
+	projectID, jobID, ok := strings.Cut(check.ID, "/")
+	if !ok {
+		return "", forge.ErrNotSupported
+	}
+	var log []byte
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/projects/%s/jobs/%s/trace", projectID, jobID), nil, &log); err != nil {
+		return "", err
+	}
+	return string(log), nil
+}
+
+// ReviewThreads returns the unresolved discussions of a merge request, the
+// thread ID is the discussion ID.
+func (c *Client) ReviewThreads(ctx context.Context, upstream forge.Repo, number int) ([]forge.ReviewThread, error) {
+	var discussions []struct {
+		ID    string `json:"id"`
+		Notes []struct {
+			ID     int    `json:"id"`
+			Body   string `json:"body"`
+			System bool   `json:"system"`
+			Author struct {
+				Username string `json:"username"`
+			} `json:"author"`
+			Resolvable bool `json:"resolvable"`
+			Resolved   bool `json:"resolved"`
+			Position   *struct {
+				NewPath string `json:"new_path"`
+				NewLine int    `json:"new_line"`
+			} `json:"position"`
+		} `json:"notes"`
+	}
+	path := fmt.Sprintf("%s/merge_requests/%d/discussions?per_page=100", projectPath(upstream), number)
+	if err := c.do(ctx, http.MethodGet, path, nil, &discussions); err != nil {
+		return nil, err
+	}
+	mrURL := fmt.Sprintf("%s/-/merge_requests/%d", upstream.URL(), number)
+	var threads []forge.ReviewThread
+	for _, d := range discussions {
+		// Only comments and replies to them can be resolved, not the
+		// system notes of pushes and approvals
+		if len(d.Notes) == 0 || !d.Notes[0].Resolvable || d.Notes[0].Resolved {
+			continue
+		}
+		thread := forge.ReviewThread{ID: d.ID}
+		if pos := d.Notes[0].Position; pos != nil {
+			thread.Path, thread.Line = pos.NewPath, pos.NewLine
+		}
+		for _, note := range d.Notes {
+			if note.System {
+				continue
+			}
+			thread.Comments = append(thread.Comments, forge.ReviewComment{
+				Author: note.Author.Username,
+				Body:   note.Body,
+				URL:    fmt.Sprintf("%s#note_%d", mrURL, note.ID),
+			})
+		}
+		threads = append(threads, thread)
+	}
+	return threads, nil
+}
+
+// ReplyToReviewThread adds a note to the discussion.
+func (c *Client) ReplyToReviewThread(ctx context.Context, upstream forge.Repo, number int, thread forge.ReviewThread, body string) error {
+	path := fmt.Sprintf("%s/merge_requests/%d/discussions/%s/notes", projectPath(upstream), number, url.PathEscape(thread.ID))
+	return c.do(ctx, http.MethodPost, path, map[string]string{"body": body}, nil)
+}
+
+// developerAccess is the lowest access level which may push to a project
+const developerAccess = 30
+
+// CanPush looks up the user among the project's members, including those
+// inherited from its groups.
+func (c *Client) CanPush(ctx context.Context, repo forge.Repo, user string) (bool, error) {
+	var members []struct {
+		Username    string `json:"username"`
+		AccessLevel int    `json:"access_level"`
+	}
+	path := fmt.Sprintf("%s/members/all?query=%s&per_page=100", projectPath(repo), url.QueryEscape(user))
+	if err := c.do(ctx, http.MethodGet, path, nil, &members); err != nil {
+		return false, err
+	}
+	for _, member := range members {
+		if member.Username == user {
+			return member.AccessLevel >= developerAccess, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Client) GitCredentials() (string, string) {
+	return "oauth2", c.token
+}
+
+func (c *Client) getProject(ctx context.Context, repo forge.Repo) (*project, error) {
+	var p project
+	if err := c.do(ctx, http.MethodGet, projectPath(repo), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// projectPath addresses a project by its URL encoded full path, which
+// supports nested groups.
+func projectPath(repo forge.Repo) string {
+	return "/projects/" + url.PathEscape(repo.FullName())
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// GitLab returns "message" as a string or an object of field errors
+		var apiErr struct {
+			Message json.RawMessage `json:"message"`
+			Error   string          `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &apiErr) == nil {
+			var s string
+			switch {
+			case json.Unmarshal(apiErr.Message, &s) == nil && s != "":
+				msg = s
+			case len(apiErr.Message) > 0:
+				msg = string(apiErr.Message)
+			case apiErr.Error != "":
+				msg = apiErr.Error
+			}
+		}
+		return fmt.Errorf("%s %s: %w", method, path, &APIError{StatusCode: resp.StatusCode, Message: msg})
+	}
+
+	if out == nil {
+		return nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		if *raw, err = io.ReadAll(io.LimitReader(resp.Body, forge.MaxLogSize)); err != nil {
+			return fmt.Errorf("%s %s: failed to read response: %w", method, path, err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+	}
+	return nil
+}
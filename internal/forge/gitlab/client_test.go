@@ -0,0 +1,229 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestClient(t *testing.T) {
+	var gotFork, gotMR map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"401 Unauthorized"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"username":"alice"}`))
+	})
+	mux.HandleFunc("GET /projects/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("id") {
+		case "platform/infra/deploy":
+			_, _ = w.Write([]byte(`{"id":1,"path":"deploy","path_with_namespace":"platform/infra/deploy","namespace":{"full_path":"platform/infra"},"forked_from_project":null}`))
+		case "alice/deploy":
+			_, _ = w.Write([]byte(`{"id":2,"path":"deploy","path_with_namespace":"alice/deploy","namespace":{"full_path":"alice"},"import_status":"started","forked_from_project":{"id":1}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"404 Project Not Found"}`))
+		}
+	})
+	mux.HandleFunc("POST /projects/{id}/fork", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotFork)
+		gotFork["id"] = r.PathValue("id")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":3}`))
+	})
+	mux.HandleFunc("POST /projects/{id}/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotMR)
+		gotMR["id"] = r.PathValue("id")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"iid":5,"web_url":"https://gitlab.example.com/platform/infra/deploy/-/merge_requests/5"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := t.Context()
+	c := NewClient(server.URL, "test-token")
+	upstream := forge.Repo{Host: "gitlab.example.com", Owner: "platform/infra", Name: "deploy"}
+	fork := forge.Repo{Host: "gitlab.example.com", Owner: "alice", Name: "deploy"}
+
+	login, err := c.CurrentUser(ctx)
+	if err != nil || login != "alice" {
+		t.Fatalf("CurrentUser() = %q, %v", login, err)
+	}
+
+	repo, err := c.GetRepository(ctx, upstream)
+	if err != nil || repo.Fork || repo.Repo != upstream {
+		t.Fatalf("GetRepository(upstream) = %+v, %v", repo, err)
+	}
+	repo, err = c.GetRepository(ctx, fork)
+	if err != nil || !repo.Fork || !repo.Importing {
+		t.Fatalf("GetRepository(fork) = %+v, %v", repo, err)
+	}
+	_, err = c.GetRepository(ctx, forge.Repo{Host: "gitlab.example.com", Owner: "alice", Name: "missing"})
+	if !errors.Is(err, forge.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := c.CreateFork(ctx, upstream, "team"); err != nil {
+		t.Fatal(err)
+	}
+	if gotFork["id"] != "platform/infra/deploy" || gotFork["namespace_path"] != "team" {
+		t.Errorf("unexpected fork request %v", gotFork)
+	}
+
+	if err := c.SyncFork(ctx, fork, "main"); !errors.Is(err, forge.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+
+	mr, err := c.CreatePullRequest(ctx, upstream, forge.NewPullRequest{
+		Title:      "Title",
+		Body:       "Body",
+		Head:       fork,
+		HeadBranch: "baca-1",
+		Base:       "main",
+	})
+	if err != nil || mr.Number != 5 {
+		t.Fatalf("CreatePullRequest() = %+v, %v", mr, err)
+	}
+	if gotMR["id"] != "alice/deploy" || gotMR["target_project_id"] != float64(1) || gotMR["source_branch"] != "baca-1" || gotMR["description"] != "Body" {
+		t.Errorf("unexpected merge request %v", gotMR)
+	}
+
+	user, password := c.GitCredentials()
+	if user != "oauth2" || password != "test-token" {
+		t.Errorf("unexpected git credentials %s", user)
+	}
+}
+
+func TestPullRequestStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/{id}/merge_requests/3", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"iid":3,"web_url":"https://gitlab.com/platform/deploy/-/merge_requests/3","state":"merged",
+			"sha":"abc123","source_branch":"baca-1","source_project_id":2,"has_conflicts":false,
+			"detailed_merge_status":"not_open","head_pipeline":{"id":9,"project_id":2}}`))
+	})
+	mux.HandleFunc("GET /projects/2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":2,"path":"deploy","namespace":{"full_path":"alice"}}`))
+	})
+	mux.HandleFunc("GET /projects/2/pipelines/9/jobs", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"name":"lint","status":"success"},
+			{"name":"flaky","status":"failed","allow_failure":true},
+			{"name":"deploy","status":"manual"}]`))
+	})
+	mux.HandleFunc("GET /projects/{id}/merge_requests/3/approvals", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"approved_by":[{"user":{"username":"bob"}}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	status, err := NewClient(server.URL, "test-token").PullRequestStatus(t.Context(), forge.Repo{Host: "gitlab.com", Owner: "platform", Name: "deploy"}, 3)
+	if err != nil {
+		t.Fatalf("PullRequestStatus() error = %v", err)
+	}
+	if status.State != forge.StateMerged || status.Mergeable == nil || !*status.Mergeable {
+		t.Errorf("unexpected state: %+v", status)
+	}
+	if status.Head.FullName() != "alice/deploy" || status.HeadBranch != "baca-1" {
+		t.Errorf("unexpected head: %+v", status)
+	}
+	if len(status.Checks) != 2 || status.CheckState() != forge.CheckSuccess {
+		t.Errorf("unexpected checks: %+v", status.Checks)
+	}
+	if status.Review != forge.ReviewApproved {
+		t.Errorf("Review = %q, want approved", status.Review)
+	}
+}
+
+func TestCheckLog(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/2/jobs/11/trace", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("npm ERR! test failed\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	log, err := NewClient(server.URL, "test-token").CheckLog(t.Context(), forge.Repo{Host: "gitlab.com", Owner: "platform", Name: "deploy"}, forge.Check{ID: "2/11"})
+	if err != nil || log != "npm ERR! test failed\n" {
+		t.Fatalf("CheckLog() = %q, %v", log, err)
+	}
+}
+
+func TestReviewThreads(t *testing.T) {
+	var gotReply map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/platform%2Fdeploy/merge_requests/7/discussions", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"id":"aaa","notes":[{"id":1,"body":"added 1 commit","system":true,"resolvable":false}]},
+			{"id":"bbb","notes":[{"id":2,"body":"done","author":{"username":"alice"},"resolvable":true,"resolved":true}]},
+			{"id":"ccc","notes":[
+				{"id":3,"body":"Handle the error","author":{"username":"alice"},"resolvable":true,"position":{"new_path":"main.go","new_line":12}},
+				{"id":4,"body":"+1","author":{"username":"bob"},"resolvable":true}
+			]}
+		]`))
+	})
+	mux.HandleFunc("POST /projects/platform%2Fdeploy/merge_requests/7/discussions/ccc/notes", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotReply)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := t.Context()
+	c := NewClient(server.URL, "test-token")
+	upstream := forge.Repo{Host: "gitlab.com", Owner: "platform", Name: "deploy"}
+
+	threads, err := c.ReviewThreads(ctx, upstream, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 1 {
+		t.Fatalf("expected only the unresolved discussion, got %+v", threads)
+	}
+	thread := threads[0]
+	if thread.ID != "ccc" || thread.Path != "main.go" || thread.Line != 12 || len(thread.Comments) != 2 {
+		t.Errorf("unexpected thread %+v", thread)
+	}
+	if thread.URL() != "https://gitlab.com/platform/deploy/-/merge_requests/7#note_3" {
+		t.Errorf("unexpected URL %s", thread.URL())
+	}
+
+	if err := c.ReplyToReviewThread(ctx, upstream, 7, thread, "Fixed"); err != nil {
+		t.Fatal(err)
+	}
+	if gotReply["body"] != "Fixed" {
+		t.Errorf("unexpected reply %v", gotReply)
+	}
+}
+
+func TestCanPush(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/platform%2Fdeploy/members/all", func(w http.ResponseWriter, r *http.Request) {
+		members := map[string]string{
+			"alice": `[{"username":"alice","access_level":40}]`,
+			"bob":   `[{"username":"bob","access_level":20},{"username":"bobby","access_level":50}]`,
+		}[r.URL.Query().Get("query")]
+		if members == "" {
+			members = "[]"
+		}
+		_, _ = w.Write([]byte(members))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := NewClient(server.URL, "test-token")
+	upstream := forge.Repo{Host: "gitlab.com", Owner: "platform", Name: "deploy"}
+	for user, want := range map[string]bool{"alice": true, "bob": false, "mallory": false} {
+		got, err := c.CanPush(t.Context(), upstream, user)
+		if err != nil || got != want {
+			t.Errorf("CanPush(%s) = %v, %v, want %v", user, got, err, want)
+		}
+	}
+}
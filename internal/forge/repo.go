@@ -0,0 +1,69 @@
+// Package forge contains the git hosting (forge) primitives shared by the
+// fork setup and publish steps of a job.
+package forge
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Repo identifies a repository on a forge.
+type Repo struct {
+	Host  string // e.g. "github.com"
+	Owner string // user or organization
+	Name  string // repository name without .git
+}
+
+// ParseRepoURL parses https and scp-like ssh repository URLs, e.g.
+// https://github.com/org/repo(.git) or git@github.com:org/repo.git.
+func ParseRepoURL(repoURL string) (Repo, error) {
+	var host, path string
+
+	if strings.HasPrefix(repoURL, "git@") && !strings.Contains(repoURL, "://") {
+		rest := strings.TrimPrefix(repoURL, "git@")
+		var ok bool
+		host, path, ok = strings.Cut(rest, ":")
+		if !ok {
+			return Repo{}, fmt.Errorf("invalid repository URL %q", repoURL)
+		}
+	} else {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return Repo{}, fmt.Errorf("invalid repository URL %q: %w", repoURL, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return Repo{}, fmt.Errorf("invalid repository URL %q: missing scheme or host", repoURL)
+		}
+		host = u.Host
+		path = u.Path
+	}
+
+	path = strings.Trim(path, "/")
+	path = strings.TrimSuffix(path, ".git")
+
+	idx := strings.LastIndex(path, "/")
+	if idx <= 0 || idx == len(path)-1 {
+		return Repo{}, fmt.Errorf("invalid repository URL %q: expected <owner>/<name>", repoURL)
+	}
+
+	return Repo{
+		Host:  strings.ToLower(host),
+		Owner: path[:idx],
+		Name:  path[idx+1:],
+	}, nil
+}
+
+// FullName returns "owner/name".
+func (r Repo) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+// URL returns the https clone URL of the repository.
+func (r Repo) URL() string {
+	return fmt.Sprintf("https://%s/%s/%s", r.Host, r.Owner, r.Name)
+}
+
+func (r Repo) String() string {
+	return r.Host + "/" + r.FullName()
+}
@@ -0,0 +1,55 @@
+package forge
+
+import "testing"
+
+func TestParseRepoURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Repo
+		wantErr bool
+	}{
+		{url: "https://github.com/org/repo", want: Repo{Host: "github.com", Owner: "org", Name: "repo"}},
+		{url: "https://github.com/org/repo.git", want: Repo{Host: "github.com", Owner: "org", Name: "repo"}},
+		{url: "https://GitHub.com/org/repo/", want: Repo{Host: "github.com", Owner: "org", Name: "repo"}},
+		{url: "git@github.com:org/repo.git", want: Repo{Host: "github.com", Owner: "org", Name: "repo"}},
+		{url: "https://gitlab.example.com/group/sub/repo", want: Repo{Host: "gitlab.example.com", Owner: "group/sub", Name: "repo"}},
+		{url: "https://git.example.com:3000/org/repo", want: Repo{Host: "git.example.com:3000", Owner: "org", Name: "repo"}},
+		{url: "not-a-valid-url", wantErr: true},
+		{url: "https://github.com/repo", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := ParseRepoURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRepoURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRepoURL(%q) = %+v, want %+v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKindForHost(t *testing.T) {
+	hosts := map[string]string{"gitlab.example.com": "gitlab"}
+
+	if kind, err := KindForHost("github.com", hosts); err != nil || kind != GitHub {
+		t.Errorf("github.com: got %q, %v", kind, err)
+	}
+	if kind, err := KindForHost("gitlab.example.com", hosts); err != nil || kind != GitLab {
+		t.Errorf("gitlab.example.com: got %q, %v", kind, err)
+	}
+	if _, err := KindForHost("git.example.com", hosts); err == nil {
+		t.Error("expected error for unknown host")
+	}
+}
+
+func TestHostTokenEnv(t *testing.T) {
+	if got := HostTokenEnv(GitHub, "ghe.example.com"); got != "GITHUB_TOKEN_GHE_EXAMPLE_COM" {
+		t.Errorf("got %s", got)
+	}
+	if got := HostTokenEnv(Gitea, "git.example.com:3000"); got != "GITEA_TOKEN_GIT_EXAMPLE_COM_3000" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,29 @@
+package forge
+
+// ReviewThread is an unresolved thread of review comments on a pull request,
+// with the comments in chronological order.
+type ReviewThread struct {
+	// ID identifies the thread for ReplyToReviewThread, its format is up to
+	// the forge
+	ID string `json:"id"`
+	// Path and Line are the commented file and line, empty for comments on
+	// the whole pull request
+	Path     string          `json:"path,omitempty"`
+	Line     int             `json:"line,omitempty"`
+	Comments []ReviewComment `json:"comments"`
+}
+
+// ReviewComment is a comment in a review thread.
+type ReviewComment struct {
+	Author string `json:"author"`
+	Body   string `json:"body"`
+	URL    string `json:"url,omitempty"`
+}
+
+// URL returns the link to the first comment of the thread.
+func (t ReviewThread) URL() string {
+	if len(t.Comments) == 0 {
+		return ""
+	}
+	return t.Comments[0].URL
+}
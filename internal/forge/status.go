@@ -0,0 +1,119 @@
+package forge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxLogSize limits the check logs read by CheckLog.
+const MaxLogSize = 32 << 20
+
+// States of a pull request.
+const (
+	StateOpen   = "open"
+	StateMerged = "merged"
+	StateClosed = "closed"
+)
+
+// States of a CI check, and of all checks of a pull request combined.
+const (
+	CheckSuccess = "success"
+	CheckFailure = "failure"
+	CheckPending = "pending"
+	// CheckNone is the combined state of a pull request without checks
+	CheckNone = "none"
+)
+
+// Review states of a pull request.
+const (
+	ReviewApproved         = "approved"
+	ReviewChangesRequested = "changes-requested"
+	ReviewNone             = "none"
+)
+
+// Check is a CI check of the head commit of a pull request, e.g. a GitHub
+// check run or commit status, or a GitLab pipeline job.
+type Check struct {
+	// ID identifies the check for CheckLog, its format is up to the forge.
+	// It's empty for checks without a log.
+	ID    string
+	Name  string
+	State string
+	// URL points to the check's details or logs, if known
+	URL string
+}
+
+// PullRequestStatus is the state of an existing pull request.
+type PullRequestStatus struct {
+	PullRequest
+	State  string
+	Checks []Check
+	Review string
+	// Mergeable is nil while the forge hasn't computed it yet
+	Mergeable *bool
+	// Head is the repository, usually the fork, HeadBranch is in
+	Head       Repo
+	HeadBranch string
+	HeadSHA    string
+}
+
+// CheckState combines the checks: failure if any failed, else pending if
+// any is pending.
+func (s *PullRequestStatus) CheckState() string {
+	if len(s.Checks) == 0 {
+		return CheckNone
+	}
+	state := CheckSuccess
+	for _, check := range s.Checks {
+		switch check.State {
+		case CheckFailure:
+			return CheckFailure
+		case CheckPending:
+			state = CheckPending
+		}
+	}
+	return state
+}
+
+// FailedChecks returns the checks which failed.
+func (s *PullRequestStatus) FailedChecks() []Check {
+	var failed []Check
+	for _, check := range s.Checks {
+		if check.State == CheckFailure {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// ReviewState combines the latest review of each reviewer: changes requested
+// by anyone take precedence over approvals.
+func ReviewState(latest map[string]string) string {
+	state := ReviewNone
+	for _, review := range latest {
+		switch review {
+		case ReviewChangesRequested:
+			return ReviewChangesRequested
+		case ReviewApproved:
+			state = ReviewApproved
+		}
+	}
+	return state
+}
+
+// PullRequestNumber returns the number of a pull request from its web URL,
+// e.g. https://github.com/org/repo/pull/7 or
+// https://gitlab.com/group/repo/-/merge_requests/7.
+func PullRequestNumber(prURL string) (int, error) {
+	path := strings.TrimRight(prURL, "/")
+	idx := strings.LastIndex(path, "/")
+	if idx < 0 {
+		return 0, fmt.Errorf("invalid pull request URL %q", prURL)
+	}
+	number, err := strconv.Atoi(path[idx+1:])
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid pull request URL %q: expected a number at the end", prURL)
+	}
+	return number, nil
+}
@@ -0,0 +1,58 @@
+package forge
+
+import "testing"
+
+func TestCheckState(t *testing.T) {
+	tests := []struct {
+		checks []Check
+		want   string
+	}{
+		{want: CheckNone},
+		{checks: []Check{{Name: "lint", State: CheckSuccess}}, want: CheckSuccess},
+		{checks: []Check{{Name: "lint", State: CheckSuccess}, {Name: "test", State: CheckPending}}, want: CheckPending},
+		{checks: []Check{{Name: "lint", State: CheckFailure}, {Name: "test", State: CheckPending}}, want: CheckFailure},
+	}
+	for _, tt := range tests {
+		s := &PullRequestStatus{Checks: tt.checks}
+		if got := s.CheckState(); got != tt.want {
+			t.Errorf("CheckState(%+v) = %q, want %q", tt.checks, got, tt.want)
+		}
+	}
+
+	s := &PullRequestStatus{Checks: []Check{{Name: "lint", State: CheckFailure}, {Name: "test", State: CheckSuccess}}}
+	if failed := s.FailedChecks(); len(failed) != 1 || failed[0].Name != "lint" {
+		t.Errorf("FailedChecks() = %+v", failed)
+	}
+}
+
+func TestReviewState(t *testing.T) {
+	if got := ReviewState(nil); got != ReviewNone {
+		t.Errorf("no reviews: got %q", got)
+	}
+	if got := ReviewState(map[string]string{"alice": ReviewApproved}); got != ReviewApproved {
+		t.Errorf("approved: got %q", got)
+	}
+	if got := ReviewState(map[string]string{"alice": ReviewApproved, "bob": ReviewChangesRequested}); got != ReviewChangesRequested {
+		t.Errorf("changes requested: got %q", got)
+	}
+}
+
+func TestPullRequestNumber(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    int
+		wantErr bool
+	}{
+		{url: "https://github.com/org/repo/pull/7", want: 7},
+		{url: "https://gitlab.com/group/sub/repo/-/merge_requests/12/", want: 12},
+		{url: "https://codeberg.org/org/repo/pulls/3", want: 3},
+		{url: "https://github.com/org/repo", wantErr: true},
+		{url: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := PullRequestNumber(tt.url)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("PullRequestNumber(%q) = %d, %v, want %d", tt.url, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package guardrails checks the agent's changes against the limits of a
+// Change before anything is committed or pushed.
+package guardrails
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/manno/baca/internal/change"
+)
+
+// FileChange is a file in the diff between the base branch and the work tree.
+type FileChange struct {
+	Path    string
+	Added   int
+	Removed int
+	Binary  bool
+	Deleted bool
+	New     bool
+}
+
+// Violation is a guardrail a change breaks. Path is empty for limits on the
+// whole diff, which can't be fixed by reverting single files.
+type Violation struct {
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Collect returns the changes in workDir compared to base, including new
+// files and commits made by the agent.
+func Collect(ctx context.Context, workDir, base string) ([]FileChange, error) {
+	// Intent-to-add makes new files show up in the diff
+	if _, err := git(ctx, workDir, "add", "--all", "--intent-to-add"); err != nil {
+		return nil, err
+	}
+
+	numstat, err := git(ctx, workDir, "diff", "--no-renames", "--numstat", "-z", base)
+	if err != nil {
+		return nil, err
+	}
+	status, err := git(ctx, workDir, "diff", "--no-renames", "--name-status", "-z", base)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDiff(numstat, status), nil
+}
+
+// ParseDiff returns the changes from the output of `git diff --no-renames
+// -z` with --numstat and --name-status, for diffs Collect can't compute,
+// e.g. of the staged changes in another repository.
+func ParseDiff(numstat, status string) []FileChange {
+	// --name-status -z: "<status>\0<path>\0" for each file
+	statuses := map[string]string{}
+	fields := strings.Split(strings.TrimSuffix(status, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		statuses[fields[i+1]] = fields[i]
+	}
+
+	// --numstat -z: "<added>\t<removed>\t<path>\0", "-" for binary files
+	var changes []FileChange
+	for _, entry := range strings.Split(strings.TrimSuffix(numstat, "\x00"), "\x00") {
+		parts := strings.SplitN(entry, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		fc := FileChange{
+			Path:    parts[2],
+			Binary:  parts[0] == "-",
+			Deleted: statuses[parts[2]] == "D",
+			New:     statuses[parts[2]] == "A",
+		}
+		fc.Added, _ = strconv.Atoi(parts[0])
+		fc.Removed, _ = strconv.Atoi(parts[1])
+		changes = append(changes, fc)
+	}
+	return changes
+}
+
+// Check returns all guardrails the changes violate.
+func Check(g change.GuardrailsSpec, changes []FileChange) []Violation {
+	var violations []Violation
+	var added, removed int
+
+	for _, fc := range changes {
+		added += fc.Added
+		removed += fc.Removed
+
+		switch {
+		case len(g.AllowedPaths) > 0 && !matchAny(g.AllowedPaths, fc.Path):
+			violations = append(violations, Violation{Path: fc.Path, Message: "not in allowed paths"})
+		case matchAny(g.ForbiddenPaths, fc.Path):
+			violations = append(violations, Violation{Path: fc.Path, Message: "matches forbidden paths"})
+		case g.ForbidDeletions && fc.Deleted:
+			violations = append(violations, Violation{Path: fc.Path, Message: "deleted, deletions are forbidden"})
+		case g.ForbidBinary && fc.Binary && !fc.Deleted:
+			violations = append(violations, Violation{Path: fc.Path, Message: "binary file, binary files are forbidden"})
+		}
+	}
+
+	if g.MaxFiles > 0 && len(changes) > g.MaxFiles {
+		violations = append(violations, Violation{Message: fmt.Sprintf("%d files changed, at most %d allowed", len(changes), g.MaxFiles)})
+	}
+	if g.MaxLinesAdded > 0 && added > g.MaxLinesAdded {
+		violations = append(violations, Violation{Message: fmt.Sprintf("%d lines added, at most %d allowed", added, g.MaxLinesAdded)})
+	}
+	if g.MaxLinesRemoved > 0 && removed > g.MaxLinesRemoved {
+		violations = append(violations, Violation{Message: fmt.Sprintf("%d lines removed, at most %d allowed", removed, g.MaxLinesRemoved)})
+	}
+	return violations
+}
+
+// Revert restores the files of the violations to their state in base, new
+// files are removed. Violations without a path are skipped.
+func Revert(ctx context.Context, workDir, base string, changes []FileChange, violations []Violation) error {
+	isNew := map[string]bool{}
+	for _, fc := range changes {
+		isNew[fc.Path] = fc.New
+	}
+
+	for _, v := range violations {
+		if v.Path == "" {
+			continue
+		}
+		if isNew[v.Path] {
+			if _, err := git(ctx, workDir, "rm", "--cached", "--quiet", "--", v.Path); err != nil {
+				return err
+			}
+			if err := os.Remove(filepath.Join(workDir, v.Path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s: %w", v.Path, err)
+			}
+			continue
+		}
+		if _, err := git(ctx, workDir, "checkout", base, "--", v.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ViolationError is returned when the agent's changes violate guardrails.
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	return fmt.Sprintf("agent changes violate %d guardrails, nothing will be pushed:\n%s", len(e.Violations), Report(e.Violations))
+}
+
+// Report formats violations for the job log and error.
+func Report(violations []Violation) string {
+	lines := make([]string, 0, len(violations))
+	for _, v := range violations {
+		lines = append(lines, "  - "+v.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matchAny reports whether path matches one of the globs. Globs support
+// `*` within a path segment and `**` across segments, a trailing slash
+// matches everything below a directory, e.g. ".github/workflows/".
+func matchAny(globs []string, path string) bool {
+	for _, glob := range globs {
+		if globRegexp(glob).MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func globRegexp(glob string) *regexp.Regexp {
+	if strings.HasSuffix(glob, "/") {
+		glob += "**"
+	}
+
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String())
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
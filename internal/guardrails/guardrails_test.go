@@ -0,0 +1,131 @@
+package guardrails
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/change"
+)
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{".github/workflows/", ".github/workflows/ci.yml", true},
+		{".github/workflows/", ".github/dependabot.yml", false},
+		{"*.md", "README.md", true},
+		{"*.md", "docs/README.md", false},
+		{"**/*.md", "docs/README.md", true},
+		{"**/*.md", "README.md", true},
+		{"src/**", "src/a/b.go", true},
+		{"go.?od", "go.mod", true},
+	}
+	for _, tt := range tests {
+		if got := matchAny([]string{tt.glob}, tt.path); got != tt.want {
+			t.Errorf("matchAny(%q, %q) = %v, want %v", tt.glob, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	changes := []FileChange{
+		{Path: "main.go", Added: 10, Removed: 2},
+		{Path: ".github/workflows/ci.yml", Added: 1},
+		{Path: "logo.png", Binary: true, New: true},
+		{Path: "old.go", Removed: 30, Deleted: true},
+	}
+	g := change.GuardrailsSpec{
+		MaxFiles:        3,
+		MaxLinesRemoved: 20,
+		ForbiddenPaths:  []string{".github/workflows/"},
+		ForbidBinary:    true,
+		ForbidDeletions: true,
+	}
+
+	var got []string
+	for _, v := range Check(g, changes) {
+		got = append(got, v.String())
+	}
+	want := []string{
+		".github/workflows/ci.yml: matches forbidden paths",
+		"logo.png: binary file, binary files are forbidden",
+		"old.go: deleted, deletions are forbidden",
+		"4 files changed, at most 3 allowed",
+		"32 lines removed, at most 20 allowed",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Check() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if v := Check(change.GuardrailsSpec{AllowedPaths: []string{"**/*.go"}}, changes[:2]); len(v) != 1 || v[0].Path != ".github/workflows/ci.yml" {
+		t.Errorf("expected only the workflow outside allowed paths, got %v", v)
+	}
+}
+
+func TestCollectAndRevert(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(path, content string) {
+		t.Helper()
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "-b", "main")
+	write("main.go", "package main\n")
+	write(".github/workflows/ci.yml", "on: push\n")
+	run("add", "-A")
+	run("commit", "-m", "initial")
+
+	// The agent edits a file, adds a workflow and changes an existing one
+	write("main.go", "package main\n\nfunc main() {}\n")
+	write(".github/workflows/ci.yml", "on: [push, pull_request]\n")
+	write(".github/workflows/new.yml", "on: push\n")
+
+	ctx := t.Context()
+	changes, err := Collect(ctx, dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changed files, got %+v", changes)
+	}
+
+	g := change.GuardrailsSpec{ForbiddenPaths: []string{".github/workflows/"}}
+	violations := Check(g, changes)
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, got %v", violations)
+	}
+	if err := Revert(ctx, dir, "main", changes, violations); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = Collect(ctx, dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != "main.go" || changes[0].Added != 2 {
+		t.Errorf("expected only main.go to remain changed, got %+v", changes)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".github/workflows/new.yml")); !os.IsNotExist(err) {
+		t.Errorf("expected new workflow to be removed, got %v", err)
+	}
+}
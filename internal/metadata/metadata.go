@@ -0,0 +1,216 @@
+// Package metadata parses and validates the pull request metadata the agent
+// generates after making its changes. `baca execute` writes it as JSON for
+// `baca publish`.
+package metadata
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTitleLength is the longest title kept, longer titles are truncated.
+const MaxTitleLength = 72
+
+const fallbackTitle = "Automated code changes"
+
+// Metadata is the pull request title and body, also used for the commit.
+type Metadata struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Labels []string `json:"labels,omitempty"`
+	// CommitMessage replaces "title\n\nbody" as the commit message
+	CommitMessage string `json:"commitMessage,omitempty"`
+}
+
+// Load reads metadata written by Write. The error wraps fs.ErrNotExist if
+// the file is missing, e.g. because execute failed before writing it.
+func Load(path string) (Metadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to read PR metadata: %w", err)
+	}
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Metadata{}, fmt.Errorf("failed to parse PR metadata: %w", err)
+	}
+	return m, m.validate()
+}
+
+// Write stores m as JSON.
+func Write(path string, m Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write PR metadata: %w", err)
+	}
+	return nil
+}
+
+// Parse extracts metadata from the agent's output. It accepts the JSON
+// object the agent is asked for, as well as the older "TITLE: ...\nBODY:\n..."
+// format, and ignores terminal escape codes, markdown fences and any text
+// around them.
+func Parse(output string) (Metadata, error) {
+	text := stripANSI(output)
+
+	m, ok := parseJSON(text)
+	if !ok {
+		m = parseText(text)
+	}
+	m.normalize()
+	return m, m.validate()
+}
+
+// Fallback returns deterministic metadata for when the agent's output can't
+// be used: the first line of the prompt as title, the prompt and the
+// diffstat as body.
+func Fallback(prompt, diffstat string) Metadata {
+	prompt = CleanPrompt(prompt)
+	title, _, _ := strings.Cut(prompt, "\n")
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = fallbackTitle
+	}
+
+	body := "## Prompt\n\n" + prompt
+	if diffstat = strings.TrimSpace(diffstat); diffstat != "" {
+		body += "\n\n## Changes\n\n```\n" + diffstat + "\n```"
+	}
+
+	m := Metadata{Title: title, Body: body}
+	m.normalize()
+	return m
+}
+
+// CleanPrompt strips everything after a "---" line, which prompts use for
+// instructions that don't belong into a pull request.
+func CleanPrompt(prompt string) string {
+	lines := strings.Split(prompt, "\n")
+	for i, line := range lines {
+		if line == "---" {
+			lines = lines[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+func stripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// parseJSON decodes the first JSON object with a title in text.
+func parseJSON(text string) (Metadata, bool) {
+	for i := strings.Index(text, "{"); i >= 0; {
+		var raw struct {
+			Metadata
+			// Agents tend to follow the snake case of the prompt's example
+			CommitMessageSnake string `json:"commit_message"`
+		}
+		if err := json.NewDecoder(strings.NewReader(text[i:])).Decode(&raw); err == nil && raw.Title != "" {
+			m := raw.Metadata
+			if m.CommitMessage == "" {
+				m.CommitMessage = raw.CommitMessageSnake
+			}
+			return m, true
+		}
+		next := strings.Index(text[i+1:], "{")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return Metadata{}, false
+}
+
+// parseText parses the "TITLE: ...\nBODY:\n..." format. A fence opened
+// before the title ends the body, code blocks within the body are kept.
+func parseText(text string) Metadata {
+	var m Metadata
+	var body []string
+	inBody, fenced, inCode := false, false, false
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+scan:
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inBody:
+			if strings.HasPrefix(trimmed, "```") {
+				if fenced && !inCode && trimmed == "```" {
+					break scan
+				}
+				inCode = !inCode
+			}
+			body = append(body, line)
+		case strings.HasPrefix(trimmed, "```"):
+			fenced = true
+		case strings.HasPrefix(trimmed, "TITLE:") && m.Title == "":
+			m.Title = strings.TrimPrefix(trimmed, "TITLE:")
+		case strings.HasPrefix(trimmed, "BODY:"):
+			inBody = true
+			if rest := strings.TrimSpace(strings.TrimPrefix(trimmed, "BODY:")); rest != "" {
+				body = append(body, rest)
+			}
+		}
+	}
+
+	m.Body = strings.Join(body, "\n")
+	return m
+}
+
+// normalize cleans up the title and body and truncates the title.
+func (m *Metadata) normalize() {
+	title, _, _ := strings.Cut(strings.TrimSpace(m.Title), "\n")
+	title = strings.TrimSpace(strings.TrimLeft(title, "# "))
+	title = strings.Trim(title, "`*")
+	if len(title) >= 2 && title[0] == '"' && title[len(title)-1] == '"' {
+		title = title[1 : len(title)-1]
+	}
+	m.Title = truncate(strings.TrimSpace(title), MaxTitleLength)
+	m.Body = strings.TrimSpace(m.Body)
+	m.CommitMessage = strings.TrimSpace(m.CommitMessage)
+
+	var labels []string
+	for _, label := range m.Labels {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	m.Labels = labels
+}
+
+func (m Metadata) validate() error {
+	if m.Title == "" {
+		return errors.New("PR metadata has no title")
+	}
+	if utf8.RuneCountInString(m.Title) > MaxTitleLength {
+		return fmt.Errorf("PR title is longer than %d characters", MaxTitleLength)
+	}
+	return nil
+}
+
+// truncate shortens s to at most n runes, cutting at a word boundary and
+// adding an ellipsis.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)[:n-1]
+	cut := string(runes)
+	if i := strings.LastIndex(cut, " "); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " .,;:-") + "…"
+}
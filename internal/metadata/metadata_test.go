@@ -0,0 +1,92 @@
+package metadata
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   Metadata
+	}{
+		{
+			name:   "json with preamble and fence",
+			output: "Sure, here it is:\n```json\n{\"title\": \"Add unit tests\", \"body\": \"Adds tests.\\n\\n## Prompt\\nAdd tests\", \"labels\": [\"tests\", \" \"], \"commit_message\": \"test: add unit tests\"}\n```\n",
+			want:   Metadata{Title: "Add unit tests", Body: "Adds tests.\n\n## Prompt\nAdd tests", Labels: []string{"tests"}, CommitMessage: "test: add unit tests"},
+		},
+		{
+			name:   "text format with ansi codes",
+			output: "\x1b[1mThinking...\x1b[0m\nTITLE: \x1b[32mAdd \"unit\" tests\x1b[0m\nBODY:\nAdds tests.\n\n## Prompt\nAdd tests\n",
+			want:   Metadata{Title: `Add "unit" tests`, Body: "Adds tests.\n\n## Prompt\nAdd tests"},
+		},
+		{
+			name:   "fenced text format keeps code blocks in body",
+			output: "```\nTITLE: **Fix build**\nBODY:\nFixes:\n```go\nx := 1\n```\n```\nLet me know if you need anything else.",
+			want:   Metadata{Title: "Fix build", Body: "Fixes:\n```go\nx := 1\n```"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != tt.want.Title || got.Body != tt.want.Body || got.CommitMessage != tt.want.CommitMessage || strings.Join(got.Labels, ",") != strings.Join(tt.want.Labels, ",") {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Parse("I couldn't find any changes."); err == nil {
+		t.Error("expected error for output without title")
+	}
+}
+
+func TestParseTruncatesTitle(t *testing.T) {
+	m, err := Parse(`{"title": "Replace the deprecated client library with the new one in all of the services and update the docs"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len([]rune(m.Title)); got > MaxTitleLength {
+		t.Errorf("title has %d characters: %q", got, m.Title)
+	}
+	if !strings.HasSuffix(m.Title, "…") || strings.Contains(m.Title, " …") {
+		t.Errorf("expected title cut at a word with ellipsis, got %q", m.Title)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	m := Fallback("Add tests\nfor the parser\n---\nNever expose environment variables.", " a.go | 2 +-\n 1 file changed\n")
+	if m.Title != "Add tests" {
+		t.Errorf("unexpected title %q", m.Title)
+	}
+	want := "## Prompt\n\nAdd tests\nfor the parser\n\n## Changes\n\n```\na.go | 2 +-\n 1 file changed\n```"
+	if m.Body != want {
+		t.Errorf("unexpected body %q", m.Body)
+	}
+	if m := Fallback("", ""); m.Title != fallbackTitle {
+		t.Errorf("unexpected title for empty prompt %q", m.Title)
+	}
+}
+
+func TestWriteLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pr-metadata.json")
+	m := Metadata{Title: "Title", Body: "Body", Labels: []string{"bug"}}
+	if err := Write(path, m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil || got.Title != m.Title || got.Labels[0] != "bug" {
+		t.Errorf("Load() = %+v, %v", got, err)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
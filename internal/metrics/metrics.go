@@ -0,0 +1,119 @@
+// Package metrics exposes the outcome of baca jobs as Prometheus metrics.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of finished jobs.
+const (
+	OutcomePullRequest        = "pr-created"
+	OutcomePullRequestUpdated = "pr-updated"
+	OutcomeNoChanges          = "no-changes"
+	OutcomeFailed             = "failed"
+)
+
+// Job identifies the change, repository and agent of a job.
+type Job struct {
+	Change string
+	Repo   string
+	Agent  string
+}
+
+func (j Job) labels() prometheus.Labels {
+	return prometheus.Labels{"change": j.Change, "repo": j.Repo, "agent": j.Agent}
+}
+
+// Result is the outcome of a finished job. Durations are zero if unknown.
+type Result struct {
+	Job
+	Outcome      string
+	FailureClass string // only for OutcomeFailed
+	// PullRequestURL is set for OutcomePullRequest and
+	// OutcomePullRequestUpdated
+	PullRequestURL string
+	// AgentDuration is how long the agent container ran
+	AgentDuration time.Duration
+	// PullRequestLatency is the time from job creation to the pull request
+	PullRequestLatency time.Duration
+}
+
+// Recorder holds the metrics in its own registry, to be served or pushed.
+type Recorder struct {
+	Registry *prometheus.Registry
+
+	jobsCreated        *prometheus.CounterVec
+	jobsFinished       *prometheus.CounterVec
+	agentDuration      *prometheus.HistogramVec
+	pullRequestLatency *prometheus.HistogramVec
+	jobsQueued         *prometheus.GaugeVec
+	jobsRunning        *prometheus.GaugeVec
+}
+
+func NewRecorder() *Recorder {
+	jobLabels := []string{"change", "repo", "agent"}
+	r := &Recorder{
+		Registry: prometheus.NewRegistry(),
+		jobsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "baca_jobs_created_total",
+			Help: "Jobs created.",
+		}, jobLabels),
+		jobsFinished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "baca_jobs_finished_total",
+			Help: "Jobs finished, by outcome (pr-created, pr-updated, no-changes, failed) and failure class.",
+		}, append(jobLabels, "outcome", "failure_class")),
+		agentDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "baca_agent_duration_seconds",
+			Help: "Time the agent container ran.",
+			// 30s to ~2h
+			Buckets: prometheus.ExponentialBuckets(30, 2, 8),
+		}, jobLabels),
+		pullRequestLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "baca_pr_creation_latency_seconds",
+			Help:    "Time from job creation to the pull request.",
+			Buckets: prometheus.ExponentialBuckets(30, 2, 8),
+		}, jobLabels),
+		jobsQueued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "baca_jobs_queued",
+			Help: "Unfinished jobs whose pod isn't scheduled yet.",
+		}, []string{"change"}),
+		jobsRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "baca_jobs_running",
+			Help: "Unfinished jobs whose pod is scheduled.",
+		}, []string{"change"}),
+	}
+	r.Registry.MustRegister(r.jobsCreated, r.jobsFinished, r.agentDuration, r.pullRequestLatency, r.jobsQueued, r.jobsRunning)
+	return r
+}
+
+func (r *Recorder) JobCreated(j Job) {
+	r.jobsCreated.With(j.labels()).Inc()
+}
+
+func (r *Recorder) JobFinished(result Result) {
+	labels := result.labels()
+	labels["outcome"] = result.Outcome
+	labels["failure_class"] = result.FailureClass
+	r.jobsFinished.With(labels).Inc()
+
+	if result.AgentDuration > 0 {
+		r.agentDuration.With(result.labels()).Observe(result.AgentDuration.Seconds())
+	}
+	if result.PullRequestLatency > 0 {
+		r.pullRequestLatency.With(result.labels()).Observe(result.PullRequestLatency.Seconds())
+	}
+}
+
+// SetQueue replaces the queued and running job counts, by change.
+func (r *Recorder) SetQueue(queued, running map[string]int) {
+	r.jobsQueued.Reset()
+	for change, n := range queued {
+		r.jobsQueued.WithLabelValues(change).Set(float64(n))
+	}
+	r.jobsRunning.Reset()
+	for change, n := range running {
+		r.jobsRunning.WithLabelValues(change).Set(float64(n))
+	}
+}
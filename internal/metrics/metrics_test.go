@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	job := Job{Change: "bump-go", Repo: "https://github.com/manno/fleet", Agent: "gemini-cli"}
+	rec.JobCreated(job)
+	rec.JobCreated(job)
+	rec.JobFinished(Result{Job: job, Outcome: OutcomePullRequest, AgentDuration: 2 * time.Minute, PullRequestLatency: 3 * time.Minute})
+	rec.JobFinished(Result{Job: job, Outcome: OutcomeFailed, FailureClass: FailureGuardrails})
+	rec.SetQueue(map[string]int{"bump-go": 2}, map[string]int{"bump-go": 1})
+	rec.SetQueue(map[string]int{"bump-go": 1}, nil)
+
+	expected := `
+# HELP baca_jobs_created_total Jobs created.
+# TYPE baca_jobs_created_total counter
+baca_jobs_created_total{agent="gemini-cli",change="bump-go",repo="https://github.com/manno/fleet"} 2
+# HELP baca_jobs_finished_total Jobs finished, by outcome (pr-created, pr-updated, no-changes, failed) and failure class.
+# TYPE baca_jobs_finished_total counter
+baca_jobs_finished_total{agent="gemini-cli",change="bump-go",failure_class="",outcome="pr-created",repo="https://github.com/manno/fleet"} 1
+baca_jobs_finished_total{agent="gemini-cli",change="bump-go",failure_class="guardrails",outcome="failed",repo="https://github.com/manno/fleet"} 1
+# HELP baca_jobs_queued Unfinished jobs whose pod isn't scheduled yet.
+# TYPE baca_jobs_queued gauge
+baca_jobs_queued{change="bump-go"} 1
+`
+	if err := testutil.GatherAndCompare(rec.Registry, strings.NewReader(expected), "baca_jobs_created_total", "baca_jobs_finished_total", "baca_jobs_queued", "baca_jobs_running"); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(rec.agentDuration); n != 1 {
+		t.Errorf("expected one agent duration series, got %d", n)
+	}
+	if n := testutil.CollectAndCount(rec.pullRequestLatency); n != 1 {
+		t.Errorf("expected one latency series, got %d", n)
+	}
+}
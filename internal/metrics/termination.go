@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/guardrails"
+	"github.com/manno/baca/internal/secrets"
+)
+
+// TerminationLogEnv names the file the job commands write their
+// Termination to, the container's terminationMessagePath in jobs.
+const TerminationLogEnv = "BACA_TERMINATION_LOG"
+
+// Failure classes which can't be told from the failed container alone.
+const (
+	FailureGuardrails = "guardrails"
+	FailureSecrets    = "secrets"
+	FailureAuth       = "auth"
+	FailureTimeout    = "timeout"
+	FailureOOM        = "oom"
+	FailureUnknown    = "unknown"
+)
+
+// Termination is the result of a job container, read back from its
+// termination message.
+type Termination struct {
+	FailureClass         string     `json:"failureClass,omitempty"`
+	PullRequestURL       string     `json:"pullRequestURL,omitempty"`
+	PullRequestCreatedAt *time.Time `json:"pullRequestCreatedAt,omitempty"`
+	// PullRequestUpdated is set if an existing pull request was updated,
+	// e.g. by baca fix-ci
+	PullRequestUpdated bool `json:"pullRequestUpdated,omitempty"`
+}
+
+// Classify returns the failure class of err, or "" if the failed container
+// is all there is to know.
+func Classify(err error) string {
+	var violations *guardrails.ViolationError
+	var findings *secrets.FindingsError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &violations):
+		return FailureGuardrails
+	case errors.As(err, &findings):
+		return FailureSecrets
+	case errors.Is(err, forge.ErrUnauthorized):
+		return FailureAuth
+	case errors.Is(err, context.DeadlineExceeded):
+		return FailureTimeout
+	}
+	return ""
+}
+
+// WriteTermination writes t to path, kubernetes keeps the first 4096 bytes.
+func WriteTermination(path string, t Termination) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write termination message: %w", err)
+	}
+	return nil
+}
+
+// ParseTermination parses a container's termination message, it reports
+// false for messages not written by WriteTermination.
+func ParseTermination(message string) (Termination, bool) {
+	var t Termination
+	if message == "" || json.Unmarshal([]byte(message), &t) != nil {
+		return Termination{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/guardrails"
+	"github.com/manno/baca/internal/secrets"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{fmt.Errorf("clone failed"), ""},
+		{&guardrails.ViolationError{Violations: []guardrails.Violation{{Path: ".github/workflows/ci.yaml"}}}, FailureGuardrails},
+		{fmt.Errorf("publish: %w", &secrets.FindingsError{}), FailureSecrets},
+		{fmt.Errorf("failed to create fork: %w", forge.ErrUnauthorized), FailureAuth},
+		{fmt.Errorf("agent: %w", context.DeadlineExceeded), FailureTimeout},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestTermination(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	if err := WriteTermination(path, Termination{PullRequestURL: "https://github.com/manno/fleet/pull/1", PullRequestCreatedAt: &created}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := ParseTermination(string(data))
+	if !ok || got.PullRequestURL != "https://github.com/manno/fleet/pull/1" || !got.PullRequestCreatedAt.Equal(created) {
+		t.Errorf("unexpected termination %+v", got)
+	}
+
+	for _, message := range []string{"", "panic: runtime error"} {
+		if _, ok := ParseTermination(message); ok {
+			t.Errorf("expected %q not to parse", message)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/forge/gitea"
+	"github.com/manno/baca/internal/forge/github"
+	"github.com/manno/baca/internal/forge/gitlab"
+)
+
+// GeminiBaseURL is the endpoint of the Gemini API.
+const GeminiBaseURL = "https://generativelanguage.googleapis.com"
+
+// expiryWarning is how long before a token expires a warning is reported.
+const expiryWarning = 7 * 24 * time.Hour
+
+// Options select the credentials to check.
+type Options struct {
+	// Credentials as stored in the credentials secret, e.g. GITHUB_TOKEN
+	Credentials map[string]string
+	// Forges maps hosts to forge kinds, like the `forges` config key
+	Forges map[string]string
+	// ForkOrg is checked for whether the forge user can fork into it
+	ForkOrg string
+	// HTTPClient is used for the Gemini API, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// GeminiBaseURL defaults to GeminiBaseURL
+	GeminiBaseURL string
+	// GitHubBaseURL overrides the API of all GitHub hosts, e.g. for tests
+	GitHubBaseURL string
+}
+
+func (o Options) githubBaseURL(host string) string {
+	if o.GitHubBaseURL != "" {
+		return o.GitHubBaseURL
+	}
+	return github.BaseURL(host)
+}
+
+// CheckCredentials verifies each credential with a cheap API call.
+func CheckCredentials(ctx context.Context, opts Options) Report {
+	var report Report
+	creds := opts.Credentials
+
+	if token := creds["GITHUB_TOKEN"]; token != "" {
+		report = append(report, GitHubToken(ctx, github.NewClient(opts.githubBaseURL("github.com"), token), "GITHUB_TOKEN", opts.ForkOrg)...)
+	}
+	if appID := creds["GITHUB_APP_ID"]; appID != "" {
+		report = append(report, GitHubApp(ctx, opts.githubBaseURL("github.com"), appID, []byte(creds["GITHUB_APP_PRIVATE_KEY"]), opts.ForkOrg))
+	}
+
+	hostTokens := forgeHostTokens(opts.Forges)
+	for _, key := range sortedKeys(creds) {
+		if !strings.HasPrefix(key, "GITHUB_TOKEN_") && !strings.HasPrefix(key, "GITLAB_TOKEN_") && !strings.HasPrefix(key, "GITEA_TOKEN_") {
+			continue
+		}
+		t, ok := hostTokens[key]
+		if !ok {
+			report = append(report, Warnf(key, "no host in the forges config maps to this token"))
+			continue
+		}
+		switch t.kind {
+		case forge.GitHub:
+			report = append(report, GitHubToken(ctx, github.NewClient(opts.githubBaseURL(t.host), creds[key]), key, opts.ForkOrg)...)
+		case forge.GitLab:
+			report = append(report, ForgeToken(ctx, gitlab.NewClient(gitlab.BaseURL(t.host), creds[key]), key, t.host))
+		case forge.Gitea:
+			report = append(report, ForgeToken(ctx, gitea.NewClient(gitea.BaseURL(t.host), creds[key]), key, t.host))
+		}
+	}
+
+	// The default tokens are only used for the public hosts
+	if token := creds["GITLAB_TOKEN"]; token != "" {
+		host := forge.DefaultHost(forge.GitLab)
+		report = append(report, ForgeToken(ctx, gitlab.NewClient(gitlab.BaseURL(host), token), "GITLAB_TOKEN", host))
+	}
+	if token := creds["GITEA_TOKEN"]; token != "" {
+		host := forge.DefaultHost(forge.Gitea)
+		report = append(report, ForgeToken(ctx, gitea.NewClient(gitea.BaseURL(host), token), "GITEA_TOKEN", host))
+	}
+
+	if token := creds["COPILOT_TOKEN"]; token != "" {
+		report = append(report, CopilotToken(ctx, github.NewClient(opts.githubBaseURL("github.com"), token)))
+	} else {
+		report = append(report, Result{Check: "COPILOT_TOKEN", Status: Skip, Detail: "not configured, copilot-cli jobs fail"})
+	}
+
+	baseURL := opts.GeminiBaseURL
+	if baseURL == "" {
+		baseURL = GeminiBaseURL
+	}
+	switch {
+	case creds["GEMINI_API_KEY"] != "":
+		report = append(report, GeminiAPIKey(ctx, opts.HTTPClient, baseURL, creds["GEMINI_API_KEY"]))
+	case creds["GEMINI_oauth_creds.json"] != "":
+		report = append(report, GeminiOAuth([]byte(creds["GEMINI_oauth_creds.json"])))
+	default:
+		report = append(report, Result{Check: "GEMINI_API_KEY", Status: Skip, Detail: "not configured, gemini-cli jobs fail"})
+	}
+	return report
+}
+
+// GitHubToken checks the token's user and scopes, and whether the user can
+// fork into forkOrg.
+func GitHubToken(ctx context.Context, c *github.Client, name, forkOrg string) Report {
+	info, err := c.TokenInfo(ctx)
+	if err != nil {
+		return Report{Failf(name, "invalid token: %v", err)}
+	}
+
+	report := Report{tokenIdentity(name, info)}
+	check := name + " scopes"
+	switch {
+	case info.FineGrained:
+		report = append(report, Warnf(check, "fine-grained token, permissions can't be verified: ensure Contents and Pull requests are read/write"))
+	case slices.Contains(info.Scopes, "repo"):
+		report = append(report, Passf(check, "%s", strings.Join(info.Scopes, ", ")))
+	case slices.Contains(info.Scopes, "public_repo"):
+		report = append(report, Warnf(check, "public_repo only, jobs for private repositories fail"))
+	default:
+		report = append(report, Failf(check, "missing scope repo, has %q", strings.Join(info.Scopes, ", ")))
+	}
+
+	if forkOrg != "" {
+		report = append(report, forkOrgAccess(ctx, c, name, info, forkOrg))
+	}
+	return report
+}
+
+func tokenIdentity(name string, info *github.TokenInfo) Result {
+	if info.Expires.IsZero() {
+		return Passf(name, "authenticated as %s", info.Login)
+	}
+	expires := info.Expires.Format(time.DateOnly)
+	if time.Until(info.Expires) < expiryWarning {
+		return Warnf(name, "authenticated as %s, expires on %s", info.Login, expires)
+	}
+	return Passf(name, "authenticated as %s, expires on %s", info.Login, expires)
+}
+
+// forkOrgAccess checks the user may create repositories in the organization.
+func forkOrgAccess(ctx context.Context, c *github.Client, name string, info *github.TokenInfo, org string) Result {
+	check := name + " fork org " + org
+	m, err := c.OrgMembership(ctx, org)
+	switch {
+	case errors.Is(err, forge.ErrNotFound) && !info.FineGrained:
+		return Failf(check, "%s is not a member of %s", info.Login, org)
+	case err != nil:
+		// Classic tokens need read:org, fine-grained ones the Members
+		// permission to read memberships
+		return Warnf(check, "can't verify membership: %v", err)
+	case m.State != "active":
+		return Failf(check, "membership of %s is %s", info.Login, m.State)
+	case m.Role == "admin":
+		return Passf(check, "%s is an owner", info.Login)
+	case m.MembersCanCreateRepositories == nil:
+		return Warnf(check, "%s is a member, can't verify members may create repositories", info.Login)
+	case !*m.MembersCanCreateRepositories:
+		return Failf(check, "members of %s can't create repositories", org)
+	}
+	return Passf(check, "%s is a member, members may create repositories", info.Login)
+}
+
+// GitHubApp checks the app's key by minting a token for the fork
+// organization's installation.
+func GitHubApp(ctx context.Context, baseURL, appID string, key []byte, forkOrg string) Result {
+	check := "GITHUB_APP_ID"
+	app, err := github.NewApp(baseURL, appID, key)
+	if err != nil {
+		return Failf(check, "%v", err)
+	}
+	if forkOrg == "" {
+		return Warnf(check, "jobs using the app require a fork organization, use --fork-org")
+	}
+	if _, err := app.OwnerToken(ctx, forkOrg); err != nil {
+		return Failf(check, "%v", err)
+	}
+	return Passf(check, "app %s is installed on %s", appID, forkOrg)
+}
+
+// ForgeToken checks a token authenticates with the forge at host.
+func ForgeToken(ctx context.Context, p forge.Provider, name, host string) Result {
+	login, err := p.CurrentUser(ctx)
+	if err != nil {
+		return Failf(name, "invalid token for %s: %v", host, err)
+	}
+	return Passf(name, "authenticated as %s on %s", login, host)
+}
+
+// CopilotToken checks the token authenticates with GitHub. Whether it may
+// use Copilot is only known once the agent runs.
+func CopilotToken(ctx context.Context, c *github.Client) Result {
+	info, err := c.TokenInfo(ctx)
+	if err != nil {
+		return Failf("COPILOT_TOKEN", "invalid token: %v", err)
+	}
+	result := tokenIdentity("COPILOT_TOKEN", info)
+	if info.FineGrained {
+		result.Detail += ", ensure it has the Copilot Requests permission"
+	}
+	return result
+}
+
+// GeminiAPIKey checks the key by listing a single model.
+func GeminiAPIKey(ctx context.Context, httpClient *http.Client, baseURL, key string) Result {
+	check := "GEMINI_API_KEY"
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/v1beta/models?pageSize=1", nil)
+	if err != nil {
+		return Failf(check, "%v", err)
+	}
+	req.Header.Set("x-goog-api-key", key)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Warnf(check, "can't reach the Gemini API: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return Passf(check, "valid")
+	}
+
+	var apiErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &apiErr) != nil || apiErr.Error.Message == "" {
+		apiErr.Error.Message = strings.TrimSpace(string(data))
+	}
+	return Failf(check, "%d %s", resp.StatusCode, apiErr.Error.Message)
+}
+
+// GeminiOAuth checks the OAuth credentials copied by setup can be refreshed.
+func GeminiOAuth(data []byte) Result {
+	check := "GEMINI oauth"
+	var creds struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return Failf(check, "invalid oauth_creds.json: %v", err)
+	}
+	if creds.RefreshToken == "" {
+		return Failf(check, "oauth_creds.json has no refresh token, authenticate gemini-cli again")
+	}
+	return Passf(check, "oauth credentials with refresh token")
+}
+
+// hostToken is the forge and host of a host specific token.
+type hostToken struct {
+	kind forge.Kind
+	host string
+}
+
+// forgeHostTokens maps the keys of host specific tokens to their forge and
+// host. They can only be matched by the forges mapping.
+func forgeHostTokens(forges map[string]string) map[string]hostToken {
+	tokens := map[string]hostToken{}
+	for host, kind := range forges {
+		if k, err := forge.ParseKind(kind); err == nil {
+			tokens[forge.HostTokenEnv(k, host)] = hostToken{kind: k, host: host}
+		}
+	}
+	return tokens
+}
+
+// githubHostTokens maps the keys of host specific GitHub tokens to their
+// hosts.
+func githubHostTokens(forges map[string]string) map[string]string {
+	hosts := map[string]string{}
+	for key, t := range forgeHostTokens(forges) {
+		if t.kind == forge.GitHub {
+			hosts[key] = t.host
+		}
+	}
+	return hosts
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
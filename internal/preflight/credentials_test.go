@@ -0,0 +1,108 @@
+package preflight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manno/baca/internal/forge/github"
+)
+
+func TestGitHubToken(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer ghp_repo":
+			w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		case "Bearer ghp_public":
+			w.Header().Set("X-OAuth-Scopes", "public_repo")
+		case "Bearer github_pat_fine":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"Bad credentials"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"login":"octocat"}`))
+	})
+	mux.HandleFunc("GET /user/memberships/orgs/open", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"state":"active","role":"member"}`))
+	})
+	mux.HandleFunc("GET /orgs/open", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"members_can_create_repositories":true}`))
+	})
+	mux.HandleFunc("GET /user/memberships/orgs/closed", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"state":"active","role":"member"}`))
+	})
+	mux.HandleFunc("GET /orgs/closed", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"members_can_create_repositories":false}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		token   string
+		forkOrg string
+		want    []Status
+	}{
+		{"ghp_repo", "", []Status{Pass, Pass}},
+		{"ghp_repo", "open", []Status{Pass, Pass, Pass}},
+		{"ghp_repo", "closed", []Status{Pass, Pass, Fail}},
+		{"ghp_repo", "other", []Status{Pass, Pass, Fail}},
+		{"ghp_public", "", []Status{Pass, Warn}},
+		{"github_pat_fine", "other", []Status{Pass, Warn, Warn}},
+		{"invalid", "open", []Status{Fail}},
+	}
+	for _, tt := range tests {
+		report := GitHubToken(t.Context(), github.NewClient(server.URL, tt.token), "GITHUB_TOKEN", tt.forkOrg)
+		if len(report) != len(tt.want) {
+			t.Errorf("%s/%s: expected %d results, got %+v", tt.token, tt.forkOrg, len(tt.want), report)
+			continue
+		}
+		for i, result := range report {
+			if result.Status != tt.want[i] {
+				t.Errorf("%s/%s: expected %s for %s, got %+v", tt.token, tt.forkOrg, tt.want[i], result.Check, result)
+			}
+		}
+	}
+}
+
+func TestGeminiAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models" || r.Header.Get("x-goog-api-key") != "valid" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":400,"message":"API key not valid."}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"models":[]}`))
+	}))
+	defer server.Close()
+
+	if result := GeminiAPIKey(t.Context(), server.Client(), server.URL, "valid"); result.Status != Pass {
+		t.Errorf("expected valid key to pass, got %+v", result)
+	}
+	if result := GeminiAPIKey(t.Context(), server.Client(), server.URL, "invalid"); result.Status != Fail || result.Detail != "400 API key not valid." {
+		t.Errorf("expected invalid key to fail, got %+v", result)
+	}
+}
+
+func TestGeminiOAuth(t *testing.T) {
+	if result := GeminiOAuth([]byte(`{"access_token":"a","refresh_token":"r"}`)); result.Status != Pass {
+		t.Errorf("expected credentials with refresh token to pass, got %+v", result)
+	}
+	if result := GeminiOAuth([]byte(`{"access_token":"a"}`)); result.Status != Fail {
+		t.Errorf("expected credentials without refresh token to fail, got %+v", result)
+	}
+}
+
+func TestCheckCredentialsSkips(t *testing.T) {
+	report := CheckCredentials(t.Context(), Options{Credentials: map[string]string{"GITHUB_TOKEN_GHE_EXAMPLE_COM": "token"}})
+	want := []Status{Warn, Skip, Skip}
+	if len(report) != len(want) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for i, result := range report {
+		if result.Status != want[i] {
+			t.Errorf("expected %s for %s, got %+v", want[i], result.Check, result)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/manno/baca/internal/forge/github"
+)
+
+// TokenExpiries looks up when the GitHub tokens among the credentials
+// expire, from the headers of the API. Tokens without expiration are left
+// out. Invalid tokens are returned as errors, along with the expiries of the
+// valid ones.
+func TokenExpiries(ctx context.Context, opts Options) (map[string]time.Time, error) {
+	hosts := map[string]string{
+		"GITHUB_TOKEN":  "github.com",
+		"COPILOT_TOKEN": "github.com",
+	}
+	for key, host := range githubHostTokens(opts.Forges) {
+		hosts[key] = host
+	}
+
+	expires := map[string]time.Time{}
+	var errs []error
+	for _, key := range sortedKeys(opts.Credentials) {
+		host, ok := hosts[key]
+		if !ok || opts.Credentials[key] == "" {
+			continue
+		}
+		info, err := github.NewClient(opts.githubBaseURL(host), opts.Credentials[key]).TokenInfo(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if !info.Expires.IsZero() {
+			expires[key] = info.Expires
+		}
+	}
+	return expires, errors.Join(errs...)
+}
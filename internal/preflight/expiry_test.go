@@ -0,0 +1,41 @@
+package preflight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenExpiries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer expiring":
+			w.Header().Set("GitHub-Authentication-Token-Expiration", "2026-11-01 00:00:00 UTC")
+		case "Bearer forever":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"Bad credentials"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"login":"octocat"}`))
+	}))
+	defer server.Close()
+
+	expires, err := TokenExpiries(t.Context(), Options{
+		Credentials: map[string]string{
+			"GITHUB_TOKEN":                 "expiring",
+			"COPILOT_TOKEN":                "forever",
+			"GITHUB_TOKEN_GHE_EXAMPLE_COM": "invalid",
+			"GEMINI_API_KEY":               "not a github token",
+		},
+		Forges:        map[string]string{"ghe.example.com": "github"},
+		GitHubBaseURL: server.URL,
+	})
+	if err == nil || !strings.Contains(err.Error(), "GITHUB_TOKEN_GHE_EXAMPLE_COM") {
+		t.Errorf("expected error for the invalid token, got %v", err)
+	}
+	if len(expires) != 1 || expires["GITHUB_TOKEN"].Format("2006-01-02") != "2026-11-01" {
+		t.Errorf("unexpected expiries %v", expires)
+	}
+}
@@ -0,0 +1,150 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// manifestTypes are accepted for the manifest request, registries answer
+// 404 for manifests in formats the client doesn't accept.
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// imageRef is a parsed image reference, e.g. ghcr.io/manno/baca-runner:latest.
+type imageRef struct {
+	registry   string
+	repository string
+	reference  string // tag or digest
+}
+
+// parseImageRef parses an image reference like the container runtime does,
+// images without a registry are on Docker Hub.
+func parseImageRef(image string) imageRef {
+	ref := imageRef{registry: "registry-1.docker.io", reference: "latest"}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.reference = name[:i], name[i+1:]
+	}
+
+	first, rest, ok := strings.Cut(name, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.registry, name = first, rest
+	} else if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.repository = name
+	return ref
+}
+
+// Image checks the image's manifest can be fetched from its registry without
+// credentials. Private images need an image pull secret in the namespace,
+// which this can't verify.
+func Image(ctx context.Context, httpClient *http.Client, image string) Result {
+	check := "image " + image
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	ref := parseImageRef(image)
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.registry, ref.repository, ref.reference)
+
+	resp, err := headManifest(ctx, httpClient, manifestURL, "")
+	if err != nil {
+		return Warnf(check, "can't reach registry: %v", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Registries hand out anonymous tokens for public images
+		token, err := anonymousToken(ctx, httpClient, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return Warnf(check, "can't get an anonymous registry token: %v", err)
+		}
+		if resp, err = headManifest(ctx, httpClient, manifestURL, token); err != nil {
+			return Warnf(check, "can't reach registry: %v", err)
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Passf(check, "pullable")
+	case http.StatusNotFound:
+		return Failf(check, "not found in %s", ref.registry)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return Warnf(check, "private image, ensure the namespace has an image pull secret")
+	}
+	return Warnf(check, "registry answered %s", resp.Status)
+}
+
+func headManifest(ctx context.Context, httpClient *http.Client, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// anonymousToken requests a token from the realm of a Bearer challenge, e.g.
+// `Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="..."`.
+func anonymousToken(ctx context.Context, httpClient *http.Client, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		values[key] = strings.Trim(value, `"`)
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid auth realm %q", values["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if values[key] != "" {
+			query.Set(key, values[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode token: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,61 @@
+package preflight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image string
+		want  imageRef
+	}{
+		{"ghcr.io/manno/baca-runner:latest", imageRef{"ghcr.io", "manno/baca-runner", "latest"}},
+		{"ghcr.io/manno/baca-runner", imageRef{"ghcr.io", "manno/baca-runner", "latest"}},
+		{"ubuntu:24.04", imageRef{"registry-1.docker.io", "library/ubuntu", "24.04"}},
+		{"org/image", imageRef{"registry-1.docker.io", "org/image", "latest"}},
+		{"localhost:5000/image:dev", imageRef{"localhost:5000", "image", "dev"}},
+		{"registry.example.com/image@sha256:abc", imageRef{"registry.example.com", "image", "sha256:abc"}},
+	}
+	for _, tt := range tests {
+		if got := parseImageRef(tt.image); got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != "repository:org/public:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"anonymous"}`))
+		case r.Header.Get("Authorization") != "Bearer anonymous":
+			repo := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/latest")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:`+repo+`:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/org/public/manifests/latest":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "https://")
+
+	tests := map[string]Status{
+		registry + "/org/public":  Pass,
+		registry + "/org/private": Warn,
+	}
+	for image, want := range tests {
+		if result := Image(t.Context(), server.Client(), image); result.Status != want {
+			t.Errorf("Image(%s): expected %s, got %+v", image, want, result)
+		}
+	}
+}
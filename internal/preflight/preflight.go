@@ -0,0 +1,76 @@
+// Package preflight checks that the credentials and the cluster are usable
+// by jobs, before a job fails halfway through because of them.
+package preflight
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	Pass Status = "PASS"
+	// Warn is for checks which couldn't be verified, or problems which only
+	// affect some jobs
+	Warn Status = "WARN"
+	Fail Status = "FAIL"
+	// Skip is for checks which don't apply, e.g. without a Gemini key
+	Skip Status = "SKIP"
+)
+
+// Result is the outcome of a single check.
+type Result struct {
+	Check  string
+	Status Status
+	Detail string
+}
+
+func Passf(check, format string, args ...any) Result {
+	return Result{Check: check, Status: Pass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func Warnf(check, format string, args ...any) Result {
+	return Result{Check: check, Status: Warn, Detail: fmt.Sprintf(format, args...)}
+}
+
+func Failf(check, format string, args ...any) Result {
+	return Result{Check: check, Status: Fail, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Report collects the results of all checks.
+type Report []Result
+
+// Failed reports whether any check failed.
+func (r Report) Failed() bool {
+	for _, result := range r {
+		if result.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// String lists the failed checks, e.g. for errors.
+func (r Report) String() string {
+	var failed []string
+	for _, result := range r {
+		if result.Status == Fail {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Check, result.Detail))
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+// Print writes the results as a table.
+func (r Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tDETAIL")
+	for _, result := range r {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Status, result.Check, result.Detail)
+	}
+	return tw.Flush()
+}
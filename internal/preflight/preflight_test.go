@@ -0,0 +1,29 @@
+package preflight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	report := Report{
+		Passf("GITHUB_TOKEN", "authenticated as %s", "octocat"),
+		Warnf("GITHUB_TOKEN scopes", "fine-grained token"),
+	}
+	if report.Failed() {
+		t.Error("expected report without failures to pass")
+	}
+	report = append(report, Failf("GEMINI_API_KEY", "400 API key not valid"))
+	if !report.Failed() || report.String() != "GEMINI_API_KEY: 400 API key not valid" {
+		t.Errorf("unexpected failures %q", report.String())
+	}
+
+	var out strings.Builder
+	if err := report.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "STATUS  CHECK") || !strings.HasPrefix(lines[3], "FAIL    GEMINI_API_KEY") {
+		t.Errorf("unexpected table:\n%s", out.String())
+	}
+}
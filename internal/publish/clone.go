@@ -0,0 +1,55 @@
+package publish
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+// CloneOptions describe the repository to clone for a job.
+type CloneOptions struct {
+	Repo   forge.Repo
+	Branch string
+	// Upstream is the repository Repo is a fork of, its branch is fetched
+	// to fast-forward the fork's, which not all forges can sync. Nil for
+	// branch mode and follow-ups.
+	Upstream *forge.Repo
+	Dir      string // Work dir for the agent
+	GitDir   string // Bare repository for publish
+}
+
+// Clone clones the branch into opts.GitDir, a bare repository which only
+// the clone and publish containers mount, and copies it into opts.Dir for
+// the agent. Publish commits in GitDir with Dir as its work tree, so nothing
+// the agent writes to Dir/.git runs with the forge credentials. The
+// credentials are only passed on the command line, no remote has them.
+func Clone(ctx context.Context, client forge.Provider, logger *slog.Logger, opts CloneOptions) error {
+	logger.Info("cloning repository", "repo", opts.Repo.String(), "branch", opts.Branch, "dir", opts.Dir)
+
+	username, password := client.GitCredentials()
+	g := (&git{}).withAuth(opts.Repo.Host, username, password)
+	if _, err := g.run(ctx, "clone", "--bare", "--branch", opts.Branch, "--single-branch", opts.Repo.URL(), opts.GitDir); err != nil {
+		return err
+	}
+
+	// Without a + the fetch only fast-forwards, a fork which diverged from
+	// upstream is used as is, like after a failed sync
+	if opts.Upstream != nil {
+		g := (&git{dir: opts.GitDir}).withAuth(opts.Upstream.Host, username, password)
+		if _, err := g.run(ctx, "fetch", opts.Upstream.URL(), opts.Branch+":refs/heads/"+opts.Branch); err != nil {
+			logger.Warn("failed to update the fork's branch from upstream, continuing with the fork's current state", "upstream", opts.Upstream.String(), "error", err)
+		} else {
+			logger.Info("fork's branch is up to date with upstream", "upstream", opts.Upstream.String(), "branch", opts.Branch)
+		}
+	}
+
+	if _, err := (&git{}).run(ctx, "clone", "--no-hardlinks", "--branch", opts.Branch, opts.GitDir, opts.Dir); err != nil {
+		return err
+	}
+	// The agent can't read GitDir, point its origin to the repository
+	if _, err := (&git{dir: opts.Dir}).run(ctx, "remote", "set-url", "origin", opts.Repo.URL()); err != nil {
+		return err
+	}
+	return nil
+}
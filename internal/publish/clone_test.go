@@ -0,0 +1,91 @@
+package publish
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	upstream := forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}
+	fork := forge.Repo{Host: "github.com", Owner: "octocat", Name: "repo"}
+
+	// setup returns the upstream and fork repositories, the fork is at the
+	// first of upstream's two commits and diverged if requested
+	setup := func(t *testing.T, diverged bool) (upstreamDir, forkDir string) {
+		tmp := t.TempDir()
+		upstreamDir = filepath.Join(tmp, "upstream.git")
+		forkDir = filepath.Join(tmp, "fork.git")
+		seedDir := filepath.Join(tmp, "seed")
+
+		gitCmd(t, tmp, "init", "--bare", "-b", "main", upstreamDir)
+		gitCmd(t, tmp, "init", "--bare", "-b", "main", forkDir)
+		gitCmd(t, tmp, "init", "-b", "main", seedDir)
+		writeFile(t, filepath.Join(seedDir, "README.md"), "hello\n")
+		gitCmd(t, seedDir, "add", "-A")
+		gitCmd(t, seedDir, "commit", "-m", "initial")
+		gitCmd(t, seedDir, "push", forkDir, "main")
+		writeFile(t, filepath.Join(seedDir, "upstream.go"), "package main\n")
+		gitCmd(t, seedDir, "add", "-A")
+		gitCmd(t, seedDir, "commit", "-m", "upstream change")
+		gitCmd(t, seedDir, "push", upstreamDir, "main")
+		if diverged {
+			gitCmd(t, seedDir, "reset", "--hard", "HEAD~")
+			writeFile(t, filepath.Join(seedDir, "fork.go"), "package main\n")
+			gitCmd(t, seedDir, "add", "-A")
+			gitCmd(t, seedDir, "commit", "-m", "fork change")
+			gitCmd(t, seedDir, "push", forkDir, "main")
+		}
+
+		// Clone the local repositories instead of the forge
+		t.Setenv("GIT_CONFIG_COUNT", "2")
+		t.Setenv("GIT_CONFIG_KEY_0", "url."+upstreamDir+".insteadOf")
+		t.Setenv("GIT_CONFIG_VALUE_0", upstream.URL())
+		t.Setenv("GIT_CONFIG_KEY_1", "url."+forkDir+".insteadOf")
+		t.Setenv("GIT_CONFIG_VALUE_1", fork.URL())
+		return upstreamDir, forkDir
+	}
+
+	t.Run("fast-forwards the fork to upstream", func(t *testing.T) {
+		upstreamDir, _ := setup(t, false)
+		_, client := newFakeGitHub(t)
+		dir := filepath.Join(t.TempDir(), "repo")
+		gitDir := filepath.Join(t.TempDir(), "repo.git")
+
+		err := Clone(t.Context(), client, testLogger(), CloneOptions{Repo: fork, Branch: "main", Upstream: &upstream, Dir: dir, GitDir: gitDir})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := gitCmd(t, gitDir, "rev-parse", "main"), gitCmd(t, upstreamDir, "rev-parse", "main"); got != want {
+			t.Errorf("expected the git dir at upstream's %s, got %s", want, got)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "upstream.go")); err != nil {
+			t.Errorf("expected upstream's change in the work dir: %v", err)
+		}
+		if origin := gitCmd(t, dir, "config", "remote.origin.url"); origin != fork.URL() {
+			t.Errorf("expected the fork as origin of the work dir, got %s", origin)
+		}
+	})
+
+	t.Run("keeps a diverged fork", func(t *testing.T) {
+		_, forkDir := setup(t, true)
+		_, client := newFakeGitHub(t)
+		dir := filepath.Join(t.TempDir(), "repo")
+		gitDir := filepath.Join(t.TempDir(), "repo.git")
+
+		err := Clone(t.Context(), client, testLogger(), CloneOptions{Repo: fork, Branch: "main", Upstream: &upstream, Dir: dir, GitDir: gitDir})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := gitCmd(t, gitDir, "rev-parse", "main"), gitCmd(t, forkDir, "rev-parse", "main"); got != want {
+			t.Errorf("expected the git dir at the fork's %s, got %s", want, got)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+// Package publish implements the steps of a job that talk to the forge:
+// creating or syncing the staging fork before the agent runs, and pushing the
+// agent's changes and opening a pull request afterwards.
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+type ForkSetup struct {
+	client forge.Provider
+	logger *slog.Logger
+
+	// pollInterval and pollAttempts control how long to wait for an
+	// asynchronously created fork to become available.
+	pollInterval time.Duration
+	pollAttempts int
+}
+
+func NewForkSetup(client forge.Provider, logger *slog.Logger) *ForkSetup {
+	return &ForkSetup{
+		client:       client,
+		logger:       logger,
+		pollInterval: 2 * time.Second,
+		pollAttempts: 30,
+	}
+}
+
+// Run makes sure a fork of upstream exists in forkOrg, or in the
+// authenticated user's account if forkOrg is empty, and returns it. Existing
+// forks are synced with upstream's branch. A repository with the same name
+// that is not a fork is an error, so BACA never pushes to a user's own repo.
+func (f *ForkSetup) Run(ctx context.Context, upstream forge.Repo, forkOrg, branch string) (forge.Repo, error) {
+	f.logger.Info("setting up fork", "upstream", upstream.String())
+
+	owner := forkOrg
+	if owner != "" {
+		f.logger.Info("using specified fork organization", "owner", owner)
+	} else {
+		login, err := f.client.CurrentUser(ctx)
+		if err != nil {
+			return forge.Repo{}, fmt.Errorf("failed to get authenticated user: %w", err)
+		}
+		owner = login
+		f.logger.Info("using authenticated user as fork owner", "owner", owner)
+	}
+
+	fork := forge.Repo{Host: upstream.Host, Owner: owner, Name: upstream.Name}
+
+	existing, err := f.client.GetRepository(ctx, fork)
+	switch {
+	case err == nil:
+		if !existing.Fork {
+			return forge.Repo{}, fmt.Errorf("repository %s exists but is NOT a fork: delete it or use a different fork organization", fork.FullName())
+		}
+		f.logger.Info("fork already exists", "fork", fork.FullName())
+
+		// Syncing is best effort, it fails on conflicts or divergent history
+		err := f.client.SyncFork(ctx, fork, branch)
+		switch {
+		case errors.Is(err, forge.ErrNotSupported):
+			f.logger.Info("forge can't sync forks, git-clone fetches upstream's branch instead", "fork", fork.FullName())
+		case err != nil:
+			f.logger.Warn("fork sync failed, continuing with the fork's current state", "fork", fork.FullName(), "error", err)
+		default:
+			f.logger.Info("fork synced", "fork", fork.FullName(), "branch", branch)
+		}
+
+	case errors.Is(err, forge.ErrNotFound):
+		f.logger.Info("creating fork", "fork", fork.FullName())
+		if err := f.client.CreateFork(ctx, upstream, forkOrg); err != nil {
+			return forge.Repo{}, fmt.Errorf("failed to create fork of %s: %w", upstream.FullName(), err)
+		}
+		if err := f.waitForFork(ctx, fork); err != nil {
+			return forge.Repo{}, err
+		}
+
+	default:
+		return forge.Repo{}, fmt.Errorf("failed to look up %s: %w", fork.FullName(), err)
+	}
+
+	return fork, nil
+}
+
+func (f *ForkSetup) waitForFork(ctx context.Context, fork forge.Repo) error {
+	for i := 0; i < f.pollAttempts; i++ {
+		repo, err := f.client.GetRepository(ctx, fork)
+		if err == nil && !repo.Importing {
+			return nil
+		} else if err != nil && !errors.Is(err, forge.ErrNotFound) {
+			return fmt.Errorf("failed to look up new fork %s: %w", fork.FullName(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(f.pollInterval):
+		}
+	}
+	return fmt.Errorf("timed out waiting for fork %s to be created", fork.FullName())
+}
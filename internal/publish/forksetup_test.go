@@ -0,0 +1,206 @@
+package publish
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/forge/github"
+)
+
+// fakeGitHub is a minimal in-memory GitHub API.
+type fakeGitHub struct {
+	mu     sync.Mutex
+	login  string
+	repos  map[string]fakeRepo
+	syncs  []string
+	forks  []string
+	prs    []fakePullRequest
+	labels map[string][]string // By pull request number
+	// replies to review threads, by the ID of their first comment
+	replies map[string]string
+}
+
+type fakeRepo struct {
+	Name  string `json:"name"`
+	Fork  bool   `json:"fork"`
+	Owner struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+type fakePullRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+}
+
+func newFakeRepo(owner, name string, fork bool) fakeRepo {
+	r := fakeRepo{Name: name, Fork: fork}
+	r.Owner.Login = owner
+	return r
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, forge.Provider) {
+	f := &fakeGitHub{login: "octocat", repos: map[string]fakeRepo{}, labels: map[string][]string{}, replies: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"login": f.login})
+	})
+	mux.HandleFunc("GET /repos/{owner}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		repo, ok := f.repos[r.PathValue("owner")+"/"+r.PathValue("name")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, repo)
+	})
+	mux.HandleFunc("POST /repos/{owner}/{name}/forks", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Organization string `json:"organization"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		owner := req.Organization
+		if owner == "" {
+			owner = f.login
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		name := r.PathValue("name")
+		repo := newFakeRepo(owner, name, true)
+		f.repos[owner+"/"+name] = repo
+		f.forks = append(f.forks, owner+"/"+name)
+		writeJSON(w, http.StatusAccepted, repo)
+	})
+	mux.HandleFunc("POST /repos/{owner}/{name}/merge-upstream", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.syncs = append(f.syncs, r.PathValue("owner")+"/"+r.PathValue("name"))
+		writeJSON(w, http.StatusOK, map[string]string{"merge_type": "fast-forward"})
+	})
+	mux.HandleFunc("POST /repos/{owner}/{name}/pulls", func(w http.ResponseWriter, r *http.Request) {
+		var pr fakePullRequest
+		_ = json.NewDecoder(r.Body).Decode(&pr)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.prs = append(f.prs, pr)
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"number":   len(f.prs),
+			"html_url": "https://github.com/" + r.PathValue("owner") + "/" + r.PathValue("name") + "/pull/1",
+		})
+	})
+	mux.HandleFunc("POST /repos/{owner}/{name}/issues/{number}/labels", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Labels []string `json:"labels"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.labels[r.PathValue("number")] = req.Labels
+		writeJSON(w, http.StatusOK, []any{})
+	})
+	mux.HandleFunc("POST /repos/{owner}/{name}/pulls/{number}/comments/{id}/replies", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Body string `json:"body"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.replies[r.PathValue("id")] = req.Body
+		writeJSON(w, http.StatusCreated, map[string]any{})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return f, github.NewClient(server.URL, "test-token")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestForkSetup(t *testing.T) {
+	upstream := forge.Repo{Host: "github.com", Owner: "org", Name: "repo"}
+
+	t.Run("creates missing fork", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		setup := NewForkSetup(client, testLogger())
+		setup.pollInterval = time.Millisecond
+
+		fork, err := setup.Run(t.Context(), upstream, "", "main")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fork.URL() != "https://github.com/octocat/repo" {
+			t.Errorf("unexpected fork URL %s", fork.URL())
+		}
+		if len(f.forks) != 1 || f.forks[0] != "octocat/repo" {
+			t.Errorf("expected fork octocat/repo to be created, got %v", f.forks)
+		}
+	})
+
+	t.Run("creates fork in organization", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		setup := NewForkSetup(client, testLogger())
+		setup.pollInterval = time.Millisecond
+
+		fork, err := setup.Run(t.Context(), upstream, "my-team", "main")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fork.FullName() != "my-team/repo" || len(f.forks) != 1 || f.forks[0] != "my-team/repo" {
+			t.Errorf("expected fork my-team/repo, got %s, created %v", fork.FullName(), f.forks)
+		}
+	})
+
+	t.Run("syncs existing fork", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		f.repos["octocat/repo"] = newFakeRepo("octocat", "repo", true)
+
+		_, err := NewForkSetup(client, testLogger()).Run(t.Context(), upstream, "", "develop")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(f.forks) != 0 {
+			t.Errorf("expected no new fork, got %v", f.forks)
+		}
+		if len(f.syncs) != 1 || f.syncs[0] != "octocat/repo" {
+			t.Errorf("expected fork to be synced, got %v", f.syncs)
+		}
+	})
+
+	t.Run("refuses non-fork repository with the same name", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		f.repos["octocat/repo"] = newFakeRepo("octocat", "repo", false)
+
+		_, err := NewForkSetup(client, testLogger()).Run(t.Context(), upstream, "", "main")
+		if err == nil || !strings.Contains(err.Error(), "NOT a fork") {
+			t.Fatalf("expected non-fork error, got %v", err)
+		}
+		if len(f.syncs) != 0 {
+			t.Errorf("expected no sync, got %v", f.syncs)
+		}
+	})
+}
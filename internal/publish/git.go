@@ -0,0 +1,74 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// git runs git commands in a repository directory. Config is passed as
+// `-c key=value` to every command, so credentials never end up in
+// .git/config or the global git config.
+//
+// The work dir and the home directory are shared with the agent, so hooks,
+// fsmonitor and the global git config are disabled: they could run the
+// agent's code with the forge credentials.
+type git struct {
+	dir    string
+	gitDir string // Repository outside of dir, which is only its work tree
+	config []string
+	env    []string // Added to the process environment, e.g. GNUPGHOME
+}
+
+var (
+	safeConfig = []string{"core.hooksPath=/dev/null", "core.fsmonitor=false"}
+	safeEnv    = []string{"GIT_CONFIG_GLOBAL=/dev/null"}
+)
+
+func (g *git) run(ctx context.Context, args ...string) (string, error) {
+	var cmdArgs []string
+	if g.gitDir != "" {
+		cmdArgs = append(cmdArgs, "--git-dir="+g.gitDir, "--work-tree=.")
+	}
+	for _, c := range append(safeConfig, g.config...) {
+		cmdArgs = append(cmdArgs, "-c", c)
+	}
+	cmdArgs = append(cmdArgs, args...)
+
+	cmd := exec.CommandContext(ctx, "git", cmdArgs...)
+	cmd.Dir = g.dir
+	cmd.Env = append(append(os.Environ(), safeEnv...), g.env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// Only mention the subcommand, config may contain credentials
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// succeeds runs a git command and reports whether it exited with 0, e.g. for
+// `git diff --quiet`.
+func (g *git) succeeds(ctx context.Context, args ...string) bool {
+	_, err := g.run(ctx, args...)
+	return err == nil
+}
+
+// withAuth returns a copy of g which authenticates https requests to host
+// with basic auth.
+func (g *git) withAuth(host, username, password string) *git {
+	basic := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return &git{
+		dir:    g.dir,
+		gitDir: g.gitDir,
+		config: append(append([]string{}, g.config...), fmt.Sprintf("http.https://%s/.extraheader=AUTHORIZATION: basic %s", host, basic)),
+		env:    g.env,
+	}
+}
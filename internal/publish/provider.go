@@ -0,0 +1,74 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/forge/gitea"
+	"github.com/manno/baca/internal/forge/github"
+	"github.com/manno/baca/internal/forge/gitlab"
+)
+
+// Token returns the token for host, preferring the host specific variable
+// over the forge kind's default, e.g. GITHUB_TOKEN. The default token is
+// only used for the kind's public host, it's never sent to other hosts.
+func Token(kind forge.Kind, host string) string {
+	if token := os.Getenv(forge.HostTokenEnv(kind, host)); token != "" {
+		return token
+	}
+	if host != forge.DefaultHost(kind) {
+		return ""
+	}
+	return os.Getenv(forge.TokenEnv(kind))
+}
+
+// hostToken returns the token from Token, or an error naming the variable
+// to set.
+func hostToken(kind forge.Kind, host string) (string, error) {
+	token := Token(kind, host)
+	if token == "" {
+		name := forge.HostTokenEnv(kind, host)
+		if host == forge.DefaultHost(kind) {
+			name = forge.TokenEnv(kind)
+		}
+		return "", fmt.Errorf("no %s token for %s: set %s", kind, host, name)
+	}
+	return token, nil
+}
+
+// NewProvider returns the forge client for a job that publishes to a fork of
+// upstream in forkOwner. It authenticates with the token from Token, or, on
+// GitHub hosts without a host specific token, as the GitHub App from
+// GITHUB_APP_ID and GITHUB_APP_PRIVATE_KEY if set.
+func NewProvider(ctx context.Context, kind forge.Kind, upstream forge.Repo, forkOwner string) (forge.Provider, error) {
+	host := upstream.Host
+	if forge.TokenEnv(kind) == "" {
+		return nil, fmt.Errorf("unsupported forge %q", kind)
+	}
+	if kind == forge.GitHub {
+		appID := os.Getenv("GITHUB_APP_ID")
+		if appID != "" && os.Getenv(forge.HostTokenEnv(kind, host)) == "" {
+			app, err := github.NewApp(github.BaseURL(host), appID, []byte(os.Getenv("GITHUB_APP_PRIVATE_KEY")))
+			if err != nil {
+				return nil, err
+			}
+			return github.NewAppProvider(ctx, app, upstream, forkOwner)
+		}
+	}
+
+	token, err := hostToken(kind, host)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case forge.GitHub:
+		return github.NewClient(github.BaseURL(host), token), nil
+	case forge.GitLab:
+		return gitlab.NewClient(gitlab.BaseURL(host), token), nil
+	case forge.Gitea:
+		return gitea.NewClient(gitea.BaseURL(host), token), nil
+	}
+	return nil, fmt.Errorf("unsupported forge %q", kind)
+}
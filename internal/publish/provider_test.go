@@ -0,0 +1,32 @@
+package publish
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/forge"
+)
+
+func TestToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "default-token")
+	t.Setenv("GITHUB_TOKEN_GHE_EXAMPLE_COM", "ghe-token")
+
+	if got := Token(forge.GitHub, "ghe.example.com"); got != "ghe-token" {
+		t.Errorf("expected host token, got %q", got)
+	}
+	if got := Token(forge.GitHub, "github.com"); got != "default-token" {
+		t.Errorf("expected default token, got %q", got)
+	}
+	if got := Token(forge.GitHub, "other.example.com"); got != "" {
+		t.Errorf("expected no token for a host without its own, got %q", got)
+	}
+
+	t.Setenv("GITLAB_TOKEN", "gitlab-token")
+	if got := Token(forge.GitLab, "gitlab.example.com"); got != "" {
+		t.Errorf("expected no gitlab.com token for a self-hosted GitLab, got %q", got)
+	}
+	_, err := NewProvider(t.Context(), forge.GitLab, forge.Repo{Host: "gitlab.example.com", Owner: "org", Name: "repo"}, "org")
+	if err == nil || !strings.Contains(err.Error(), "GITLAB_TOKEN_GITLAB_EXAMPLE_COM") {
+		t.Errorf("expected error naming the host token, got %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package publish
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/followup"
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/guardrails"
+	"github.com/manno/baca/internal/metadata"
+	"github.com/manno/baca/internal/secrets"
+)
+
+const (
+	defaultAuthorName  = "BCA Bot"
+	defaultAuthorEmail = "baca@example.com"
+)
+
+// Options describe where to publish the changes in WorkDir.
+type Options struct {
+	WorkDir string // Clone of the fork, with the agent's changes
+	// GitDir is the bare repository cloned by Clone, the changes are
+	// committed there with WorkDir as its work tree. WorkDir's own
+	// repository is writable by the agent and isn't used.
+	GitDir     string
+	Upstream   forge.Repo // Repository the pull request is opened against
+	Fork       forge.Repo // Repository the branch is pushed to
+	BaseBranch string     // Base branch of the pull request
+	// Metadata for the pull request and commit, a template from Prompt and
+	// the diffstat is used if it has no title
+	Metadata metadata.Metadata
+	Prompt   string
+	Commit   CommitOptions
+	// Guardrails are checked again on the staged changes, the agent's job
+	// checks them before generating the PR metadata, which could still
+	// change files.
+	Guardrails change.GuardrailsSpec
+	// Scanner checks the diff and metadata for credentials before anything
+	// is committed, nil disables the check.
+	Scanner *secrets.Scanner
+	// PullRequest is an existing pull request to update instead of opening
+	// a new one. GitDir is a clone of its head branch, BaseBranch, and the
+	// changes are pushed there.
+	PullRequest *forge.PullRequest
+	// ReviewThreads of PullRequest the changes address, they are replied to
+	// with the metadata's summary after the push
+	ReviewThreads []forge.ReviewThread
+}
+
+// CommitOptions control authorship and signing of the commit with the
+// agent's changes.
+type CommitOptions struct {
+	AuthorName  string   // Default: BCA Bot
+	AuthorEmail string   // Default: baca@example.com
+	CoAuthors   []string // "Name <email>", added as Co-authored-by trailers
+	SignOff     bool     // Add a Signed-off-by trailer for the author (DCO)
+	// SigningKey is an OpenSSH or armored GPG private key to sign the
+	// commit with, unsigned if empty.
+	SigningKey string
+}
+
+type Publisher struct {
+	client forge.Provider
+	logger *slog.Logger
+}
+
+func NewPublisher(client forge.Provider, logger *slog.Logger) *Publisher {
+	return &Publisher{
+		client: client,
+		logger: logger,
+	}
+}
+
+// Publish commits all changes in the work dir to a new branch, pushes it to
+// the fork and opens a pull request. With opts.PullRequest the changes are
+// pushed to its head branch instead. It returns nil if the agent made no
+// changes.
+func (p *Publisher) Publish(ctx context.Context, opts Options) (*forge.PullRequest, error) {
+	if opts.GitDir == "" {
+		return nil, fmt.Errorf("no git dir to publish the changes from")
+	}
+	name, email := opts.Commit.AuthorName, opts.Commit.AuthorEmail
+	if name == "" {
+		name = defaultAuthorName
+	}
+	if email == "" {
+		email = defaultAuthorEmail
+	}
+	g := &git{
+		dir:    opts.WorkDir,
+		gitDir: opts.GitDir,
+		config: []string{
+			"user.name=" + name,
+			"user.email=" + email,
+		},
+	}
+
+	branch := opts.BaseBranch
+	if opts.PullRequest == nil {
+		branch = newBranchName()
+	}
+
+	// The bare clone has no index yet. Start from the base branch and stage
+	// everything in the work tree, including new files and the agent's
+	// commits, before checking for changes.
+	if _, err := g.run(ctx, "reset", "-q"); err != nil {
+		return nil, err
+	}
+	if _, err := g.run(ctx, "add", "-A"); err != nil {
+		return nil, err
+	}
+	// The base branch as Clone fetched it from the forge, into a repository
+	// the agent can't write, so it can't hide changes by moving the base
+	base, err := g.run(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	if g.succeeds(ctx, "diff", "--cached", "--quiet") {
+		p.logger.Info("no changes made by agent, skipping PR creation or update")
+		return nil, nil
+	}
+
+	if opts.Metadata.Title == "" {
+		diffstat, err := g.run(ctx, "diff", "--cached", "--no-ext-diff", "--no-textconv", "--stat")
+		if err != nil {
+			p.logger.Warn("failed to compute diffstat", "error", err)
+		}
+		opts.Metadata = metadata.Fallback(opts.Prompt, diffstat)
+		p.logger.Info("no PR metadata from agent, using template", "title", opts.Metadata.Title)
+	}
+
+	if err := p.checkGuardrails(ctx, g, opts.Guardrails); err != nil {
+		return nil, err
+	}
+
+	if err := p.commit(ctx, g, opts); err != nil {
+		return nil, err
+	}
+
+	if opts.Scanner != nil {
+		if err := p.scanForSecrets(ctx, g, base, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	p.logger.Info("pushing branch", "fork", opts.Fork.String(), "branch", branch)
+	username, password := p.client.GitCredentials()
+	if _, err := g.withAuth(opts.Fork.Host, username, password).run(ctx, "push", opts.Fork.URL(), "HEAD:refs/heads/"+branch); err != nil {
+		return nil, err
+	}
+	if opts.PullRequest != nil {
+		p.logger.Info("pull request updated", "url", opts.PullRequest.URL)
+		p.replyToReviewThreads(ctx, g, opts)
+		return opts.PullRequest, nil
+	}
+
+	p.logger.Info("creating pull request", "head", opts.Fork.FullName()+":"+branch, "repo", opts.Upstream.FullName(), "base", opts.BaseBranch)
+	pr, err := p.client.CreatePullRequest(ctx, opts.Upstream, forge.NewPullRequest{
+		Title:      opts.Metadata.Title,
+		Body:       opts.Metadata.Body,
+		Head:       opts.Fork,
+		HeadBranch: branch,
+		Base:       opts.BaseBranch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pull request: %w", err)
+	}
+
+	p.logger.Info("pull request created", "url", pr.URL)
+
+	// Labels are a nicety, the token may lack permission to set them
+	if len(opts.Metadata.Labels) > 0 {
+		if err := p.client.AddLabels(ctx, opts.Upstream, pr.Number, opts.Metadata.Labels); err != nil {
+			p.logger.Warn("failed to add labels", "labels", opts.Metadata.Labels, "error", err)
+		}
+	}
+	return pr, nil
+}
+
+// replyToReviewThreads answers the review threads addressed by the pushed
+// commit. The changes are pushed already, failed replies are only logged.
+func (p *Publisher) replyToReviewThreads(ctx context.Context, g *git, opts Options) {
+	if len(opts.ReviewThreads) == 0 {
+		return
+	}
+	head, err := g.run(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		p.logger.Warn("failed to get pushed commit, not replying to review threads", "error", err)
+		return
+	}
+	summary := opts.Metadata.Body
+	if summary == "" {
+		summary = opts.Metadata.CommitMessage
+	}
+	reply := followup.Reply(strings.TrimSpace(head), summary)
+	for _, thread := range opts.ReviewThreads {
+		if err := p.client.ReplyToReviewThread(ctx, opts.Upstream, opts.PullRequest.Number, thread, reply); err != nil {
+			p.logger.Warn("failed to reply to review thread", "url", thread.URL(), "error", err)
+			continue
+		}
+		p.logger.Info("replied to review thread", "url", thread.URL())
+	}
+}
+
+// checkGuardrails fails if the staged changes violate the guardrails. The
+// agent's job reverts offending files in revert mode, here all violations
+// fail.
+func (p *Publisher) checkGuardrails(ctx context.Context, g *git, spec change.GuardrailsSpec) error {
+	if reflect.DeepEqual(spec, change.GuardrailsSpec{}) {
+		return nil
+	}
+
+	numstat, err := g.run(ctx, "diff", "--cached", "--no-renames", "--numstat", "-z")
+	if err != nil {
+		return fmt.Errorf("failed to collect changes for guardrails: %w", err)
+	}
+	status, err := g.run(ctx, "diff", "--cached", "--no-renames", "--name-status", "-z")
+	if err != nil {
+		return fmt.Errorf("failed to collect changes for guardrails: %w", err)
+	}
+	changes := guardrails.ParseDiff(numstat, status)
+	violations := guardrails.Check(spec, changes)
+	if len(violations) == 0 {
+		p.logger.Info("guardrails passed", "files", len(changes))
+		return nil
+	}
+
+	for _, v := range violations {
+		p.logger.Error("guardrail violated", "path", v.Path, "reason", v.Message)
+	}
+	return &guardrails.ViolationError{Violations: violations}
+}
+
+// scanForSecrets fails if the commits to push, i.e. their changes and
+// messages compared to base, or the PR metadata contain a credential.
+// Findings are reported redacted.
+func (p *Publisher) scanForSecrets(ctx context.Context, g *git, base string, opts Options) error {
+	diff, err := g.run(ctx, "diff", "--no-ext-diff", "--no-textconv", base, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to get diff for secret scanning: %w", err)
+	}
+	messages, err := g.run(ctx, "log", "--format=%B", base+"..HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to get commit messages for secret scanning: %w", err)
+	}
+
+	findings := opts.Scanner.ScanDiff(diff)
+	findings = append(findings, opts.Scanner.Scan("commit message", messages)...)
+	findings = append(findings, opts.Scanner.Scan("PR title", opts.Metadata.Title)...)
+	findings = append(findings, opts.Scanner.Scan("PR body", opts.Metadata.Body)...)
+	if len(findings) == 0 {
+		p.logger.Info("no secrets found in changes")
+		return nil
+	}
+
+	for _, f := range findings {
+		p.logger.Error("possible secret found", "source", f.Source, "line", f.Line, "rule", f.Rule, "match", f.Match)
+	}
+	return &secrets.FindingsError{Findings: findings}
+}
+
+// commit commits the staged changes with the PR metadata as message, and
+// the trailers and signature configured in opts.Commit.
+func (p *Publisher) commit(ctx context.Context, g *git, opts Options) error {
+	message := opts.Metadata.CommitMessage
+	if message == "" {
+		message = opts.Metadata.Title + "\n\n" + opts.Metadata.Body
+	}
+	args := []string{"commit", "-m", message}
+	for _, coAuthor := range opts.Commit.CoAuthors {
+		args = append(args, "--trailer", "Co-authored-by: "+coAuthor)
+	}
+	if opts.Commit.SignOff {
+		args = append(args, "--signoff")
+	}
+
+	if opts.Commit.SigningKey != "" {
+		s, err := newSigner(ctx, opts.Commit.SigningKey)
+		if err != nil {
+			return fmt.Errorf("failed to set up commit signing: %w", err)
+		}
+		defer s.cleanup()
+		g = &git{dir: g.dir, gitDir: g.gitDir, config: append(append([]string{}, g.config...), s.config...), env: s.env}
+		p.logger.Info("signing commit")
+	}
+
+	_, err := g.run(ctx, args...)
+	return err
+}
+
+func newBranchName() string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("baca-%d-%s", time.Now().Unix(), hex.EncodeToString(b))
+}
@@ -0,0 +1,369 @@
+package publish
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/followup"
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/guardrails"
+	"github.com/manno/baca/internal/metadata"
+	"github.com/manno/baca/internal/secrets"
+)
+
+// setupClone creates a bare "fork" repository with one commit on main and
+// clones it like Clone: a bare git dir for publish and a work dir for the
+// agent.
+func setupClone(t *testing.T) (forkDir, workDir, gitDir string) {
+	t.Helper()
+	tmp := t.TempDir()
+	forkDir = filepath.Join(tmp, "fork.git")
+	seedDir := filepath.Join(tmp, "seed")
+	workDir = filepath.Join(tmp, "work")
+
+	gitCmd(t, tmp, "init", "--bare", "-b", "main", forkDir)
+	gitCmd(t, tmp, "init", "-b", "main", seedDir)
+	writeFile(t, filepath.Join(seedDir, "README.md"), "hello\n")
+	gitCmd(t, seedDir, "add", "-A")
+	gitCmd(t, seedDir, "commit", "-m", "initial")
+	gitCmd(t, seedDir, "push", forkDir, "main")
+	gitDir = bareClone(t, forkDir, "main")
+	gitCmd(t, tmp, "clone", "-b", "main", gitDir, workDir)
+	gitCmd(t, workDir, "remote", "set-url", "origin", forkDir)
+
+	return forkDir, workDir, gitDir
+}
+
+// bareClone clones branch of the fork into a git dir for publish, which
+// pushes to the fork's URL instead of the forge.
+func bareClone(t *testing.T, forkDir, branch string) string {
+	t.Helper()
+	gitDir := filepath.Join(t.TempDir(), "repo.git")
+	gitCmd(t, forkDir, "clone", "--bare", "-b", branch, "--single-branch", forkDir, gitDir)
+	fork := forge.Repo{Host: "github.com", Owner: "octocat", Name: "repo"}
+	gitCmd(t, gitDir, "config", "url."+forkDir+".insteadOf", fork.URL())
+	return gitDir
+}
+
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	opts := Options{
+		Upstream:   forge.Repo{Host: "github.com", Owner: "org", Name: "repo"},
+		Fork:       forge.Repo{Host: "github.com", Owner: "octocat", Name: "repo"},
+		BaseBranch: "main",
+		Metadata:   metadata.Metadata{Title: `Handle "quoted" titles`, Body: "Body text"},
+	}
+
+	t.Run("pushes branch and creates pull request", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		forkDir, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		pr, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pr == nil || len(f.prs) != 1 {
+			t.Fatalf("expected a pull request, got %v", f.prs)
+		}
+
+		got := f.prs[0]
+		branch := strings.TrimPrefix(got.Head, "octocat:")
+		if !strings.HasPrefix(branch, "baca-") || got.Base != "main" || got.Title != opts.Metadata.Title {
+			t.Errorf("unexpected pull request: %+v", got)
+		}
+
+		message := gitCmd(t, forkDir, "log", "-1", "--format=%B", branch)
+		if !strings.HasPrefix(message, opts.Metadata.Title+"\n\nBody text") {
+			t.Errorf("unexpected commit message %q", message)
+		}
+		files := gitCmd(t, forkDir, "show", "--name-only", "--format=", branch)
+		if files != "new.go" {
+			t.Errorf("expected new.go to be committed, got %q", files)
+		}
+	})
+
+	t.Run("adds labels and uses the commit message", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		forkDir, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		opts.Metadata.Labels = []string{"dependencies"}
+		opts.Metadata.CommitMessage = "chore: add new.go"
+		if _, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := f.labels["1"]; len(got) != 1 || got[0] != "dependencies" {
+			t.Errorf("expected label on pull request, got %v", f.labels)
+		}
+		branch := strings.TrimPrefix(f.prs[0].Head, "octocat:")
+		if message := gitCmd(t, forkDir, "log", "-1", "--format=%B", branch); message != "chore: add new.go" {
+			t.Errorf("unexpected commit message %q", message)
+		}
+	})
+
+	t.Run("falls back to a template without metadata", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		_, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		opts.Metadata = metadata.Metadata{}
+		opts.Prompt = "Add a main package\n---\nDon't leak secrets."
+		if _, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := f.prs[0]
+		if got.Title != "Add a main package" || !strings.Contains(got.Body, "new.go | 1 +") || strings.Contains(got.Body, "secrets") {
+			t.Errorf("unexpected fallback pull request %+v", got)
+		}
+	})
+
+	t.Run("keeps commits made by the agent", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		_, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "README.md"), "changed\n")
+		gitCmd(t, workDir, "commit", "-am", "agent commit")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		if _, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(f.prs) != 1 {
+			t.Fatalf("expected a pull request, got %v", f.prs)
+		}
+	})
+
+	t.Run("sets author, trailers and ssh signature", func(t *testing.T) {
+		if _, err := exec.LookPath("ssh-keygen"); err != nil {
+			t.Skip("ssh-keygen not installed")
+		}
+		keyPath := filepath.Join(t.TempDir(), "key")
+		if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", keyPath).CombinedOutput(); err != nil {
+			t.Fatalf("ssh-keygen: %v: %s", err, out)
+		}
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, client := newFakeGitHub(t)
+		forkDir, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		opts.Commit = CommitOptions{
+			AuthorName:  "Release Bot",
+			AuthorEmail: "release-bot@example.com",
+			CoAuthors:   []string{"Jane Doe <jane@example.com>"},
+			SignOff:     true,
+			SigningKey:  string(key),
+		}
+		if _, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		branch := gitCmd(t, forkDir, "for-each-ref", "--format=%(refname:short)", "refs/heads/baca-*")
+		if author := gitCmd(t, forkDir, "log", "-1", "--format=%an <%ae>", branch); author != "Release Bot <release-bot@example.com>" {
+			t.Errorf("unexpected author %q", author)
+		}
+		message := gitCmd(t, forkDir, "log", "-1", "--format=%B", branch)
+		if !strings.Contains(message, "Co-authored-by: Jane Doe <jane@example.com>") || !strings.Contains(message, "Signed-off-by: Release Bot <release-bot@example.com>") {
+			t.Errorf("expected trailers in commit message %q", message)
+		}
+		if raw := gitCmd(t, forkDir, "cat-file", "commit", branch); !strings.Contains(raw, "-----BEGIN SSH SIGNATURE-----") {
+			t.Errorf("expected ssh signature in commit:\n%s", raw)
+		}
+	})
+
+	t.Run("aborts if the changes contain a credential", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		forkDir, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "config.yaml"), "token: s3cr3t-value-1234\n")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		opts.Scanner = secrets.NewScanner(map[string]string{"GITHUB_TOKEN": "s3cr3t-value-1234"})
+		_, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts)
+		if err == nil || !strings.Contains(err.Error(), "value of GITHUB_TOKEN") {
+			t.Fatalf("expected secret finding, got %v", err)
+		}
+		if strings.Contains(err.Error(), "s3cr3t-value-1234") {
+			t.Errorf("error leaks the secret: %v", err)
+		}
+		if len(f.prs) != 0 || gitCmd(t, forkDir, "branch", "--list", "baca-*") != "" {
+			t.Errorf("expected nothing to be pushed")
+		}
+	})
+
+	t.Run("finds credentials the agent committed and hid by moving the base", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		_, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "config.yaml"), "token: s3cr3t-value-1234\n")
+		gitCmd(t, workDir, "add", "-A")
+		gitCmd(t, workDir, "commit", "-m", "agent commit")
+		gitCmd(t, workDir, "update-ref", "refs/remotes/origin/main", "HEAD")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		opts.Metadata.CommitMessage = "chore: configure s3cr3t-value-1234"
+		opts.Scanner = secrets.NewScanner(map[string]string{"GITHUB_TOKEN": "s3cr3t-value-1234"})
+		_, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts)
+		var findings *secrets.FindingsError
+		if !errors.As(err, &findings) || len(findings.Findings) != 2 || findings.Findings[0].Source != "diff" || findings.Findings[1].Source != "commit message" {
+			t.Fatalf("expected findings in the diff and commit message, got %v", err)
+		}
+		if len(f.prs) != 0 {
+			t.Errorf("expected nothing to be published")
+		}
+	})
+
+	t.Run("aborts if the changes violate guardrails", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		forkDir, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n")
+		if err := os.MkdirAll(filepath.Join(workDir, ".github", "workflows"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(workDir, ".github", "workflows", "ci.yaml"), "on: push\n")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		opts.Guardrails = change.GuardrailsSpec{ForbiddenPaths: []string{".github/workflows/"}, OnViolation: "revert"}
+		_, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts)
+		var violations *guardrails.ViolationError
+		if !errors.As(err, &violations) || len(violations.Violations) != 1 || violations.Violations[0].Path != ".github/workflows/ci.yaml" {
+			t.Fatalf("expected guardrail violation, got %v", err)
+		}
+		if len(f.prs) != 0 || gitCmd(t, forkDir, "branch", "--list", "baca-*") != "" {
+			t.Errorf("expected nothing to be pushed")
+		}
+	})
+
+	t.Run("pushes to the head branch of an existing pull request", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		forkDir, workDir, _ := setupClone(t)
+		gitCmd(t, workDir, "checkout", "-b", "baca-1")
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n")
+		gitCmd(t, workDir, "add", "-A")
+		gitCmd(t, workDir, "commit", "-m", "agent change")
+		gitCmd(t, workDir, "push", "origin", "baca-1")
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n\nfunc main() {}\n")
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = bareClone(t, forkDir, "baca-1")
+		opts.BaseBranch = "baca-1"
+		opts.PullRequest = &forge.PullRequest{Number: 7, URL: "https://github.com/org/repo/pull/7"}
+		opts.ReviewThreads = []forge.ReviewThread{{ID: "21"}, {ID: "23"}}
+		pr, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pr == nil || pr.Number != 7 || len(f.prs) != 0 {
+			t.Fatalf("expected the pull request to be updated, got %v, created %v", pr, f.prs)
+		}
+		if count := gitCmd(t, forkDir, "rev-list", "--count", "baca-1", "^main"); count != "2" {
+			t.Errorf("expected the fix on top of the head branch, got %s commits", count)
+		}
+		if branches := gitCmd(t, forkDir, "branch", "--list", "baca-*"); branches != "baca-1" {
+			t.Errorf("expected no new branch, got %q", branches)
+		}
+		head := gitCmd(t, forkDir, "rev-parse", "--short=12", "baca-1")
+		for _, id := range []string{"21", "23"} {
+			if reply := f.replies[id]; !strings.HasPrefix(reply, "Updated in "+head+" ") || !strings.HasSuffix(reply, followup.ReplyMarker) {
+				t.Errorf("expected reply to thread %s with the pushed commit, got %q", id, reply)
+			}
+		}
+	})
+
+	t.Run("doesn't run hooks or config planted by the agent", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		_, workDir, gitDir := setupClone(t)
+		writeFile(t, filepath.Join(workDir, "new.go"), "package main\n")
+
+		marker := filepath.Join(t.TempDir(), "ran")
+		hooksDir := filepath.Join(workDir, ".git", "hooks")
+		for _, name := range []string{"pre-commit", "commit-msg", "post-commit", "pre-push", "reference-transaction"} {
+			if err := os.WriteFile(filepath.Join(hooksDir, name), []byte("#!/bin/sh\ntouch "+marker+"\n"), 0700); err != nil {
+				t.Fatal(err)
+			}
+		}
+		gitCmd(t, workDir, "config", "core.fsmonitor", filepath.Join(hooksDir, "pre-commit"))
+		// The home directory is shared with the agent as well
+		home := t.TempDir()
+		writeFile(t, filepath.Join(home, ".gitconfig"), "[core]\n\thooksPath = "+hooksDir+"\n")
+		t.Setenv("HOME", home)
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		if _, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(f.prs) != 1 {
+			t.Fatalf("expected a pull request, got %v", f.prs)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Error("expected the agent's hooks not to run")
+		}
+	})
+
+	t.Run("skips pull request without changes", func(t *testing.T) {
+		f, client := newFakeGitHub(t)
+		_, workDir, gitDir := setupClone(t)
+
+		opts := opts
+		opts.WorkDir = workDir
+		opts.GitDir = gitDir
+		pr, err := NewPublisher(client, testLogger()).Publish(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pr != nil || len(f.prs) != 0 {
+			t.Errorf("expected no pull request, got %v", f.prs)
+		}
+	})
+}
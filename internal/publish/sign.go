@@ -0,0 +1,113 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// signer holds the git config and environment to sign commits with a key
+// from the credentials secret.
+type signer struct {
+	config []string
+	env    []string
+	dir    string // Temporary key material, removed by cleanup
+}
+
+// newSigner prepares signing with key, which is either an armored GPG or an
+// OpenSSH private key without passphrase. The format is detected from the key.
+func newSigner(ctx context.Context, key string) (*signer, error) {
+	dir, err := os.MkdirTemp("", "baca-signing-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing dir: %w", err)
+	}
+	s := &signer{dir: dir}
+
+	switch {
+	case strings.Contains(key, "BEGIN OPENSSH PRIVATE KEY"):
+		err = s.setupSSH(key)
+	case strings.Contains(key, "BEGIN PGP PRIVATE KEY BLOCK"):
+		err = s.setupGPG(ctx, key)
+	default:
+		err = errors.New("signing key is neither an OpenSSH nor an armored GPG private key")
+	}
+	if err != nil {
+		s.cleanup()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *signer) setupSSH(key string) error {
+	path := filepath.Join(s.dir, "signing_key")
+	// ssh-keygen rejects keys without a trailing newline
+	if err := os.WriteFile(path, []byte(strings.TrimSpace(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write signing key: %w", err)
+	}
+	s.config = []string{
+		"gpg.format=ssh",
+		"user.signingkey=" + path,
+		"commit.gpgsign=true",
+	}
+	return nil
+}
+
+func (s *signer) setupGPG(ctx context.Context, key string) error {
+	// A private keyring, so the key never touches the user's GPG home
+	env := []string{"GNUPGHOME=" + s.dir}
+	gpg := func(stdin string, args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "gpg", append([]string{"--batch"}, args...)...)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdin = strings.NewReader(stdin)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("gpg %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), nil
+	}
+
+	if _, err := gpg(key, "--import"); err != nil {
+		return err
+	}
+	out, err := gpg("", "--with-colons", "--list-secret-keys")
+	if err != nil {
+		return err
+	}
+
+	var fingerprint string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, ":")
+		if fields[0] == "fpr" && len(fields) > 9 {
+			fingerprint = fields[9]
+			break
+		}
+	}
+	if fingerprint == "" {
+		return errors.New("no secret key found in signing key")
+	}
+
+	s.config = []string{
+		"gpg.format=openpgp",
+		"user.signingkey=" + fingerprint,
+		"commit.gpgsign=true",
+	}
+	s.env = env
+	return nil
+}
+
+func (s *signer) cleanup() {
+	if s.env != nil {
+		// Stop the gpg-agent started for the private keyring
+		cmd := exec.Command("gpgconf", "--kill", "all")
+		cmd.Env = append(os.Environ(), s.env...)
+		_ = cmd.Run()
+	}
+	_ = os.RemoveAll(s.dir)
+}
@@ -0,0 +1,52 @@
+package publish
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNewSignerGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+
+	// Export an unprotected key from a throwaway keyring
+	home := t.TempDir()
+	gpg := func(args ...string) string {
+		cmd := exec.Command("gpg", append([]string{"--batch", "--pinentry-mode", "loopback", "--passphrase", ""}, args...)...)
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %v: %v", args, err)
+		}
+		return string(out)
+	}
+	gpg("--quick-gen-key", "BACA Test <baca-test@example.com>", "ed25519", "sign", "never")
+	key := gpg("--armor", "--export-secret-keys", "baca-test@example.com")
+	t.Cleanup(func() {
+		cmd := exec.Command("gpgconf", "--kill", "all")
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+		_ = cmd.Run()
+	})
+
+	s, err := newSigner(t.Context(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.cleanup()
+
+	if !strings.HasPrefix(s.config[1], "user.signingkey=") || len(s.config[1]) != len("user.signingkey=")+40 {
+		t.Errorf("expected fingerprint as signing key, got %v", s.config)
+	}
+	if len(s.env) != 1 || !strings.HasPrefix(s.env[0], "GNUPGHOME=") {
+		t.Errorf("expected private GNUPGHOME, got %v", s.env)
+	}
+}
+
+func TestNewSignerInvalidKey(t *testing.T) {
+	if _, err := newSigner(t.Context(), "not a key"); err == nil {
+		t.Error("expected error for unknown key format")
+	}
+}
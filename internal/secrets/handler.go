@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Handler is a slog.Handler which masks credentials in the message and the
+// string, error and stringer attributes of records before passing them on.
+type Handler struct {
+	next    slog.Handler
+	scanner *Scanner
+}
+
+var _ slog.Handler = &Handler{}
+
+// NewHandler wraps next, masking everything scanner finds.
+func NewHandler(next slog.Handler, scanner *Scanner) *Handler {
+	return &Handler{next: next, scanner: scanner}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, h.scanner.Mask(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(h.mask(a))
+		return true
+	})
+	return h.next.Handle(ctx, masked)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		masked = append(masked, h.mask(a))
+	}
+	return &Handler{next: h.next.WithAttrs(masked), scanner: h.scanner}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), scanner: h.scanner}
+}
+
+func (h *Handler) mask(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(h.scanner.Mask(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		masked := make([]slog.Attr, 0, len(group))
+		for _, ga := range group {
+			masked = append(masked, h.mask(ga))
+		}
+		a.Value = slog.GroupValue(masked...)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			a.Value = slog.StringValue(h.scanner.Mask(v.Error()))
+		case fmt.Stringer:
+			a.Value = slog.StringValue(h.scanner.Mask(v.String()))
+		case []string:
+			masked := make([]string, 0, len(v))
+			for _, s := range v {
+				masked = append(masked, h.scanner.Mask(s))
+			}
+			a.Value = slog.AnyValue(masked)
+		}
+	}
+	return a
+}
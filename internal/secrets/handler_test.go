@@ -0,0 +1,31 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	scanner := NewScanner(map[string]string{"GITHUB_TOKEN": "0123456789abcdef"})
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), scanner))
+
+	logger.With("token", "0123456789abcdef").
+		WithGroup("agent").
+		Info("prompt uses 0123456789abcdef",
+			"error", errors.New("auth failed for 0123456789abcdef"),
+			slog.Group("env", "GITHUB_TOKEN", "0123456789abcdef"),
+			"args", []string{"--token", "0123456789abcdef"},
+			"count", 3)
+
+	out := buf.String()
+	if strings.Contains(out, "0123456789abcdef") {
+		t.Fatalf("log leaks the secret: %s", out)
+	}
+	if strings.Count(out, "0123********") != 5 || !strings.Contains(out, `"count":3`) {
+		t.Errorf("unexpected log output: %s", out)
+	}
+}
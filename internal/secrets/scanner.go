@@ -0,0 +1,203 @@
+// Package secrets finds credentials in text the agent produced, before it is
+// pushed or posted to a forge.
+package secrets
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// minValueLength skips short credential values, e.g. an app ID, which would
+// match unrelated text.
+const minValueLength = 8
+
+// patterns match well-known token formats, also if they are not in the
+// credentials secret.
+var patterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"github-fine-grained-token", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`)},
+	{"gitlab-token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+}
+
+// Finding is a match, it never contains the secret itself.
+type Finding struct {
+	Source string // e.g. "diff" or "PR body"
+	Line   int
+	Rule   string // Credential name or pattern name
+	Match  string // Redacted match
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.Source, f.Line, f.Rule, f.Match)
+}
+
+// Scanner looks for the literal values of credentials and token patterns.
+// It is safe for concurrent use.
+type Scanner struct {
+	values map[string]string // Value to credential name
+	// ordered has the values longest first, so a finding names the most
+	// specific credential
+	ordered []string
+}
+
+// NewScanner returns a scanner for the credential values, keyed by name.
+// Multi-line values, e.g. private keys, are also matched line by line.
+func NewScanner(credentials map[string]string) *Scanner {
+	s := &Scanner{values: map[string]string{}}
+	for name, value := range credentials {
+		s.add(name, strings.TrimSpace(value))
+		if strings.Contains(value, "\n") {
+			for _, line := range strings.Split(value, "\n") {
+				// Skip PEM headers, they are covered by the private-key pattern
+				if line = strings.TrimSpace(line); !strings.HasPrefix(line, "-----") {
+					s.add(name, line)
+				}
+			}
+		}
+	}
+	for value := range s.values {
+		s.ordered = append(s.ordered, value)
+	}
+	sort.Slice(s.ordered, func(i, j int) bool { return len(s.ordered[i]) > len(s.ordered[j]) })
+	return s
+}
+
+func (s *Scanner) add(name, value string) {
+	if len(value) >= minValueLength {
+		s.values[value] = name
+	}
+}
+
+// LoadDir reads the credentials from a mounted secret, one file per key.
+// Kubernetes' hidden ..data links are skipped.
+func LoadDir(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	credentials := map[string]string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credential %s: %w", entry.Name(), err)
+		}
+		credentials[entry.Name()] = string(data)
+	}
+	return credentials, nil
+}
+
+// credentialEnv matches the names of environment variables holding
+// credentials, e.g. GITHUB_TOKEN or GEMINI_API_KEY.
+var credentialEnv = regexp.MustCompile(`TOKEN|KEY|SECRET|PASSWORD|CREDENTIAL`)
+
+// LoadEnv returns the environment variables which look like credentials.
+func LoadEnv() map[string]string {
+	credentials := map[string]string{}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if credentialEnv.MatchString(strings.ToUpper(name)) {
+			credentials[name] = value
+		}
+	}
+	return credentials
+}
+
+// Scan returns all findings in text.
+func (s *Scanner) Scan(source, text string) []Finding {
+	return s.scan(source, text, func(string) bool { return true })
+}
+
+// ScanDiff returns the findings in lines a unified diff adds, secrets the
+// agent removed are not reported.
+func (s *Scanner) ScanDiff(diff string) []Finding {
+	return s.scan("diff", diff, func(line string) bool {
+		return strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++ ")
+	})
+}
+
+func (s *Scanner) scan(source, text string, include func(string) bool) []Finding {
+	var findings []Finding
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if !include(line) {
+			continue
+		}
+
+		found := false
+		for _, value := range s.ordered {
+			if strings.Contains(line, value) {
+				findings = append(findings, Finding{Source: source, Line: n, Rule: "value of " + s.values[value], Match: Redact(value)})
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		for _, p := range patterns {
+			if m := p.re.FindString(line); m != "" {
+				findings = append(findings, Finding{Source: source, Line: n, Rule: p.name, Match: Redact(m)})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// Mask replaces all credential values and token patterns in text with their
+// redacted form.
+func (s *Scanner) Mask(text string) string {
+	for _, value := range s.ordered {
+		if strings.Contains(text, value) {
+			text = strings.ReplaceAll(text, value, Redact(value))
+		}
+	}
+	for _, p := range patterns {
+		text = p.re.ReplaceAllStringFunc(text, Redact)
+	}
+	return text
+}
+
+// Redact keeps a short prefix of a secret to help identify it.
+func Redact(secret string) string {
+	prefix := 4
+	if len(secret) < 12 {
+		prefix = 0
+	}
+	return secret[:prefix] + strings.Repeat("*", 8)
+}
+
+// FindingsError is returned when changes contain possible secrets.
+type FindingsError struct {
+	Findings []Finding
+}
+
+func (e *FindingsError) Error() string {
+	return fmt.Sprintf("found %d possible secrets, nothing was pushed:\n%s", len(e.Findings), Report(e.Findings))
+}
+
+// Report formats findings for logs and errors.
+func Report(findings []Finding) string {
+	lines := make([]string, 0, len(findings))
+	for _, f := range findings {
+		lines = append(lines, "  - "+f.String())
+	}
+	return strings.Join(lines, "\n")
+}
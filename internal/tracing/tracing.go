@@ -0,0 +1,121 @@
+// Package tracing exports OpenTelemetry traces of apply and the job phases
+// via OTLP/HTTP. The trace context is passed into the job containers in the
+// TRACEPARENT environment variable, so their spans join the trace of apply.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TraceParentEnv and TraceStateEnv carry the W3C trace context into job
+	// containers
+	TraceParentEnv = "TRACEPARENT"
+	TraceStateEnv  = "TRACESTATE"
+	// EndpointEnv configures the exporter in the job containers, it's the
+	// standard OTLP variable
+	EndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+)
+
+const instrumentation = "github.com/manno/baca"
+
+// Config is read from the `tracing` key of the config file.
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318
+	Endpoint string `mapstructure:"endpoint"`
+	// JobEndpoint is the collector URL reachable from the jobs' pods,
+	// defaults to Endpoint
+	JobEndpoint string `mapstructure:"jobEndpoint"`
+	// Headers are sent with each export, e.g. for authentication. They
+	// aren't passed to the jobs.
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// JobEndpointOrDefault returns the collector URL for the jobs.
+func (c Config) JobEndpointOrDefault() string {
+	if c.JobEndpoint != "" {
+		return c.JobEndpoint
+	}
+	return c.Endpoint
+}
+
+// Setup installs the global tracer provider exporting to the configured
+// endpoint, or to the endpoint in the OTLP environment variables. Without
+// an endpoint tracing stays disabled. The returned function flushes the
+// spans, it must be called before exiting.
+func Setup(ctx context.Context, cfg Config, service string) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	var opts []otlptracehttp.Option
+	switch {
+	case cfg.Endpoint != "":
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	case os.Getenv(EndpointEnv) == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "":
+		return noop, nil
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return noop, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// Start starts a span with the global tracer provider.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Env returns the environment variables passing the trace context of ctx to
+// a job container. It's empty if ctx has no sampled span.
+func Env(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	env := map[string]string{}
+	for _, key := range carrier.Keys() {
+		env[strings.ToUpper(key)] = carrier.Get(key)
+	}
+	return env
+}
+
+// FromEnv returns ctx with the trace context from the environment, as passed
+// by Env.
+func FromEnv(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+	for _, key := range []string{TraceParentEnv, TraceStateEnv} {
+		if value := os.Getenv(key); value != "" {
+			carrier.Set(strings.ToLower(key), value)
+		}
+	}
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEnv(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	t.Setenv(TraceParentEnv, traceParent)
+	t.Setenv(TraceStateEnv, "")
+
+	ctx := FromEnv(context.Background())
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsRemote() || sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !sc.IsSampled() {
+		t.Fatalf("unexpected span context %+v", sc)
+	}
+	if env := Env(ctx); env[TraceParentEnv] != traceParent {
+		t.Errorf("expected %s=%s, got %v", TraceParentEnv, traceParent, env)
+	}
+
+	t.Setenv(TraceParentEnv, "")
+	if env := Env(FromEnv(context.Background())); len(env) != 0 {
+		t.Errorf("expected no trace context without a span, got %v", env)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	t.Setenv(EndpointEnv, "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	shutdown, err := Setup(t.Context(), Config{}, "baca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if otel.GetTracerProvider() != previous {
+		t.Error("expected tracing to stay disabled without an endpoint")
+	}
+
+	var mu sync.Mutex
+	var requests []*http.Request
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	shutdown, err = Setup(t.Context(), Config{Endpoint: collector.URL, Headers: map[string]string{"Authorization": "Bearer token"}}, "baca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := Start(context.Background(), "apply")
+	if env := Env(ctx); env[TraceParentEnv] == "" {
+		t.Errorf("expected trace context for the jobs, got %v", env)
+	}
+	End(span, nil)
+	if err := shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || requests[0].URL.Path != "/v1/traces" || requests[0].Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("expected one export to /v1/traces, got %d", len(requests))
+	}
+}
@@ -10,7 +10,10 @@ import (
 
 	"github.com/manno/baca/internal/backend/k8s"
 	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/internal/tracing"
 	"github.com/manno/baca/tests/utils"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -66,7 +69,7 @@ var _ = Describe("Backend Apply", func() {
 			credentials["GEMINI_API_KEY"] = geminiKey
 		}
 
-		err = b.Setup(ctx, credentials)
+		err = b.Setup(ctx, credentials, nil)
 		Expect(err).NotTo(HaveOccurred())
 	})
 
@@ -90,7 +93,7 @@ var _ = Describe("Backend Apply", func() {
 				},
 			}
 
-			err := b.ApplyChange(ctx, ch, false, 0, "")
+			_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{})
 			Expect(err).NotTo(HaveOccurred())
 
 			jobList := &batchv1.JobList{}
@@ -119,7 +122,7 @@ var _ = Describe("Backend Apply", func() {
 				},
 			}
 
-			err := b.ApplyChange(ctx, ch, false, 0, "")
+			_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{})
 			Expect(err).NotTo(HaveOccurred())
 
 			jobList := &batchv1.JobList{}
@@ -146,7 +149,7 @@ var _ = Describe("Backend Apply", func() {
 				},
 			}
 
-			err := b.ApplyChange(ctx, ch, false, 0, "")
+			_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{})
 			Expect(err).NotTo(HaveOccurred())
 
 			jobList := &batchv1.JobList{}
@@ -170,7 +173,7 @@ var _ = Describe("Backend Apply", func() {
 				},
 			}
 
-			err := b.ApplyChange(ctx, ch, false, 0, "")
+			_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{})
 			Expect(err).NotTo(HaveOccurred())
 
 			jobList := &batchv1.JobList{}
@@ -197,7 +200,7 @@ var _ = Describe("Backend Apply", func() {
 				},
 			}
 
-			err := b.ApplyChange(ctx, ch, false, 0, "test-org")
+			_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{ForkOrg: "test-org"})
 			Expect(err).NotTo(HaveOccurred())
 
 			jobList := &batchv1.JobList{}
@@ -207,7 +210,7 @@ var _ = Describe("Backend Apply", func() {
 
 			// Check fork-setup init container has FORK_ORG env var
 			job := jobList.Items[0]
-			Expect(job.Spec.Template.Spec.InitContainers).To(HaveLen(2))
+			Expect(job.Spec.Template.Spec.InitContainers).To(HaveLen(3))
 			forkSetupContainer := job.Spec.Template.Spec.InitContainers[0]
 			Expect(forkSetupContainer.Name).To(Equal("fork-setup"))
 
@@ -237,7 +240,7 @@ var _ = Describe("Backend Apply", func() {
 				},
 			}
 
-			err := b.ApplyChange(ctx, ch, false, 0, "")
+			_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{})
 			Expect(err).NotTo(HaveOccurred())
 
 			jobList := &batchv1.JobList{}
@@ -261,5 +264,64 @@ var _ = Describe("Backend Apply", func() {
 			Expect(foundForkOrg).To(BeTrue(), "FORK_ORG environment variable should be set")
 			Expect(forkOrgValue).To(Equal(""))
 		})
+
+		It("passes the trace context of apply to the job containers", func() {
+			previous := otel.GetTracerProvider()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider())
+			DeferCleanup(func() { otel.SetTracerProvider(previous) })
+
+			ch := &change.Change{
+				APIVersion: "v1",
+				Kind:       "Change",
+				Spec: change.ChangeSpec{
+					Prompt: "Add tests",
+					Repos: []string{
+						"https://github.com/example/repo1",
+					},
+					Agent: "copilot-cli",
+				},
+			}
+
+			traceCtx, span := tracing.Start(ctx, "test")
+			defer span.End()
+			_, err := b.ApplyChange(traceCtx, ch, k8s.ApplyOptions{TracingEndpoint: "http://otel-collector.monitoring:4318"})
+			Expect(err).NotTo(HaveOccurred())
+
+			jobList := &batchv1.JobList{}
+			err = k8sClient.List(ctx, jobList, client.InNamespace(namespace))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobList.Items).To(HaveLen(1))
+
+			spec := jobList.Items[0].Spec.Template.Spec
+			traceID := span.SpanContext().TraceID().String()
+			for _, container := range append(spec.InitContainers, spec.Containers...) {
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: tracing.EndpointEnv, Value: "http://otel-collector.monitoring:4318"}))
+				Expect(container.Env).To(ContainElement(HaveField("Value", ContainSubstring(traceID))), "TRACEPARENT should continue the trace in %s", container.Name)
+			}
+		})
+
+		It("does not persist jobs in server dry-run mode", func() {
+			ch := &change.Change{
+				APIVersion: "v1",
+				Kind:       "Change",
+				Spec: change.ChangeSpec{
+					Prompt: "Add tests",
+					Repos: []string{
+						"https://github.com/example/repo1",
+						"https://github.com/example/repo2",
+					},
+					Agent: "copilot-cli",
+				},
+			}
+
+			jobs, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{DryRun: k8s.DryRunServer})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobs).To(HaveLen(2))
+
+			jobList := &batchv1.JobList{}
+			err = k8sClient.List(ctx, jobList, client.InNamespace(namespace))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobList.Items).To(BeEmpty())
+		})
 	})
 })
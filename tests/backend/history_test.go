@@ -0,0 +1,113 @@
+package backend_test
+
+import (
+	"io"
+	"log/slog"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/manno/baca/internal/backend/k8s"
+	"github.com/manno/baca/internal/change"
+	"github.com/manno/baca/tests/utils"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Run History", func() {
+	var b *k8s.KubernetesBackend
+	var ch *change.Change
+
+	BeforeEach(func() {
+		var err error
+		namespace, err = utils.NewNamespaceName()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(k8sClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespace,
+			},
+		})).ToNot(HaveOccurred())
+
+		DeferCleanup(func() {
+			Expect(k8sClient.Delete(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: namespace,
+				},
+			})).ToNot(HaveOccurred())
+		})
+
+		b, err = k8s.New(cfg, namespace, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Setup(ctx, map[string]string{"GITHUB_TOKEN": "test-github-token"}, nil)).To(Succeed())
+
+		ch = &change.Change{
+			APIVersion: "v1",
+			Kind:       "Change",
+			Metadata:   change.Metadata{Name: "add-tests"},
+			Spec: change.ChangeSpec{
+				Prompt: "Add tests",
+				Repos: []string{
+					"https://github.com/example/repo1",
+					"https://github.com/example/repo2",
+				},
+				Agent: "copilot-cli",
+			},
+		}
+	})
+
+	It("records each apply as a run", func() {
+		jobs, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		runs, err := b.History(ctx, k8s.HistoryFilter{Change: "add-tests"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(HaveLen(1))
+		run := runs[0]
+		Expect(run.ChangeHash).To(Equal(k8s.ChangeHash(ch)))
+		Expect(run.SubmittedBy).NotTo(BeEmpty())
+		Expect(run.Finished).To(BeNil())
+		Expect(run.Repos).To(HaveLen(2))
+		for i, repo := range run.Repos {
+			Expect(repo.Repo).To(Equal(ch.Spec.Repos[i]))
+			Expect(repo.Job).To(Equal(jobs[i].Name))
+			Expect(repo.Outcome).To(Equal(k8s.OutcomeRunning))
+		}
+
+		jobList := &batchv1.JobList{}
+		Expect(k8sClient.List(ctx, jobList, client.InNamespace(namespace), client.MatchingLabels{"baca.io/run": run.ID})).To(Succeed())
+		Expect(jobList.Items).To(HaveLen(2))
+		for _, job := range jobList.Items {
+			// Kept for a week, so the outcome can still be recorded
+			Expect(*job.Spec.TTLSecondsAfterFinished).To(BeNumerically("==", 7*24*60*60))
+		}
+
+		Expect(b.History(ctx, k8s.HistoryFilter{Change: "other"})).To(BeEmpty())
+		Expect(b.History(ctx, k8s.HistoryFilter{Repo: "example/repo2", Outcome: k8s.OutcomeRunning})).To(HaveLen(1))
+	})
+
+	It("records jobs deleted without an outcome as unknown", func() {
+		_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		runs, err := b.History(ctx, k8s.HistoryFilter{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(HaveLen(1))
+
+		Expect(k8sClient.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace(namespace))).To(Succeed())
+
+		run, err := b.GetRun(ctx, runs[0].ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(run.Finished).NotTo(BeNil())
+		for _, repo := range run.Repos {
+			Expect(repo.Outcome).To(Equal(k8s.OutcomeUnknown))
+		}
+	})
+
+	It("does not record dry runs", func() {
+		_, err := b.ApplyChange(ctx, ch, k8s.ApplyOptions{DryRun: k8s.DryRunServer})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.History(ctx, k8s.HistoryFilter{})).To(BeEmpty())
+	})
+})
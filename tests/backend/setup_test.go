@@ -3,6 +3,7 @@ package backend_test
 import (
 	"io"
 	"log/slog"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -11,6 +12,7 @@ import (
 	"github.com/manno/baca/tests/utils"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -56,7 +58,7 @@ var _ = Describe("Backend Setup", func() {
 				"GITHUB_TOKEN":   "test-github-token",
 				"GOOGLE_API_KEY": "test-google-key",
 			}
-			err = b.Setup(ctx, credentials)
+			err = b.Setup(ctx, credentials, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			secret := &corev1.Secret{}
@@ -76,7 +78,7 @@ var _ = Describe("Backend Setup", func() {
 			credentials := map[string]string{
 				"GITHUB_TOKEN": "initial-token",
 			}
-			err = b.Setup(ctx, credentials)
+			err = b.Setup(ctx, credentials, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			updatedCredentials := map[string]string{
@@ -84,7 +86,7 @@ var _ = Describe("Backend Setup", func() {
 				"COPILOT_TOKEN":  "new-copilot-token",
 				"GEMINI_API_KEY": "new-gemini-key",
 			}
-			err = b.Setup(ctx, updatedCredentials)
+			err = b.Setup(ctx, updatedCredentials, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			secret := &corev1.Secret{}
@@ -99,6 +101,106 @@ var _ = Describe("Backend Setup", func() {
 		})
 	})
 
+	When("Setting up a profile", func() {
+		It("annotates the profile's secret with its owner", func() {
+			b, err := k8s.New(cfg, namespace, logger)
+			Expect(err).NotTo(HaveOccurred())
+			identity, err := b.Identity(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(b.WithProfile("alice").Setup(ctx, map[string]string{"GITHUB_TOKEN": "token"}, nil)).To(Succeed())
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "baca-credentials-alice", Namespace: namespace}, secret)).To(Succeed())
+			Expect(secret.Annotations).To(HaveKeyWithValue("baca.io/submitted-by", identity))
+		})
+
+		It("refuses to update a profile owned by another user", func() {
+			Expect(k8sClient.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "baca-credentials-bob",
+					Namespace:   namespace,
+					Annotations: map[string]string{"baca.io/submitted-by": "bob"},
+				},
+			})).To(Succeed())
+
+			b, err := k8s.New(cfg, namespace, logger)
+			Expect(err).NotTo(HaveOccurred())
+			err = b.WithProfile("bob").Setup(ctx, map[string]string{"GITHUB_TOKEN": "token"}, nil)
+			Expect(err).To(MatchError(ContainSubstring("belongs to bob")))
+			_, err = b.Rotate(ctx, map[string]string{"GITHUB_TOKEN": "token"}, nil)
+			Expect(err).To(MatchError(ContainSubstring("belongs to bob")))
+		})
+	})
+
+	When("Storing credentials after references", func() {
+		It("drops the references of the stored credentials", func() {
+			b, err := k8s.New(cfg, namespace, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.SetupCredentialRefs(ctx, &k8s.CredentialRefs{Secrets: map[string]k8s.SecretKeyRef{
+				"GITHUB_TOKEN":   {Name: "team-secrets", Key: "github"},
+				"GEMINI_API_KEY": {Name: "team-secrets", Key: "gemini"},
+			}})).To(Succeed())
+
+			Expect(b.ForgetCredentialRefs(ctx, []string{"GITHUB_TOKEN"})).To(Succeed())
+			cm := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "baca-credential-refs", Namespace: namespace}, cm)).To(Succeed())
+			Expect(cm.Data["refs.json"]).NotTo(ContainSubstring("GITHUB_TOKEN"))
+			Expect(cm.Data["refs.json"]).To(ContainSubstring("GEMINI_API_KEY"))
+
+			Expect(b.ForgetCredentialRefs(ctx, []string{"GEMINI_API_KEY"})).To(Succeed())
+			err = k8sClient.Get(ctx, client.ObjectKey{Name: "baca-credential-refs", Namespace: namespace}, cm)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("refuses references to another profile's credentials", func() {
+			b, err := k8s.New(cfg, namespace, logger)
+			Expect(err).NotTo(HaveOccurred())
+			err = b.WithProfile("alice").SetupCredentialRefs(ctx, &k8s.CredentialRefs{Secrets: map[string]k8s.SecretKeyRef{
+				"GITHUB_TOKEN": {Name: "baca-credentials-bob", Key: "GITHUB_TOKEN"},
+			}})
+			Expect(err).To(MatchError(ContainSubstring("another profile")))
+		})
+	})
+
+	When("Rotating credentials", func() {
+		It("replaces single keys and keys holding the same value", func() {
+			b, err := k8s.New(cfg, namespace, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			expires := time.Now().Add(3 * 24 * time.Hour).Truncate(time.Second)
+			err = b.Setup(ctx, map[string]string{
+				"GITHUB_TOKEN":   "old-token",
+				"COPILOT_TOKEN":  "old-token",
+				"GEMINI_API_KEY": "gemini-key",
+			}, map[string]time.Time{"GITHUB_TOKEN": expires, "COPILOT_TOKEN": expires})
+			Expect(err).NotTo(HaveOccurred())
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "baca-credentials", Namespace: namespace}, secret)).To(Succeed())
+			Expect(secret.Annotations).To(HaveKeyWithValue("expires-at.baca.io/GITHUB_TOKEN", expires.UTC().Format(time.RFC3339)))
+			Expect(secret.Annotations).To(HaveKey("created-at.baca.io/GEMINI_API_KEY"))
+
+			keys, err := b.Rotate(ctx, map[string]string{"GITHUB_TOKEN": "new-token"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(Equal([]string{"COPILOT_TOKEN", "GITHUB_TOKEN"}))
+
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "baca-credentials", Namespace: namespace}, secret)).To(Succeed())
+			Expect(string(secret.Data["GITHUB_TOKEN"])).To(Equal("new-token"))
+			Expect(string(secret.Data["COPILOT_TOKEN"])).To(Equal("new-token"))
+			Expect(string(secret.Data["GEMINI_API_KEY"])).To(Equal("gemini-key"))
+			Expect(secret.Annotations).NotTo(HaveKey("expires-at.baca.io/GITHUB_TOKEN"))
+		})
+
+		It("fails without credentials", func() {
+			b, err := k8s.New(cfg, namespace, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = b.Rotate(ctx, map[string]string{"GITHUB_TOKEN": "new-token"}, nil)
+			Expect(err).To(MatchError(ContainSubstring("run 'baca setup' first")))
+		})
+	})
+
 	When("Using GetConfig", func() {
 		It("can get config from kubeconfig file", func() {
 			cfg2, err := k8s.GetConfig(kubeconfigPath)
@@ -0,0 +1,109 @@
+package forge_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/manno/baca/internal/forge"
+	"github.com/manno/baca/internal/forge/gitea"
+	"github.com/manno/baca/internal/publish"
+	"github.com/manno/baca/tests/utils"
+)
+
+// giteaAPI sends a request to the Gitea API to prepare test data.
+func giteaAPI(method, path string, body any) {
+	data, err := json.Marshal(body)
+	Expect(err).NotTo(HaveOccurred())
+
+	req, err := http.NewRequestWithContext(ctx, method, giteaURL+"/api/v1"+path, bytes.NewReader(data))
+	Expect(err).NotTo(HaveOccurred())
+	req.Header.Set("Authorization", "token "+giteaToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	Expect(err).NotTo(HaveOccurred())
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	Expect(resp.StatusCode).To(BeNumerically("<", 300), "%s %s: %s", method, path, msg)
+}
+
+var _ = Describe("Gitea provider", func() {
+	var (
+		client   *gitea.Client
+		setup    *publish.ForkSetup
+		org      string
+		upstream forge.Repo
+	)
+
+	BeforeEach(func() {
+		if giteaURL == "" || giteaToken == "" {
+			Skip("GITEA_URL and GITEA_TOKEN not set, start a Gitea with dev/setup-gitea")
+		}
+
+		u, err := url.Parse(giteaURL)
+		Expect(err).NotTo(HaveOccurred())
+
+		client = gitea.NewClient(giteaURL+"/api/v1", giteaToken)
+		setup = publish.NewForkSetup(client, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+		org, err = utils.NewNamespaceName()
+		Expect(err).NotTo(HaveOccurred())
+		giteaAPI(http.MethodPost, "/orgs", map[string]string{"username": org})
+		giteaAPI(http.MethodPost, fmt.Sprintf("/orgs/%s/repos", org), map[string]any{"name": "demo", "auto_init": true, "default_branch": "main"})
+		upstream = forge.Repo{Host: u.Host, Owner: org, Name: "demo"}
+
+		DeferCleanup(func() {
+			login, err := client.CurrentUser(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			giteaAPI(http.MethodDelete, fmt.Sprintf("/repos/%s/demo", login), nil)
+			giteaAPI(http.MethodDelete, fmt.Sprintf("/repos/%s/demo", org), nil)
+			giteaAPI(http.MethodDelete, "/orgs/"+org, nil)
+		})
+	})
+
+	It("creates a fork, syncs it and opens a pull request", func() {
+		fork, err := setup.Run(ctx, upstream, "", "main")
+		Expect(err).NotTo(HaveOccurred())
+
+		repo, err := client.GetRepository(ctx, fork)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.Fork).To(BeTrue())
+
+		// Second run finds and syncs the existing fork
+		_, err = setup.Run(ctx, upstream, "", "main")
+		Expect(err).NotTo(HaveOccurred())
+
+		giteaAPI(http.MethodPost, fmt.Sprintf("/repos/%s/contents/baca.txt", fork.FullName()), map[string]string{
+			"content":    "YmFjYQo=",
+			"message":    "Add baca.txt",
+			"new_branch": "baca-test",
+		})
+
+		pr, err := client.CreatePullRequest(ctx, upstream, forge.NewPullRequest{
+			Title:      "Add baca.txt",
+			Body:       "Created by the forge integration tests",
+			Head:       fork,
+			HeadBranch: "baca-test",
+			Base:       "main",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pr.Number).To(BeNumerically(">", 0))
+
+		Expect(client.EditPullRequest(ctx, upstream, pr.Number, forge.PullRequestEdit{Title: "Add baca.txt (edited)"})).To(Succeed())
+	})
+
+	It("refuses a non-fork repository with the same name", func() {
+		giteaAPI(http.MethodPost, "/user/repos", map[string]any{"name": "demo", "auto_init": true})
+
+		_, err := setup.Run(ctx, upstream, "", "main")
+		Expect(err).To(MatchError(ContainSubstring("NOT a fork")))
+	})
+})
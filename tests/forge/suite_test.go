@@ -0,0 +1,35 @@
+package forge_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var (
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	giteaURL   string
+	giteaToken string
+)
+
+func TestForge(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Forge Integration Suite")
+}
+
+var _ = BeforeSuite(func() {
+	ctx, cancel = context.WithCancel(context.TODO())
+
+	// Started by dev/setup-gitea
+	giteaURL = os.Getenv("GITEA_URL")
+	giteaToken = os.Getenv("GITEA_TOKEN")
+})
+
+var _ = AfterSuite(func() {
+	cancel()
+})